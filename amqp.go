@@ -0,0 +1,186 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// How long an AMQPOutput waits to connect to the broker again after failing
+// to, at first (doubling each time it fails again, up to
+// maxFailoverBackoff); a variable so tests can shorten it.
+var amqpRetryDelay = time.Second
+
+// amqpChannel is what an AMQPOutput publishes on: a channel, closing its
+// connection along with it. Tests stand in their own.
+type amqpChannel interface {
+	PublishWithContext(ctx context.Context, exchange string, key string, mandatory bool, immediate bool, msg amqp.Publishing) error
+	Close() error
+}
+
+// amqpConnection is an amqpChannel on a connection of its own.
+type amqpConnection struct {
+	*amqp.Channel
+	conn *amqp.Connection
+}
+
+func (c amqpConnection) Close() error {
+	return c.conn.Close()
+}
+
+// amqpMessage is an entry waiting to be published, with its routing key.
+type amqpMessage struct {
+	key        string
+	publishing amqp.Publishing
+}
+
+// AMQPOutput publishes entries, as JSON objects of their journal fields, to
+// an AMQP 0-9-1 exchange (as RabbitMQ has), routed by their facility and
+// severity, as "local4.warning". Like a Relay, it publishes from a queue, so
+// that a slow or missing broker doesn't hold up anything else.
+type AMQPOutput struct {
+	url      string
+	exchange string
+	dial     func() (amqpChannel, error)
+
+	queue  chan amqpMessage
+	done   chan struct{}
+	mu     sync.Mutex
+	closed bool
+}
+
+func init() {
+	RegisterSink("amqp", func() (Sink, error) {
+		if *amqpURL == "" {
+			return nil, nil
+		}
+		return NewAMQPOutput(*amqpURL, *amqpExchange)
+	})
+}
+
+// NewAMQPOutput returns an AMQPOutput to the broker at an amqp:// or amqps://
+// URL, publishing to exchange, and starts it publishing. The exchange must
+// already exist; a topic exchange lets consumers bind to keys like
+// "auth.*" or "*.err".
+func NewAMQPOutput(server string, exchange string) (*AMQPOutput, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "amqp" && u.Scheme != "amqps") || u.Host == "" {
+		return nil, fmt.Errorf("bad AMQP URL %q; expected amqp://HOST[:PORT][/VHOST] or amqps://...", u.Redacted())
+	}
+	o := &AMQPOutput{url: server, exchange: exchange, queue: make(chan amqpMessage, relayQueueSize), done: make(chan struct{})}
+	o.dial = o.connect
+	go o.run(u.Redacted())
+	return o, nil
+}
+
+// Write queues a message for the exchange, dropping it if the queue is full.
+func (o *AMQPOutput) Write(msg *SyslogMessage) error {
+	body, err := entryJSON(msg.Message, int(msg.Priority()), msg.Entry)
+	if err != nil {
+		return err
+	}
+	message := amqpMessage{routingKey(msg), amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		Timestamp:    msg.Timestamp,
+		AppId:        "journald-syslog",
+		Body:         body,
+	}}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return fmt.Errorf("AMQP output to %s is closed", o.exchange)
+	}
+	select {
+	case o.queue <- message:
+		return nil
+	default:
+		return fmt.Errorf("dropped a message for AMQP exchange %s, which isn't keeping up", o.exchange)
+	}
+}
+
+// Flush does nothing: messages are published as soon as they can be.
+func (o *AMQPOutput) Flush() error {
+	return nil
+}
+
+// Close stops the output once it's published the messages queued, or given
+// up on them after relayCloseTimeout.
+func (o *AMQPOutput) Close() error {
+	o.mu.Lock()
+	if !o.closed {
+		o.closed = true
+		close(o.queue)
+	}
+	o.mu.Unlock()
+	select {
+	case <-o.done:
+		return nil
+	case <-time.After(relayCloseTimeout):
+		return fmt.Errorf("gave up publishing %d messages to %s", len(o.queue), o.exchange)
+	}
+}
+
+// run publishes queued messages, connecting to the broker (named in logs by
+// its URL without a password) when it needs to. While the broker can't be
+// reached, messages wait in the queue, and connecting is tried again after
+// a backoff; the outage is logged once, when it starts. A message which
+// can't be published is logged and dropped, and the connection made again
+// for the next.
+func (o *AMQPOutput) run(name string) {
+	defer close(o.done)
+	var channel amqpChannel
+	down := false
+	for message := range o.queue {
+		for delay := amqpRetryDelay; channel == nil; delay = min(delay*2, maxFailoverBackoff) {
+			var err error
+			if channel, err = o.dial(); err != nil {
+				if !down {
+					log.Printf("can't publish to AMQP broker %s: %s", name, err)
+					down = true
+				}
+				time.Sleep(delay)
+			}
+		}
+		if down {
+			log.Printf("publishing to AMQP broker %s again", name)
+			down = false
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := channel.PublishWithContext(ctx, o.exchange, message.key, false, false, message.publishing)
+		cancel()
+		if err != nil {
+			log.Printf("can't publish to AMQP broker %s: %s", name, err)
+			channel.Close()
+			channel = nil
+		}
+	}
+	if channel != nil {
+		channel.Close()
+	}
+}
+
+func (o *AMQPOutput) connect() (amqpChannel, error) {
+	conn, err := amqp.Dial(o.url)
+	if err != nil {
+		return nil, err
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return amqpConnection{channel, conn}, nil
+}
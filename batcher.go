@@ -0,0 +1,118 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"time"
+)
+
+// batcher queues items for a sink and hands them to send in batches of up to
+// size, once size have been queued or wait has passed since the first of
+// them, whichever comes first. send is only ever called from one goroutine.
+type batcher[T any] struct {
+	size int
+	wait time.Duration
+	send func([]T)
+
+	queue   chan T
+	flushes chan chan struct{}
+}
+
+// newBatcher returns a batcher, with room for relayQueueSize items queued,
+// and starts it sending.
+func newBatcher[T any](size int, wait time.Duration, send func([]T)) *batcher[T] {
+	b := &batcher[T]{
+		size:    size,
+		wait:    wait,
+		send:    send,
+		queue:   make(chan T, relayQueueSize),
+		flushes: make(chan chan struct{}),
+	}
+	go b.run()
+	return b
+}
+
+// Add queues an item, reporting false if the queue is full.
+func (b *batcher[T]) Add(item T) bool {
+	select {
+	case b.queue <- item:
+		return true
+	default:
+		return false
+	}
+}
+
+// Flush sends everything queued and batched up, reporting false if that
+// hasn't happened within timeout, as when the other end is unreachable (in
+// which case it carries on in the background).
+func (b *batcher[T]) Flush(timeout time.Duration) bool {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	done := make(chan struct{})
+	select {
+	case b.flushes <- done:
+	case <-deadline.C:
+		return false
+	}
+	select {
+	case <-done:
+		return true
+	case <-deadline.C:
+		return false
+	}
+}
+
+func (b *batcher[T]) run() {
+	var batch []T
+	send := func() {
+		for len(batch) > 0 {
+			n := min(len(batch), b.size)
+			b.send(batch[:n])
+			batch = batch[n:]
+		}
+		batch = nil
+	}
+	// A timer which has fired, but not been received from, has to be
+	// drained when stopped, or the stale tick would cut the next batch
+	// short.
+	timer := time.NewTimer(b.wait)
+	stop := func() {
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+	stop()
+	for {
+		select {
+		case item := <-b.queue:
+			if len(batch) == 0 {
+				timer.Reset(b.wait)
+			}
+			batch = append(batch, item)
+			if len(batch) < b.size {
+				continue
+			}
+			stop()
+			send()
+		case <-timer.C:
+			send()
+		case done := <-b.flushes:
+			stop()
+			for queued := true; queued; {
+				select {
+				case item := <-b.queue:
+					batch = append(batch, item)
+				default:
+					queued = false
+				}
+			}
+			send()
+			close(done)
+		}
+	}
+}
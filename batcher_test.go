@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBatcher(t *testing.T) {
+	batches := make(chan []int, 10)
+	b := newBatcher(3, 50*time.Millisecond, func(batch []int) {
+		batches <- append([]int(nil), batch...)
+	})
+	next := func() []int {
+		select {
+		case batch := <-batches:
+			return batch
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a batch")
+			return nil
+		}
+	}
+
+	// A full batch goes straight away, and a partial one once the wait has
+	// passed.
+	for i := 1; i <= 4; i++ {
+		b.Add(i)
+	}
+	if got := next(); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("Expected a full batch, got %v", got)
+	}
+	start := time.Now()
+	if got := next(); !reflect.DeepEqual(got, []int{4}) {
+		t.Errorf("Expected the rest, got %v", got)
+	}
+	if waited := time.Since(start); waited < 25*time.Millisecond {
+		t.Errorf("Expected the partial batch to wait, got it after %s", waited)
+	}
+
+	// Flushing sends what's queued at once, in batches of no more than
+	// the size.
+	for i := 5; i <= 9; i++ {
+		b.Add(i)
+	}
+	b.Flush(5 * time.Second)
+	if got := append(next(), next()...); !reflect.DeepEqual(got, []int{5, 6, 7, 8, 9}) {
+		t.Errorf("Expected the queued items, got %v", got)
+	}
+	select {
+	case batch := <-batches:
+		t.Errorf("Expected nothing more, got %v", batch)
+	default:
+	}
+}
+
+func TestBatcherFlushTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	b := newBatcher(1, time.Hour, func(batch []int) {
+		<-release
+	})
+	b.Add(1)
+	b.Add(2)
+	if b.Flush(20 * time.Millisecond) {
+		t.Errorf("Expected the flush to give up while sending is stuck")
+	}
+}
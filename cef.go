@@ -0,0 +1,94 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"strings"
+)
+
+// The CEF header fields following the version, in order.
+var cefHeaderFields = []string{
+	"CEF_DEVICE_VENDOR", "CEF_DEVICE_PRODUCT", "CEF_DEVICE_VERSION",
+	"CEF_SIGNATURE_ID", "CEF_NAME", "CEF_SEVERITY",
+}
+
+var (
+	cefHeaderUnescaper    = strings.NewReplacer(`\|`, `|`, `\\`, `\`)
+	cefExtensionUnescaper = strings.NewReplacer(`\=`, `=`, `\\`, `\`, `\n`, "\n", `\r`, "\r")
+)
+
+// ParseCEF recognizes an ArcSight Common Event Format payload,
+// "CEF:Version|Device Vendor|Device Product|Device Version|Signature ID|Name|Severity|Extension",
+// returning its header as CEF_VERSION, CEF_DEVICE_VENDOR and so on, and each
+// key=value pair of its extension as CEF_<KEY>. It returns nil for anything
+// else.
+func ParseCEF(payload string) map[string]string {
+	payload = strings.TrimLeft(payload, " ")
+	if !strings.HasPrefix(payload, "CEF:") {
+		return nil
+	}
+	header := splitUnescaped(payload[len("CEF:"):], '|', len(cefHeaderFields)+2)
+	if len(header) != len(cefHeaderFields)+2 {
+		return nil
+	}
+
+	fields := parseCEFExtension(header[len(header)-1])
+	fields["CEF_VERSION"] = header[0]
+	for i, name := range cefHeaderFields {
+		fields[name] = cefHeaderUnescaper.Replace(header[i+1])
+	}
+	return fields
+}
+
+// splitUnescaped splits s around at most n-1 occurrences of sep which aren't
+// escaped with a backslash.
+func splitUnescaped(s string, sep byte, n int) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s) && len(parts) < n-1; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseCEFExtension splits a CEF extension into its key=value pairs. Values
+// may contain spaces, so each one runs up to the space before the next key;
+// an equals sign in a value is escaped.
+func parseCEFExtension(ext string) map[string]string {
+	fields := map[string]string{}
+
+	// Find the start of each key, and the unescaped '=' following it.
+	type pair struct{ key, eq int }
+	var pairs []pair
+	for i := 0; i < len(ext); i++ {
+		switch ext[i] {
+		case '\\':
+			i++
+		case '=':
+			key := strings.LastIndexByte(ext[:i], ' ') + 1
+			if key < i && (len(pairs) == 0 || key > pairs[len(pairs)-1].eq) {
+				pairs = append(pairs, pair{key, i})
+			}
+		}
+	}
+
+	for n, p := range pairs {
+		end := len(ext)
+		if n+1 < len(pairs) {
+			end = pairs[n+1].key
+		}
+		value := strings.TrimRight(ext[p.eq+1:end], " ")
+		if name := JournalFieldName("CEF_", ext[p.key:p.eq]); name != "" {
+			fields[name] = cefExtensionUnescaper.Replace(value)
+		}
+	}
+	return fields
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCEF(t *testing.T) {
+	payload := `CEF:0|Security|threatmanager|1.0|100|detected a \| in message|10|src=10.0.0.1 act=blocked a \= dst=2.1.2.2 msg=Detected a threat. No action needed. cs1Label=note cs1=line one\nline two\\`
+	expected := map[string]string{
+		"CEF_VERSION":        "0",
+		"CEF_DEVICE_VENDOR":  "Security",
+		"CEF_DEVICE_PRODUCT": "threatmanager",
+		"CEF_DEVICE_VERSION": "1.0",
+		"CEF_SIGNATURE_ID":   "100",
+		"CEF_NAME":           "detected a | in message",
+		"CEF_SEVERITY":       "10",
+		"CEF_SRC":            "10.0.0.1",
+		"CEF_ACT":            "blocked a =",
+		"CEF_DST":            "2.1.2.2",
+		"CEF_MSG":            "Detected a threat. No action needed.",
+		"CEF_CS1LABEL":       "note",
+		"CEF_CS1":            "line one\nline two\\",
+	}
+	if fields := ParseCEF(payload); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected: %q\n     Got: %q", expected, fields)
+	}
+
+	for _, other := range []string{"message", "CEF:0|Security|threatmanager|1.0", ""} {
+		if fields := ParseCEF(other); fields != nil {
+			t.Errorf("%q: expected no fields, got %q", other, fields)
+		}
+	}
+
+	// CEF from RFC3164 senders runs into the TAG.
+	msg := NewSyslogMessage()
+	msg.Parse(`<134>Dec 15 11:55:02 host CEF:0|Security|threatmanager|1.0|100|worm successfully stopped|10|src=10.0.0.1`, "127.0.0.1")
+	fields := msg.Fields()
+	if fields["CEF_NAME"] != "worm successfully stopped" || fields["CEF_SRC"] != "10.0.0.1" {
+		t.Errorf("Unexpected fields: %q", fields)
+	}
+}
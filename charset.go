@@ -0,0 +1,32 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// LookupCharset returns the encoding with the given WHATWG name or label,
+// such as "latin1", "windows-1252" or "shift_jis".
+func LookupCharset(name string) (encoding.Encoding, error) {
+	return htmlindex.Get(name)
+}
+
+// Transcode converts s from charset to UTF-8, unless it's valid UTF-8
+// already (or there's no charset to convert from). journald stores fields
+// which aren't UTF-8 as binary blobs, which most tools won't display.
+func Transcode(s string, charset encoding.Encoding) string {
+	if charset == nil || utf8.ValidString(s) {
+		return s
+	}
+	decoded, err := charset.NewDecoder().String(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTranscode(t *testing.T) {
+	latin1, err := LookupCharset("latin1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	tests := []struct {
+		in       string
+		expected string
+	}{
+		{"caf\xe9", "café"},
+		{"café", "café"},
+		{"plain", "plain"},
+	}
+
+	for num, test := range tests {
+		if got := Transcode(test.in, latin1); got != test.expected {
+			t.Errorf("Failed test %d: expected %q, got %q", num, test.expected, got)
+		}
+	}
+	if got := Transcode("caf\xe9", nil); got != "caf\xe9" {
+		t.Errorf("Expected no conversion without a charset, got %q", got)
+	}
+}
+
+func TestCharsetFor(t *testing.T) {
+	sockets := socketConfigs{}
+	if err := sockets.Set("legacy:charset=latin1,source-charset=10.0.0.0/8=shift_jis"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	config := sockets.Lookup("legacy")
+	latin1, _ := LookupCharset("latin1")
+	sjis, _ := LookupCharset("shift_jis")
+
+	if got := config.CharsetFor("10.1.2.3:514"); got != sjis {
+		t.Errorf("Expected shift_jis for 10.1.2.3, got %v", got)
+	}
+	if got := config.CharsetFor("192.0.2.1:514"); got != latin1 {
+		t.Errorf("Expected latin1 for 192.0.2.1, got %v", got)
+	}
+	if got := NewSocketConfig("").CharsetFor("10.1.2.3:514"); got != nil {
+		t.Errorf("Expected no charset by default, got %v", got)
+	}
+}
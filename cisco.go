@@ -0,0 +1,112 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ciscoMnemonic matches the "%FACILITY-SEVERITY-MNEMONIC: " which starts the
+// text of every Cisco IOS message.
+var ciscoMnemonic = regexp.MustCompile(`(?:^|: )%([A-Z0-9_]+(?:-[A-Z0-9_]+)*)-([0-7])-([A-Za-z0-9_]+): ?`)
+
+// The timestamp layouts IOS uses, depending on "service timestamps log
+// datetime" options.
+var ciscoTimestampLayouts = []string{
+	"Jan _2 15:04:05.000 MST",
+	"Jan _2 15:04:05.000",
+	"Jan _2 15:04:05 MST",
+	"Jan _2 15:04:05",
+	"Jan _2 2006 15:04:05.000 MST",
+	"Jan _2 2006 15:04:05.000",
+	"Jan _2 2006 15:04:05 MST",
+	"Jan _2 2006 15:04:05",
+}
+
+// parseCisco parses what follows the PRI of a message in Cisco IOS's
+// dialect, returning the message text, e.g.
+//
+//	123: router1: *Mar  1 00:00:00.123 UTC: %LINK-3-UPDOWN: Interface ...
+//
+// where the sequence number, hostname and timestamp are all optional. The
+// severity in the mnemonic takes precedence over the PRI's; the rest becomes
+// CISCO_FACILITY, CISCO_MNEMONIC and CISCO_SEQUENCE. Timestamps marked as
+// unsynchronized ("*" or ".") are recorded as CISCO_TIMESTAMP, but not
+// trusted. It reports false (leaving msg alone) if buf isn't in the dialect.
+func (msg *SyslogMessage) parseCisco(buf string) (string, bool) {
+	// Most messages aren't Cisco's, so avoid the regexp where possible.
+	if strings.IndexByte(buf, '%') < 0 {
+		return buf, false
+	}
+	match := ciscoMnemonic.FindStringSubmatchIndex(buf)
+	if match == nil {
+		return buf, false
+	}
+
+	var sequence, hostname, stamp string
+	var ts time.Time
+	if match[0] > 0 {
+		for i, part := range strings.Split(buf[:match[0]], ": ") {
+			switch {
+			case i == 0 && isDigits(part):
+				sequence = part
+			case stamp != "":
+				return buf, false
+			case strings.TrimLeft(part, "*.") != part:
+				stamp = part
+			default:
+				if parsed, ok := msg.parseCiscoTimestamp(part); ok {
+					stamp, ts = part, parsed
+				} else if hostname == "" && !strings.Contains(part, " ") {
+					hostname = part
+				} else {
+					return buf, false
+				}
+			}
+		}
+	}
+
+	extra := map[string]string{
+		"CISCO_FACILITY": buf[match[2]:match[3]],
+		"CISCO_MNEMONIC": buf[match[6]:match[7]],
+	}
+	if sequence != "" {
+		extra["CISCO_SEQUENCE"] = sequence
+	}
+	if !ts.IsZero() {
+		msg.Timestamp = ts
+		msg.OriginalTimestamp = stamp
+	} else if stamp != "" {
+		extra["CISCO_TIMESTAMP"] = stamp
+	}
+
+	msg.Hostname = hostname
+	msg.AppName = extra["CISCO_FACILITY"]
+	msg.Severity = int(buf[match[4]] - '0')
+	msg.Extra = extra
+	return buf[match[1]:], true
+}
+
+func isDigits(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
+// parseCiscoTimestamp parses a timestamp in any of the layouts IOS uses,
+// filling in the year if it's missing.
+func (msg *SyslogMessage) parseCiscoTimestamp(s string) (time.Time, bool) {
+	for _, layout := range ciscoTimestampLayouts {
+		ts, err := time.ParseInLocation(layout, s, msg.location())
+		if err != nil {
+			continue
+		}
+		if ts.Year() == 0 {
+			ts = msg.withYear(ts)
+		}
+		return ts, true
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+func TestParseCisco(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Date(2016, 3, 2, 0, 0, 0, 0, time.UTC))
+
+	var tests = []struct {
+		format    string
+		buf       string
+		severity  int
+		hostname  string
+		timestamp time.Time
+		message   string
+		extra     map[string]string
+	}{
+		{
+			"", `<189>123: *Mar  1 00:00:00.123: %SYS-5-CONFIG_I: Configured from console by vty0`,
+			5, "", clock.Now(), "Configured from console by vty0",
+			map[string]string{"CISCO_FACILITY": "SYS", "CISCO_MNEMONIC": "CONFIG_I", "CISCO_SEQUENCE": "123", "CISCO_TIMESTAMP": "*Mar  1 00:00:00.123"},
+		},
+		{
+			"", `<187>45: router1: Mar  1 12:34:56.789 UTC: %LINEPROTO-5-UPDOWN: Line protocol on Interface Gi0/1, changed state to down`,
+			5, "router1", time.Date(2016, 3, 1, 12, 34, 56, 789000000, time.UTC), "Line protocol on Interface Gi0/1, changed state to down",
+			map[string]string{"CISCO_FACILITY": "LINEPROTO", "CISCO_MNEMONIC": "UPDOWN", "CISCO_SEQUENCE": "45"},
+		},
+		{
+			"", `<187>Mar  1 2015 12:34:56 UTC: %SW_MATM-4-MACFLAP_NOTIF: Host flapping`,
+			4, "", time.Date(2015, 3, 1, 12, 34, 56, 0, time.UTC), "Host flapping",
+			map[string]string{"CISCO_FACILITY": "SW_MATM", "CISCO_MNEMONIC": "MACFLAP_NOTIF"},
+		},
+		{
+			"cisco", `<187>%FAN-STBY-3-FAILED: Fan failed`,
+			3, "", clock.Now(), "Fan failed",
+			map[string]string{"CISCO_FACILITY": "FAN-STBY", "CISCO_MNEMONIC": "FAILED"},
+		},
+		{
+			"", `<13>Dec 15 11:55:02 host user: %FOO-1-BAR: not cisco`,
+			5, "host", time.Date(2015, 12, 15, 11, 55, 2, 0, time.UTC), "%FOO-1-BAR: not cisco",
+			nil,
+		},
+	}
+
+	for num, test := range tests {
+		msg := NewSyslogMessage()
+		msg.clock = clock
+		msg.Timestamp = clock.Now()
+		msg.ParseFormat(test.buf, "", test.format)
+		if msg.Severity != test.severity || msg.Hostname != test.hostname || !msg.Timestamp.Equal(test.timestamp) ||
+			msg.Message != test.message || !reflect.DeepEqual(msg.Extra, test.extra) {
+			t.Errorf("Failed test %d: got severity %d, hostname %q, timestamp %s, message %q, fields %q",
+				num, msg.Severity, msg.Hostname, msg.Timestamp, msg.Message, msg.Extra)
+		}
+	}
+}
@@ -0,0 +1,679 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"crypto/dsa"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/journal"
+	"golang.org/x/text/encoding"
+)
+
+// Facility names, as used by syslog.conf and friends, indexed by number.
+var facilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// ParseFacility accepts either a facility name or its number.
+func ParseFacility(value string) (int, error) {
+	for i, name := range facilityNames {
+		if value == name {
+			return i, nil
+		}
+	}
+	if facility, err := strconv.Atoi(value); err == nil && facility >= 0 && facility < len(facilityNames) {
+		return facility, nil
+	}
+	return 0, fmt.Errorf("unknown facility %q", value)
+}
+
+// Severity names, as used by syslog.conf and friends, indexed by number.
+var severityNames = []string{
+	"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug",
+}
+
+// ParseSeverity accepts either a severity name or its number.
+func ParseSeverity(value string) (int, error) {
+	for i, name := range severityNames {
+		if value == name {
+			return i, nil
+		}
+	}
+	if severity, err := strconv.Atoi(value); err == nil && severity >= 0 && severity < len(severityNames) {
+		return severity, nil
+	}
+	return 0, fmt.Errorf("unknown severity %q", value)
+}
+
+// SocketConfig holds the settings for one listening socket. Sockets from
+// systemd are matched up with their settings by FileDescriptorName=, and the
+// ones bound with -listen-* by an optional "name=" prefix on the address.
+type SocketConfig struct {
+	Name string
+
+	// Format is a hint for the parser: "rfc5424", "rfc3164", "cisco", "raw"
+	// (don't parse at all), or several of those separated by "/" to try in
+	// turn. Empty means "rfc5424/cisco/rfc3164".
+	Format string
+
+	// Strict rejects messages which don't follow Format (see Validate)
+	// exactly, rather than making the best of them.
+	Strict bool
+
+	// SourceProfiles override Format and Strict for senders within their
+	// networks (see parserProfiles).
+	SourceProfiles []sourceProfile
+
+	// TLS wraps activated TCP listeners with TLS (RFC5425).
+	TLS bool
+
+	// Protocol is "syslog" for plain syslog transports, "relp" for RELP on
+	// stream sockets, or "gelf" or "quic" for GELF or syslog-over-QUIC on
+	// UDP sockets.
+	Protocol string
+
+	// JSON promotes the top-level keys of messages which are JSON objects
+	// to journal fields, named with JSONPrefix.
+	JSON       bool
+	JSONPrefix string
+
+	// KeyValueFields lists the keys of key=value payloads (as sent by
+	// FortiGate and many other appliances) to promote to journal fields,
+	// named with KeyValuePrefix. "*" promotes every key.
+	KeyValueFields []string
+	KeyValuePrefix string
+
+	// StaticFields are constant journal fields added to the socket's
+	// entries, overriding -field's for the same names.
+	StaticFields staticFields
+
+	// FieldMappings rename, drop or set journal fields in the order given
+	// (see FieldMapping).
+	FieldMappings []FieldMapping
+
+	// Namespace is the journald namespace to send entries to, overriding
+	// -journal-namespace.
+	Namespace string
+
+	// OutputFile is a file to also write entries to, overriding
+	// -file-output.
+	OutputFile string
+
+	// Patterns extract fields from the messages of particular programs (see
+	// PatternFields).
+	Patterns []Pattern
+
+	// Trim strips the trailing line endings and NULs many senders append to
+	// messages, and any leading whitespace, before parsing. It's off unless
+	// asked for, so messages are recorded as sent.
+	Trim bool
+
+	// SDFields is how structured data parameters are recorded: "params" (or
+	// empty) as a field each, "json" as SYSLOG_SD_JSON, or "both".
+	SDFields string
+
+	// ControlChars is "escape" or "strip" to escape or remove the control
+	// characters (other than LF and TAB) in MSG and STRUCTURED-DATA values,
+	// or "keep" (or empty) to leave them alone.
+	ControlChars string
+
+	// Raw attaches the packet as received, up to RawSize bytes, to each
+	// entry (see RawFields).
+	Raw     bool
+	RawSize int
+
+	// Multiline, if nonzero, joins continuation lines arriving within that
+	// long of each other into one entry (see MultilineAggregator).
+	Multiline time.Duration
+
+	// Repeat, if nonzero, collapses runs of identical messages, counting
+	// them for up to that long at a time (see RepeatSuppressor).
+	Repeat time.Duration
+
+	// SignKeys, if any, are trusted to sign messages with syslog-sign, which
+	// are held back for up to SignWindow to be verified (see
+	// SignatureVerifier).
+	SignKeys   []*dsa.PublicKey
+	SignWindow time.Duration
+
+	// FacilityPriorities maps facilities to the lowest journal PRIORITY
+	// their messages are given, e.g. so that everything from kern or
+	// authpriv shows up in journalctl -p warning.
+	FacilityPriorities map[int]int
+
+	// Mark is "drop", "count" or "heartbeat" to drop MARK messages, drop
+	// them but count them (see ReportMarks), or send them at debug
+	// priority with SYSLOG_HEARTBEAT=1; or "keep" (or empty) to treat them
+	// like any other.
+	Mark string
+
+	// Facility and Severity are assigned to messages which don't carry a
+	// PRI (or which are taken as raw), and DefaultHostname (see HostnameFor)
+	// to those which don't carry a HOSTNAME.
+	Facility        int
+	Severity        int
+	DefaultHostname string
+
+	// Hostname is "validate" or "normalize" to check the HOSTNAMEs messages
+	// claim (see CheckHostname), or "keep" (or empty) to take them as
+	// they are.
+	Hostname string
+
+	// HostnameFrom says which hostname to record (see ChooseHostname):
+	// "claimed", "dns" or "source", or if empty, -hostname-from's choice.
+	// SourceHostnameFrom overrides it for senders within their networks.
+	HostnameFrom       string
+	SourceHostnameFrom []sourceHostnameFrom
+
+	// Multicast lists the groups UDP sockets join, on MulticastInterface
+	// (or the kernel's choice, if that's empty).
+	Multicast          []net.IP
+	MulticastInterface string
+
+	// Timezone is assumed for RFC3164 timestamps (which don't say), unless
+	// the sender's address is in one of SourceTimezones.
+	Timezone        *time.Location
+	SourceTimezones []sourceTimezone
+
+	// Charset is the encoding assumed for messages which aren't valid
+	// UTF-8, unless the sender's address is in one of SourceCharsets; they
+	// are left alone if it's nil.
+	Charset        encoding.Encoding
+	SourceCharsets []sourceCharset
+
+	// TrustedNetworks are the senders which may set journal fields directly
+	// with a -passthrough-sd-id element; with TrustAll, every sender may.
+	TrustedNetworks []*net.IPNet
+	TrustAll        bool
+
+	// TimestampLayouts lists extra timestamp formats to try, as time.Parse
+	// layouts or "unix" or "unixmilli" for times since the epoch.
+	TimestampLayouts []string
+
+	// MaxMessageSize overrides the largest message accepted (-max-datagram-size
+	// for datagram sockets, PACKETSIZE for streams), if nonzero. Longer
+	// messages are cut short, with TruncationMarker appended, and marked
+	// with SYSLOG_TRUNCATED=1.
+	MaxMessageSize   int
+	TruncationMarker string
+}
+
+// parserProfile is a named combination of Format and Strict.
+type parserProfile struct {
+	format string
+	strict bool
+}
+
+// parserProfiles are the profiles the profile and source-profile settings
+// take, for the dialects common in mixed fleets.
+var parserProfiles = map[string]parserProfile{
+	"auto":           {"", false},
+	"rfc5424":        {"rfc5424", false},
+	"rfc5424-strict": {"rfc5424", true},
+	"rfc3164":        {"rfc3164", false},
+	"rfc3164-strict": {"rfc3164", true},
+	"cisco":          {"cisco/rfc3164", false},
+	// CEF senders often leave out the syslog header, or all but the PRI;
+	// either way, what's left after any PRI is taken as the MSG.
+	"cef": {"rfc5424/rfc3164", false},
+	"raw": {"raw", false},
+}
+
+// sourceProfile assigns a parser profile to senders within a network.
+type sourceProfile struct {
+	network *net.IPNet
+	profile parserProfile
+}
+
+// sourceCharset assigns a charset to senders within a network.
+type sourceCharset struct {
+	network *net.IPNet
+	charset encoding.Encoding
+}
+
+// sourceHostnameFrom assigns a hostname precedence to senders within a
+// network.
+type sourceHostnameFrom struct {
+	network *net.IPNet
+	from    string
+}
+
+// sourceTimezone assigns a time zone to senders within a network.
+type sourceTimezone struct {
+	network  *net.IPNet
+	location *time.Location
+}
+
+// NewSocketConfig returns the default settings for a socket.
+func NewSocketConfig(name string) *SocketConfig {
+	return &SocketConfig{
+		Name:           name,
+		Protocol:       "syslog",
+		RawSize:        1024,
+		SignWindow:     time.Minute,
+		Severity:       int(journal.PriNotice),
+		JSONPrefix:     "JSON_",
+		KeyValuePrefix: "KV_",
+	}
+}
+
+// Set applies a single "key=value" setting.
+func (config *SocketConfig) Set(setting string) error {
+	parts := strings.SplitN(setting, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("socket setting %q is not key=value", setting)
+	}
+	key, value := parts[0], parts[1]
+
+	switch key {
+	case "format":
+		if value == "auto" {
+			value = ""
+		}
+		for _, format := range strings.Split(value, "/") {
+			switch format {
+			case "rfc5424", "rfc3164", "cisco", "raw":
+			default:
+				if value != "" && LookupParser(format) == nil {
+					return fmt.Errorf("unknown format %q", format)
+				}
+			}
+		}
+		config.Format = value
+	case "profile":
+		profile, ok := parserProfiles[value]
+		if !ok {
+			return fmt.Errorf("unknown profile %q", value)
+		}
+		config.Format, config.Strict = profile.format, profile.strict
+	case "source-profile":
+		network, name, err := cutSourceNetwork(key, value, "PROFILE")
+		if err != nil {
+			return err
+		}
+		profile, ok := parserProfiles[name]
+		if !ok {
+			return fmt.Errorf("unknown profile %q", name)
+		}
+		config.SourceProfiles = append(config.SourceProfiles, sourceProfile{network, profile})
+	case "strict":
+		strict, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("bad strict setting %q", value)
+		}
+		config.Strict = strict
+	case "tls":
+		tls, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("bad tls setting %q", value)
+		}
+		config.TLS = tls
+	case "protocol":
+		switch value {
+		case "syslog", "relp", "gelf", "quic":
+		default:
+			return fmt.Errorf("unknown protocol %q", value)
+		}
+		config.Protocol = value
+	case "json":
+		json, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("bad json setting %q", value)
+		}
+		config.JSON = json
+	case "json-prefix":
+		config.JSONPrefix = value
+	case "kv-field":
+		config.KeyValueFields = append(config.KeyValueFields, value)
+	case "kv-prefix":
+		config.KeyValuePrefix = value
+	case "field":
+		if config.StaticFields == nil {
+			config.StaticFields = staticFields{}
+		}
+		return config.StaticFields.Set(value)
+	case "field-drop", "field-rename", "field-template":
+		field, setting, ok := strings.Cut(value, "=")
+		kind := strings.TrimPrefix(key, "field-")
+		if ok == (kind == "drop") {
+			return fmt.Errorf("bad %s setting %q", key, value)
+		}
+		mapping, err := NewFieldMapping(kind, field, setting)
+		if err != nil {
+			return err
+		}
+		config.FieldMappings = append(config.FieldMappings, mapping)
+	case "namespace":
+		if !validNamespace(value) {
+			return fmt.Errorf("bad journal namespace %q", value)
+		}
+		config.Namespace = value
+	case "file":
+		config.OutputFile = value
+	case "patterns":
+		patterns, err := LoadPatterns(value)
+		if err != nil {
+			return err
+		}
+		config.Patterns = append(config.Patterns, patterns...)
+	case "trim":
+		trim, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("bad trim setting %q", value)
+		}
+		config.Trim = trim
+	case "sd-fields":
+		switch value {
+		case "params", "json", "both":
+		default:
+			return fmt.Errorf("unknown sd-fields setting %q", value)
+		}
+		config.SDFields = value
+	case "control-chars":
+		switch value {
+		case "keep", "escape", "strip":
+		default:
+			return fmt.Errorf("unknown control-chars setting %q", value)
+		}
+		config.ControlChars = value
+	case "raw":
+		raw, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("bad raw setting %q", value)
+		}
+		config.Raw = raw
+	case "raw-size":
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			return fmt.Errorf("bad raw-size setting %q", value)
+		}
+		config.RawSize = size
+	case "multiline":
+		window, err := time.ParseDuration(value)
+		if err != nil || window < 0 {
+			return fmt.Errorf("bad multiline setting %q", value)
+		}
+		config.Multiline = window
+	case "repeat":
+		window, err := time.ParseDuration(value)
+		if err != nil || window < 0 {
+			return fmt.Errorf("bad repeat setting %q", value)
+		}
+		config.Repeat = window
+	case "sign-key":
+		keys, err := LoadSignKeys(value)
+		if err != nil {
+			return err
+		}
+		config.SignKeys = append(config.SignKeys, keys...)
+	case "sign-window":
+		window, err := time.ParseDuration(value)
+		if err != nil || window <= 0 {
+			return fmt.Errorf("bad sign-window setting %q", value)
+		}
+		config.SignWindow = window
+	case "mark":
+		switch value {
+		case "keep", "drop", "count", "heartbeat":
+		default:
+			return fmt.Errorf("unknown mark setting %q", value)
+		}
+		config.Mark = value
+	case "facility":
+		facility, err := ParseFacility(value)
+		if err != nil {
+			return err
+		}
+		config.Facility = facility
+	case "severity":
+		severity, err := ParseSeverity(value)
+		if err != nil {
+			return err
+		}
+		config.Severity = severity
+	case "facility-priority":
+		name, level, ok := strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("facility-priority %q is not FACILITY=PRIORITY", value)
+		}
+		facility, err := ParseFacility(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		priority, err := ParseSeverity(strings.TrimSpace(level))
+		if err != nil {
+			return err
+		}
+		if config.FacilityPriorities == nil {
+			config.FacilityPriorities = map[int]int{}
+		}
+		config.FacilityPriorities[facility] = priority
+	case "default-hostname":
+		config.DefaultHostname = value
+	case "hostname":
+		switch value {
+		case "keep", "validate", "normalize":
+		default:
+			return fmt.Errorf("unknown hostname setting %q", value)
+		}
+		config.Hostname = value
+	case "hostname-from":
+		if !validHostnameFrom(value) {
+			return fmt.Errorf("unknown hostname-from setting %q", value)
+		}
+		config.HostnameFrom = value
+	case "source-hostname-from":
+		network, from, err := cutSourceNetwork(key, value, "FROM")
+		if err != nil {
+			return err
+		}
+		if !validHostnameFrom(from) {
+			return fmt.Errorf("unknown hostname-from setting %q", from)
+		}
+		config.SourceHostnameFrom = append(config.SourceHostnameFrom, sourceHostnameFrom{network, from})
+	case "multicast":
+		group := net.ParseIP(value)
+		if group == nil || !group.IsMulticast() {
+			return fmt.Errorf("bad multicast group %q", value)
+		}
+		config.Multicast = append(config.Multicast, group)
+	case "multicast-interface":
+		config.MulticastInterface = value
+	case "timezone":
+		location, err := time.LoadLocation(value)
+		if err != nil {
+			return err
+		}
+		config.Timezone = location
+	case "timestamp-layout":
+		if value == "" {
+			return errors.New("empty timestamp-layout")
+		}
+		config.TimestampLayouts = append(config.TimestampLayouts, value)
+	case "max-message-size":
+		size, err := strconv.Atoi(value)
+		if err != nil || size < 480 || size > maxMessageSize {
+			return fmt.Errorf("max-message-size %q is not between 480 and %d", value, maxMessageSize)
+		}
+		config.MaxMessageSize = size
+	case "truncation-marker":
+		config.TruncationMarker = value
+	case "source-timezone":
+		network, zone, err := cutSourceNetwork(key, value, "ZONE")
+		if err != nil {
+			return err
+		}
+		location, err := time.LoadLocation(zone)
+		if err != nil {
+			return err
+		}
+		config.SourceTimezones = append(config.SourceTimezones, sourceTimezone{network, location})
+	case "charset":
+		charset, err := LookupCharset(value)
+		if err != nil {
+			return fmt.Errorf("unknown charset %q", value)
+		}
+		config.Charset = charset
+	case "source-charset":
+		network, name, err := cutSourceNetwork(key, value, "CHARSET")
+		if err != nil {
+			return err
+		}
+		charset, err := LookupCharset(name)
+		if err != nil {
+			return fmt.Errorf("unknown charset %q", name)
+		}
+		config.SourceCharsets = append(config.SourceCharsets, sourceCharset{network, charset})
+	case "trust-fields":
+		if value == "all" {
+			config.TrustAll = true
+			break
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return err
+		}
+		config.TrustedNetworks = append(config.TrustedNetworks, network)
+	default:
+		return fmt.Errorf("unknown socket setting %q", key)
+	}
+	return nil
+}
+
+// cutSourceNetwork splits the value of a per-network setting like
+// source-timezone, "CIDR=NAME" (with spaces allowed around the "=", as in
+// "10.1.0.0/16 = America/Chicago"), into the network and the name.
+func cutSourceNetwork(key string, value string, name string) (*net.IPNet, string, error) {
+	cidr, setting, ok := strings.Cut(value, "=")
+	if !ok {
+		return nil, "", fmt.Errorf("%s %q is not CIDR=%s", key, value, name)
+	}
+	_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+	if err != nil {
+		return nil, "", err
+	}
+	return network, strings.TrimSpace(setting), nil
+}
+
+// TimezoneFor returns the time zone to assume for RFC3164 timestamps from
+// source: the first SourceTimezones network containing its address, or
+// Timezone.
+func (config *SocketConfig) TimezoneFor(source string) *time.Location {
+	if len(config.SourceTimezones) > 0 {
+		if ip := net.ParseIP(sourceHost(source)); ip != nil {
+			for _, zone := range config.SourceTimezones {
+				if zone.network.Contains(ip) {
+					return zone.location
+				}
+			}
+		}
+	}
+	return config.Timezone
+}
+
+// CharsetFor returns the charset to assume for messages from source which
+// aren't UTF-8: that of the first SourceCharsets network containing its
+// address, or Charset.
+func (config *SocketConfig) CharsetFor(source string) encoding.Encoding {
+	if len(config.SourceCharsets) > 0 {
+		if ip := net.ParseIP(sourceHost(source)); ip != nil {
+			for _, charset := range config.SourceCharsets {
+				if charset.network.Contains(ip) {
+					return charset.charset
+				}
+			}
+		}
+	}
+	return config.Charset
+}
+
+// ParserFor returns the Format and Strict settings for messages from source:
+// those of the first SourceProfiles network containing its address, or the
+// socket's own.
+func (config *SocketConfig) ParserFor(source string) (string, bool) {
+	if len(config.SourceProfiles) > 0 {
+		if ip := net.ParseIP(sourceHost(source)); ip != nil {
+			for _, profile := range config.SourceProfiles {
+				if profile.network.Contains(ip) {
+					return profile.profile.format, profile.profile.strict
+				}
+			}
+		}
+	}
+	return config.Format, config.Strict
+}
+
+// maxMessageSize bounds max-message-size; datagram sockets are further
+// limited to MAXDATAGRAMSIZE.
+const maxMessageSize = 1 << 20
+
+// DatagramSize returns the largest datagram to accept on the socket.
+func (config *SocketConfig) DatagramSize() int {
+	if config.MaxMessageSize > 0 {
+		return min(config.MaxMessageSize, MAXDATAGRAMSIZE)
+	}
+	return *maxDatagramSize
+}
+
+// StreamSize returns the largest message to accept from a stream.
+func (config *SocketConfig) StreamSize() int {
+	if config.MaxMessageSize > 0 {
+		return config.MaxMessageSize
+	}
+	return PACKETSIZE
+}
+
+// socketConfigs is a flag.Value collecting "-socket NAME:KEY=VALUE,..."
+// settings, keyed by socket name.
+type socketConfigs map[string]*SocketConfig
+
+func (c socketConfigs) String() string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (c socketConfigs) Set(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return errors.New("expected NAME:KEY=VALUE[,KEY=VALUE...]")
+	}
+	config := c.Lookup(parts[0])
+	for _, setting := range strings.Split(parts[1], ",") {
+		if err := config.Set(setting); err != nil {
+			return err
+		}
+	}
+	c[config.Name] = config
+	return nil
+}
+
+// Lookup returns the settings for the named socket, or the defaults if it
+// hasn't been configured.
+func (c socketConfigs) Lookup(name string) *SocketConfig {
+	if config, ok := c[name]; ok {
+		return config
+	}
+	return NewSocketConfig(name)
+}
+
+// splitListenName separates the optional "name=" prefix from a -listen-*
+// address.
+func splitListenName(value string) (name string, addr string) {
+	if parts := strings.SplitN(value, "=", 2); len(parts) == 2 {
+		return parts[0], parts[1]
+	}
+	return "", value
+}
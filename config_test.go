@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+func TestSocketConfigs(t *testing.T) {
+	var tests = []struct {
+		settings []string
+		name     string
+		expected *SocketConfig
+	}{
+		{
+			[]string{"cisco:format=rfc3164,facility=local7,strict=true"},
+			"cisco",
+			&SocketConfig{Name: "cisco", Format: "rfc3164", Strict: true, Protocol: "syslog", RawSize: 1024, SignWindow: time.Minute, Facility: 23, Severity: 5, JSONPrefix: "JSON_", KeyValuePrefix: "KV_"},
+		},
+		{
+			[]string{"tls:tls=true", "tls:protocol=relp,facility=4,multiline=500ms"},
+			"tls",
+			&SocketConfig{Name: "tls", TLS: true, Protocol: "relp", RawSize: 1024, SignWindow: time.Minute, Multiline: 500 * time.Millisecond, Facility: 4, Severity: 5, JSONPrefix: "JSON_", KeyValuePrefix: "KV_"},
+		},
+		{
+			[]string{"legacy:format=rfc3164/raw,severity=info,json=true,json-prefix=APP_", "legacy:timestamp-layout=2006-01-02 15:04:05,timestamp-layout=unix"},
+			"legacy",
+			&SocketConfig{Name: "legacy", Format: "rfc3164/raw", Protocol: "syslog", RawSize: 1024, SignWindow: time.Minute, Severity: 6, JSON: true, JSONPrefix: "APP_", KeyValuePrefix: "KV_", TimestampLayouts: []string{"2006-01-02 15:04:05", "unix"}},
+		},
+		{
+			[]string{"mcast:multicast=239.0.0.1,multicast=ff02::114,multicast-interface=eth0,default-hostname=dns,facility-priority=authpriv=warning,facility-priority=kern = err"},
+			"mcast",
+			&SocketConfig{
+				Name:               "mcast",
+				Protocol:           "syslog",
+				RawSize:            1024,
+				SignWindow:         time.Minute,
+				Severity:           5,
+				JSONPrefix:         "JSON_",
+				KeyValuePrefix:     "KV_",
+				Multicast:          []net.IP{net.ParseIP("239.0.0.1"), net.ParseIP("ff02::114")},
+				MulticastInterface: "eth0",
+				DefaultHostname:    "dns",
+				FacilityPriorities: map[int]int{10: 4, 0: 3},
+			},
+		},
+		{
+			[]string{"big:max-message-size=65536,truncation-marker=[...],trim=true,control-chars=escape,raw=true,raw-size=4096,sign-window=30s"},
+			"big",
+			&SocketConfig{Name: "big", Protocol: "syslog", Trim: true, Raw: true, RawSize: 4096, SignWindow: 30 * time.Second, Severity: 5, JSONPrefix: "JSON_", KeyValuePrefix: "KV_", MaxMessageSize: 65536, TruncationMarker: "[...]", ControlChars: "escape"},
+		},
+		{
+			[]string{"cisco:format=raw"},
+			"legacy-udp",
+			&SocketConfig{Name: "legacy-udp", Protocol: "syslog", RawSize: 1024, SignWindow: time.Minute, Severity: 5, JSONPrefix: "JSON_", KeyValuePrefix: "KV_"},
+		},
+	}
+
+	for num, test := range tests {
+		sockets := socketConfigs{}
+		for _, setting := range test.settings {
+			if err := sockets.Set(setting); err != nil {
+				t.Errorf("Failed test %d: %s", num, err.Error())
+			}
+		}
+		if got := sockets.Lookup(test.name); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Failed test %d:\nExpected: %v\n     Got: %v", num, test.expected, got)
+		}
+	}
+
+	for _, bad := range []string{"nocolon", ":format=raw", "x:format=xml", "x:format=rfc5424/xml", "x:severity=loud", "x:json=yes please", "x:facility=local9", "x:bogus=1", "x:tls", "x:strict=maybe", "x:multicast=10.0.0.1", "x:timezone=Mars/Olympus_Mons", "x:source-timezone=UTC", "x:source-timezone=10.0.0.1=UTC", "x:charset=klingon", "x:source-charset=10.0.0.0/8", "x:source-charset=10.0.0.0/8=klingon", "x:max-message-size=100", "x:max-message-size=big", "x:trim=sometimes", "x:control-chars=hide", "x:timestamp-layout=", "x:raw-size=0", "x:multiline=soon", "x:patterns=/nonexistent/patterns", "x:sign-key=/nonexistent/keys.pem", "x:sign-window=0", "x:hostname=fix", "x:mark=ignore", "x:namespace=../etc", "x:facility-priority=auth", "x:facility-priority=auth=loud", "x:facility-priority=local9=err", "x:profile=xml", "x:source-profile=10.0.0.0/8", "x:source-profile=10.0.0.0/8=xml"} {
+		if err := (socketConfigs{}).Set(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestParserFor(t *testing.T) {
+	sockets := socketConfigs{}
+	if err := sockets.Set("mixed:profile=rfc5424-strict,source-profile=10.1.0.0/16=cisco,source-profile=10.0.0.0/8=cef"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	config := sockets.Lookup("mixed")
+
+	for source, expected := range map[string]parserProfile{
+		"10.1.2.3:514":     {"cisco/rfc3164", false},
+		"10.2.3.4:514":     {"rfc5424/rfc3164", false},
+		"192.0.2.1:514":    {"rfc5424", true},
+		"/run/syslog.sock": {"rfc5424", true},
+	} {
+		if format, strict := config.ParserFor(source); format != expected.format || strict != expected.strict {
+			t.Errorf("%s: expected %v, got %q %v", source, expected, format, strict)
+		}
+	}
+
+	// CEF with or without a PRI is parsed as CEF, keeping the PRI's
+	// facility and severity.
+	for _, test := range []struct {
+		buf                string
+		facility, severity int
+	}{
+		{"<13>CEF:0|Security|threatmanager|1.0|100|worm successfully stopped|10|src=10.0.0.1", 1, 5},
+		{"CEF:0|Security|threatmanager|1.0|100|worm successfully stopped|10|src=10.0.0.1", config.Facility, config.Severity},
+	} {
+		format, _ := config.ParserFor("10.2.3.4:514")
+		msg := NewSyslogMessage()
+		msg.Facility, msg.Severity = config.Facility, config.Severity
+		msg.ParseFormat(test.buf, "10.2.3.4:514", format)
+		vars := msg.Fields()
+		if msg.Facility != test.facility || msg.Severity != test.severity || vars["CEF_DEVICE_VENDOR"] != "Security" {
+			t.Errorf("%q: expected CEF at %d.%d, got %d.%d, %v", test.buf, test.facility, test.severity, msg.Facility, msg.Severity, vars)
+		}
+	}
+}
+
+func TestTimezoneFor(t *testing.T) {
+	sockets := socketConfigs{}
+	if err := sockets.Set("legacy:timezone=America/New_York,source-timezone=10.1.0.0/16=Europe/Berlin,source-timezone=10.0.0.0/8=Asia/Tokyo,source-timezone=2001:db8::/32=UTC"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	config := sockets.Lookup("legacy")
+
+	for source, expected := range map[string]string{
+		"10.1.2.3:514":      "Europe/Berlin",
+		"10.2.3.4:514":      "Asia/Tokyo",
+		"[2001:db8::1]:514": "UTC",
+		"192.0.2.1:514":     "America/New_York",
+		"/dev/log":          "America/New_York",
+	} {
+		if got := config.TimezoneFor(source); got == nil || got.String() != expected {
+			t.Errorf("%s: expected %s, got %v", source, expected, got)
+		}
+	}
+
+	// Timestamps from those networks are taken as local time there.
+	if err := sockets.Set("chicago:source-timezone=10.1.0.0/16 = America/Chicago"); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	msg := NewSyslogMessage()
+	msg.clock = clockwork.NewFakeClockAt(time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC))
+	msg.Location = sockets.Lookup("chicago").TimezoneFor("10.1.2.3:514")
+	msg.ParseFormat("<13>Jun  1 06:59:00 host app: x", "10.1.2.3:514", "rfc3164")
+	if expected := time.Date(2016, 6, 1, 11, 59, 0, 0, time.UTC); !msg.Timestamp.Equal(expected) {
+		t.Errorf("Expected %s, got %s", expected, msg.Timestamp)
+	}
+
+	if got := NewSocketConfig("").TimezoneFor("10.1.2.3:514"); got != nil {
+		t.Errorf("Expected no time zone by default, got %v", got)
+	}
+}
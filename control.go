@@ -0,0 +1,51 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// isControl reports whether r is a C0 or C1 control character (or DEL) which
+// could drive a terminal, other than the harmless LF and TAB.
+func isControl(r rune) bool {
+	return (r < 0x20 && r != '\n' && r != '\t') || (r >= 0x7f && r <= 0x9f)
+}
+
+// SanitizeControl deals with the control characters in s, as listed by
+// isControl, so that they can't inject terminal escape sequences when an
+// operator views the entry: mode "escape" replaces them with Go-style escapes
+// ("\x1b", "\u009b"), and "strip" drops them. Any other mode leaves s as it
+// is.
+func SanitizeControl(s string, mode string) string {
+	if mode != "escape" && mode != "strip" || strings.IndexFunc(s, isControl) < 0 {
+		return s
+	}
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case !isControl(r):
+			b.WriteRune(r)
+		case mode == "strip":
+		case r < 0x80:
+			fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		}
+	}
+	return b.String()
+}
+
+// sanitizeControl applies SanitizeControl to the MSG and STRUCTURED-DATA
+// values of msg.
+func (msg *SyslogMessage) sanitizeControl(mode string) {
+	msg.Message = SanitizeControl(msg.Message, mode)
+	for _, params := range msg.StructuredData {
+		for name, value := range params {
+			params[name] = SanitizeControl(value, mode)
+		}
+	}
+}
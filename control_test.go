@@ -0,0 +1,39 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSanitizeControl(t *testing.T) {
+	var tests = []struct {
+		original string
+		mode     string
+		expected string
+	}{
+		{"plain\ttext\n", "escape", "plain\ttext\n"},
+		{"\x1b[31mred\x1b[0m", "escape", `\x1b[31mred\x1b[0m`},
+		{"\x1b[31mred\x1b[0m", "strip", "[31mred[0m"},
+		{"bell\a del\x7f csi\u009b é", "escape", `bell\x07 del\x7f csi\u009b é`},
+		{"bell\a", "keep", "bell\a"},
+		{"bell\a", "", "bell\a"},
+	}
+
+	for num, test := range tests {
+		if got := SanitizeControl(test.original, test.mode); got != test.expected {
+			t.Errorf("Failed test %d:\nOriginal: %q\nExpected: %q\n     Got: %q", num, test.original, test.expected, got)
+		}
+	}
+}
+
+func TestSanitizeMessageControl(t *testing.T) {
+	msg := NewSyslogMessage()
+	msg.ParseFormat("<13>1 2003-10-11T22:14:15Z - - - - [a@1 x=\"\x1b[1mowned\a\"] \x1b[2Jhi", "", "")
+	msg.sanitizeControl("escape")
+	if expected := `\x1b[2Jhi`; msg.Message != expected {
+		t.Errorf("Expected message %q, got %q", expected, msg.Message)
+	}
+	if expected := (StructuredData{"a@1": {"x": `\x1b[1mowned\x07`}}); !reflect.DeepEqual(msg.StructuredData, expected) {
+		t.Errorf("Expected structured data %v, got %v", expected, msg.StructuredData)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// How long a socket's handler is left down after a panic before it's
+// restarted (a variable so tests can shorten it).
+var restartDelay = time.Second
+
+// Drainer keeps track of the sockets, connections and in-flight messages
+// being handled, so that they can be shut down without losing messages.
+type Drainer struct {
+	mu       sync.Mutex
+	draining bool
+	sockets  []io.Closer
+	conns    map[*timeoutConn]struct{}
+	inflight sync.WaitGroup
+	stopping chan struct{}
+	flushers [drainStages][]func()
+}
+
+// Messages held back are flushed in stages, in the order they pass through
+// them: a message released by a SignatureVerifier may be joined to others by
+// a MultilineAggregator, and then counted by a RepeatSuppressor.
+const (
+	drainSigned = iota
+	drainMultiline
+	drainRepeats
+	drainStages
+)
+
+// The Drainer for everything main sets up.
+var drainer = NewDrainer()
+
+func NewDrainer() *Drainer {
+	return &Drainer{conns: map[*timeoutConn]struct{}{}, stopping: make(chan struct{})}
+}
+
+// Stopping returns a channel closed when draining starts, for background
+// loops to stop on.
+func (d *Drainer) Stopping() <-chan struct{} {
+	return d.stopping
+}
+
+// OnDrain registers f to be called at the given stage once everything in
+// flight has been handed off, to send on what's been held back, such as the
+// summaries of repeated messages.
+func (d *Drainer) OnDrain(stage int, f func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.flushers[stage] = append(d.flushers[stage], f)
+}
+
+// AddSocket registers a listener or packet socket to be closed when
+// draining starts.
+func (d *Drainer) AddSocket(socket io.Closer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sockets = append(d.sockets, socket)
+}
+
+// Draining reports whether draining has started.
+func (d *Drainer) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// Go runs f in its own goroutine, which draining waits for. A panic in f is
+// logged rather than taking the whole process down with it.
+func (d *Drainer) Go(f func()) {
+	d.inflight.Add(1)
+	go func() {
+		defer d.inflight.Done()
+		runRecovered("goroutine", f)
+	}()
+}
+
+// Supervise runs handler, which serves the socket called name, in its own
+// goroutine as Go does. If the handler panics it is restarted (after
+// restartDelay), until draining starts; if it returns, it stays stopped.
+func (d *Drainer) Supervise(name string, handler func()) {
+	d.Go(func() {
+		for runRecovered("handler for "+name, handler) && !d.Draining() {
+			log.Printf("restarting handler for %s in %s", name, restartDelay)
+			time.Sleep(restartDelay)
+		}
+	})
+}
+
+// runRecovered calls f, recovering from and logging any panic (along with
+// what was being handled, and the stack). It reports whether f panicked.
+func runRecovered(what string, f func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic in %s: %v\n%s", what, r, debug.Stack())
+			panicked = true
+		}
+	}()
+	f()
+	return false
+}
+
+// socketName describes a socket for log messages.
+func socketName(socket io.Closer) string {
+	switch s := socket.(type) {
+	case net.Listener:
+		return s.Addr().String()
+	case net.PacketConn:
+		return s.LocalAddr().String()
+	case fmt.Stringer:
+		return s.String()
+	}
+	return fmt.Sprintf("%v", socket)
+}
+
+// Track registers a stream connection whose reads should be cut short when
+// draining, returning a function to unregister it once it's closed.
+func (d *Drainer) Track(conn *timeoutConn) (untrack func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		conn.Drain(time.Now())
+	}
+	d.conns[conn] = struct{}{}
+	return func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		delete(d.conns, conn)
+	}
+}
+
+// Drain stops accepting new connections and messages, gives open
+// connections up to grace to finish sending the message they're in the
+// middle of (anything partial is ingested as-is when time runs out), and
+// waits for everything in flight to be handed off to journald, along with
+// what the OnDrain functions were holding back. It reports whether that all
+// happened before the grace period ran out (with a second of slack for the
+// final messages to be submitted).
+func (d *Drainer) Drain(grace time.Duration) bool {
+	deadline := time.Now().Add(grace)
+
+	d.mu.Lock()
+	if !d.draining {
+		close(d.stopping)
+	}
+	d.draining = true
+	flushers := d.flushers
+	for _, socket := range d.sockets {
+		if err := socket.Close(); err != nil {
+			log.Println(err)
+		}
+	}
+	for conn := range d.conns {
+		conn.Drain(deadline)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.inflight.Wait()
+		for _, stage := range flushers {
+			for _, flush := range stage {
+				runRecovered("flush", flush)
+			}
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(grace + time.Second):
+		return false
+	}
+}
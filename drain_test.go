@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	d := NewDrainer()
+	fd, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err.Error())
+	}
+	d.AddSocket(fd)
+
+	ingested := make(chan string, 2)
+	d.Go(func() {
+		for {
+			conn, err := fd.Accept()
+			if err != nil {
+				return
+			}
+			d.Go(func() {
+				defer conn.Close()
+				tconn := newTimeoutConn(conn, 0, 0)
+				defer d.Track(tconn)()
+				ReadStream(tconn, "127.0.0.1", func(buf string, source string) {
+					ingested <- buf
+				})
+			})
+		}
+	})
+
+	client, err := net.Dial("tcp", fd.Addr().String())
+	if err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Close()
+	client.Write([]byte("<13>1 - - a\n<13>1 - - b"))
+	if got := <-ingested; got != "<13>1 - - a" {
+		t.Errorf("Expected the first message, got %q", got)
+	}
+
+	// The client never finishes its second message, so draining has to cut
+	// it off, but shouldn't lose what it did send.
+	if !d.Drain(50 * time.Millisecond) {
+		t.Error("Timed out draining")
+	}
+	select {
+	case got := <-ingested:
+		if got != "<13>1 - - b" {
+			t.Errorf("Expected the partial message, got %q", got)
+		}
+	default:
+		t.Error("Partial message was lost")
+	}
+	if _, err := net.Dial("tcp", fd.Addr().String()); err == nil {
+		t.Error("Still accepting connections after draining")
+	}
+}
+
+func TestSupervise(t *testing.T) {
+	defer func(delay time.Duration) { restartDelay = delay }(restartDelay)
+	restartDelay = time.Millisecond
+
+	d := NewDrainer()
+	runs := 0
+	done := make(chan struct{})
+	d.Supervise("test", func() {
+		runs++
+		if runs < 3 {
+			panic("malformed input")
+		}
+		close(done)
+	})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Handler wasn't restarted")
+	}
+	if !d.Drain(time.Second) {
+		t.Fatal("Timed out waiting for the handler")
+	}
+	if runs != 3 {
+		t.Errorf("Expected the handler to be restarted until it returned, ran %d times", runs)
+	}
+
+	// Panics are contained, and nothing is restarted once draining.
+	runs = 0
+	d.Supervise("test", func() {
+		runs++
+		panic("malformed input")
+	})
+	if !d.Drain(time.Second) {
+		t.Fatal("Timed out waiting for the handler")
+	}
+	if runs != 1 {
+		t.Errorf("Expected no restarts while draining, ran %d times", runs)
+	}
+}
+
+func TestDrainFlushes(t *testing.T) {
+	d := NewDrainer()
+	var order []string
+	d.Go(func() {
+		time.Sleep(10 * time.Millisecond)
+		order = append(order, "inflight")
+	})
+	d.OnDrain(drainRepeats, func() { order = append(order, "repeats") })
+	d.OnDrain(drainSigned, func() { order = append(order, "signed") })
+	if !d.Drain(time.Second) {
+		t.Error("Timed out draining")
+	}
+	select {
+	case <-d.Stopping():
+	default:
+		t.Error("Expected Stopping to be closed")
+	}
+	if expected := []string{"inflight", "signed", "repeats"}; !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected %v, got %v", expected, order)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// DropCounter tracks how many datagrams the kernel has dropped on a socket
+// because its receive queue was full, as reported by SO_RXQ_OVFL.
+type DropCounter struct {
+	Name string
+
+	mu       sync.Mutex
+	dropped  uint32
+	reported uint32
+}
+
+var (
+	dropCountersMu sync.Mutex
+	dropCounters   = map[*net.UDPConn]*DropCounter{}
+)
+
+// DropCounterFor enables SO_RXQ_OVFL on a UDP socket and returns its
+// DropCounter, shared by every goroutine reading from that socket.
+func DropCounterFor(fd *net.UDPConn) *DropCounter {
+	dropCountersMu.Lock()
+	defer dropCountersMu.Unlock()
+	if counter, ok := dropCounters[fd]; ok {
+		return counter
+	}
+
+	counter := &DropCounter{Name: fd.LocalAddr().String()}
+	dropCounters[fd] = counter
+	raw, err := fd.SyscallConn()
+	if err == nil {
+		var sockErr error
+		err = raw.Control(func(s uintptr) {
+			sockErr = unix.SetsockoptInt(int(s), unix.SOL_SOCKET, unix.SO_RXQ_OVFL, 1)
+		})
+		if err == nil {
+			err = sockErr
+		}
+	}
+	if err != nil {
+		log.Printf("can't count dropped datagrams on %s: %s", counter.Name, err)
+	}
+	return counter
+}
+
+// dropCountSpace is the amount of out-of-band data needed to receive the
+// SO_RXQ_OVFL counter.
+var dropCountSpace = unix.CmsgSpace(4)
+
+// Update records the SO_RXQ_OVFL counter found (if any) in the control
+// messages received along with a datagram.
+func (c *DropCounter) Update(oob []byte) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return
+	}
+	for _, msg := range msgs {
+		if msg.Header.Level == unix.SOL_SOCKET && msg.Header.Type == unix.SO_RXQ_OVFL && len(msg.Data) >= 4 {
+			dropped := binary.NativeEndian.Uint32(msg.Data)
+			c.mu.Lock()
+			// The counter only ever grows (modulo wrapping), but readers
+			// sharing a socket may see datagrams out of order.
+			if int32(dropped-c.dropped) > 0 {
+				c.dropped = dropped
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// Report returns the number of datagrams dropped since the last Report.
+func (c *DropCounter) Report() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dropped := c.dropped - c.reported
+	c.reported = c.dropped
+	return dropped
+}
+
+// ReportDrops logs the number of datagrams dropped by the kernel on each UDP
+// socket every interval, for any socket which has dropped some, until stop
+// is closed.
+func ReportDrops(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		dropCountersMu.Lock()
+		for _, counter := range dropCounters {
+			if dropped := counter.Report(); dropped > 0 {
+				log.Printf("kernel dropped %d datagrams on %s in the last %s; its receive queue is full", dropped, counter.Name, interval)
+			}
+		}
+		dropCountersMu.Unlock()
+	}
+}
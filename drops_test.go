@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// rxqOvfl builds the control message the kernel attaches with SO_RXQ_OVFL.
+func rxqOvfl(dropped uint32) []byte {
+	oob := make([]byte, unix.CmsgSpace(4))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&oob[0]))
+	h.Level = unix.SOL_SOCKET
+	h.Type = unix.SO_RXQ_OVFL
+	h.SetLen(unix.CmsgLen(4))
+	binary.NativeEndian.PutUint32(oob[unix.CmsgLen(0):], dropped)
+	return oob
+}
+
+func TestDropCounter(t *testing.T) {
+	counter := &DropCounter{Name: "test"}
+	counter.Update(rxqOvfl(3))
+	counter.Update(rxqOvfl(7))
+	// Readers sharing a socket may see an older count last.
+	counter.Update(rxqOvfl(5))
+	counter.Update(nil)
+	if dropped := counter.Report(); dropped != 7 {
+		t.Errorf("Expected 7 drops, got %d", dropped)
+	}
+	counter.Update(rxqOvfl(10))
+	if dropped := counter.Report(); dropped != 3 {
+		t.Errorf("Expected 3 more drops, got %d", dropped)
+	}
+	if dropped := counter.Report(); dropped != 0 {
+		t.Errorf("Expected no more drops, got %d", dropped)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Bulk requests to Elasticsearch are tried this many times in all, backing
+// off between tries, while it's overloaded or failing, before the entries
+// still unindexed are dropped.
+const elasticAttempts = 5
+
+// elasticDoc is an entry waiting to be indexed, as its bulk action and
+// document lines.
+type elasticDoc struct {
+	action []byte
+	source []byte
+}
+
+// ElasticSink indexes entries in Elasticsearch or OpenSearch with the _bulk
+// API, in batches of up to batchSize or every batchWait, into daily indices
+// named PREFIX-YYYY.MM.DD (by the entry's timestamp, in UTC). Documents are
+// the entry's journal fields, along with MESSAGE, PRIORITY and @timestamp.
+type ElasticSink struct {
+	url     string
+	prefix  string
+	client  *http.Client
+	batches *batcher[*elasticDoc]
+}
+
+func init() {
+	RegisterSink("elastic", func() (Sink, error) {
+		if *elasticURL == "" {
+			return nil, nil
+		}
+		return NewElasticSink(*elasticURL, *elasticIndex, *elasticBatchSize, *elasticBatchWait)
+	})
+}
+
+// NewElasticSink returns an ElasticSink indexing entries at url (the
+// cluster's base URL, such as http://elastic:9200) into indices named
+// prefix-YYYY.MM.DD, and starts it sending.
+func NewElasticSink(url string, prefix string, batchSize int, batchWait time.Duration) (*ElasticSink, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("bad Elasticsearch URL %q", url)
+	}
+	if prefix == "" || strings.ToLower(prefix) != prefix || strings.ContainsAny(prefix, `\/*?"<>| ,#:`) {
+		return nil, fmt.Errorf("bad Elasticsearch index prefix %q", prefix)
+	}
+	if batchSize < 1 {
+		return nil, fmt.Errorf("bad Elasticsearch batch size %d", batchSize)
+	}
+	s := &ElasticSink{
+		url:    strings.TrimSuffix(url, "/") + "/_bulk",
+		prefix: prefix,
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+	s.batches = newBatcher(batchSize, batchWait, s.send)
+	return s, nil
+}
+
+// Write queues a message for indexing, dropping it if the queue is full.
+func (s *ElasticSink) Write(msg *SyslogMessage) error {
+	fields := underlay(map[string]string{
+		"@timestamp": msg.Timestamp.UTC().Format(time.RFC3339Nano),
+	}, msg.Entry)
+	source, err := entryJSON(msg.Message, int(msg.Priority()), fields)
+	if err != nil {
+		return err
+	}
+	index := s.prefix + "-" + msg.Timestamp.UTC().Format("2006.01.02")
+	action, err := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": index}})
+	if err != nil {
+		return err
+	}
+	if !s.batches.Add(&elasticDoc{action: append(action, '\n'), source: source}) {
+		return fmt.Errorf("dropped a message for Elasticsearch, which isn't keeping up")
+	}
+	return nil
+}
+
+// Flush sends the messages queued and batched up, giving up on waiting for
+// them after relayCloseTimeout.
+func (s *ElasticSink) Flush() error {
+	if !s.batches.Flush(relayCloseTimeout) {
+		return fmt.Errorf("gave up waiting for Elasticsearch to take the messages queued for it")
+	}
+	return nil
+}
+
+// Close flushes the sink.
+func (s *ElasticSink) Close() error {
+	return s.Flush()
+}
+
+// send indexes a batch of documents, logging what couldn't be.
+func (s *ElasticSink) send(batch []*elasticDoc) {
+	if err := s.index(batch); err != nil {
+		log.Printf("Elasticsearch: %s", err)
+	}
+}
+
+// index sends a batch of documents to be indexed, trying those Elasticsearch
+// couldn't take yet again until it has them all or it's given up.
+func (s *ElasticSink) index(batch []*elasticDoc) error {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		retry, err := s.bulk(batch)
+		if len(retry) == 0 {
+			return err
+		}
+		if attempt == elasticAttempts {
+			if err == nil {
+				err = fmt.Errorf("too many requests")
+			}
+			return fmt.Errorf("dropped %d messages: %s", len(retry), err)
+		}
+		batch = retry
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// bulk makes a bulk request for a batch, returning the documents worth
+// trying again: all of them, if the request as a whole fails with a server
+// error or a 429, or those rejected with a 429 otherwise. Documents rejected
+// for anything else (as when they don't fit the index's mapping) are logged
+// and dropped.
+func (s *ElasticSink) bulk(batch []*elasticDoc) ([]*elasticDoc, error) {
+	var body bytes.Buffer
+	for _, doc := range batch {
+		body.Write(doc.action)
+		body.Write(doc.source)
+	}
+	resp, err := s.client.Post(s.url, "application/x-ndjson", &body)
+	if err != nil {
+		return batch, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err := fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(reason))
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5 {
+			return batch, err
+		}
+		return nil, fmt.Errorf("dropped %d messages: %s", len(batch), err)
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Status int `json:"status"`
+			Error  struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if !result.Errors {
+		return nil, nil
+	}
+	var retry []*elasticDoc
+	dropped, reason := 0, ""
+	for i, item := range result.Items {
+		if i >= len(batch) {
+			break
+		}
+		for _, status := range item {
+			switch {
+			case status.Status == http.StatusTooManyRequests:
+				retry = append(retry, batch[i])
+			case status.Status/100 != 2:
+				dropped++
+				reason = status.Error.Type + ": " + status.Error.Reason
+			}
+		}
+	}
+	if dropped > 0 {
+		log.Printf("Elasticsearch rejected %d messages (%s)", dropped, reason)
+	}
+	return retry, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestElasticSink(t *testing.T) {
+	requests := make(chan []map[string]string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			http.NotFound(w, r)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var lines []map[string]string
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			var line map[string]interface{}
+			json.Unmarshal(scanner.Bytes(), &line)
+			if action, ok := line["index"].(map[string]interface{}); ok {
+				lines = append(lines, map[string]string{"_index": action["_index"].(string)})
+			} else {
+				lines = append(lines, map[string]string{"MESSAGE": line["MESSAGE"].(string), "@timestamp": line["@timestamp"].(string)})
+			}
+		}
+		requests <- lines
+
+		// Reject the first document once for being too busy, and the
+		// second for good.
+		if len(lines) == 4 {
+			io.WriteString(w, `{"errors": true, "items": [
+				{"index": {"status": 429, "error": {"type": "es_rejected_execution_exception", "reason": "busy"}}},
+				{"index": {"status": 400, "error": {"type": "mapper_parsing_exception", "reason": "bad field"}}}
+			]}`)
+			return
+		}
+		io.WriteString(w, `{"errors": false, "items": [{"index": {"status": 201}}]}`)
+	}))
+	defer server.Close()
+
+	sink, err := NewElasticSink(server.URL, "syslog", 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, buf := range []string{
+		"<13>1 2026-10-16T23:59:59Z host1 app - - - first",
+		"<13>1 2026-10-17T00:00:00Z host1 app - - - second",
+	} {
+		msg := NewSyslogMessage()
+		msg.Parse(buf, "192.0.2.1:514")
+		msg.Entry = msg.Fields()
+		if err := sink.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := [][]map[string]string{
+		{
+			{"_index": "syslog-2026.10.16"},
+			{"MESSAGE": "first", "@timestamp": "2026-10-16T23:59:59Z"},
+			{"_index": "syslog-2026.10.17"},
+			{"MESSAGE": "second", "@timestamp": "2026-10-17T00:00:00Z"},
+		},
+		{
+			{"_index": "syslog-2026.10.16"},
+			{"MESSAGE": "first", "@timestamp": "2026-10-16T23:59:59Z"},
+		},
+	}
+	for num, lines := range expected {
+		select {
+		case got := <-requests:
+			if !reflect.DeepEqual(got, lines) {
+				t.Errorf("Failed request %d: expected %v, got %v", num, lines, got)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for request %d", num)
+		}
+	}
+}
+
+func TestNewElasticSinkErrors(t *testing.T) {
+	tests := []struct {
+		url       string
+		prefix    string
+		batchSize int
+	}{
+		{"elastic:9200", "syslog", 100},
+		{"http://elastic:9200", "Syslog", 100},
+		{"http://elastic:9200", "sys*log", 100},
+		{"http://elastic:9200", "", 100},
+		{"http://elastic:9200", "syslog", 0},
+	}
+
+	for num, test := range tests {
+		if _, err := NewElasticSink(test.url, test.prefix, test.batchSize, time.Second); err == nil {
+			t.Errorf("Failed test %d: expected an error", num)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// staticFields are constant journal fields added to every entry, such as
+// DEPLOYMENT=prod, given with -field or a socket's field= settings.
+type staticFields map[string]string
+
+func (f staticFields) String() string {
+	var fields []string
+	for k, v := range f {
+		fields = append(fields, k+"="+v)
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ",")
+}
+
+// Set adds a field given as NAME=VALUE.
+func (f staticFields) Set(value string) error {
+	name, v, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("bad field %q; expected NAME=VALUE", value)
+	}
+	if err := checkMappedField(name); err != nil {
+		return err
+	}
+	f[name] = v
+	return nil
+}
+
+// globalFields are the fields given with -field, added to entries from
+// every socket.
+var globalFields = staticFields{}
+
+// addStaticFields sets the -field fields, then the socket's own, in an
+// entry's fields. They take precedence over fields from the message, which
+// a sender could otherwise use to pass for another site, but field mappings
+// still apply to them.
+func addStaticFields(vars map[string]string, socket map[string]string) {
+	for k, v := range globalFields {
+		vars[k] = v
+	}
+	for k, v := range socket {
+		vars[k] = v
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStaticFields(t *testing.T) {
+	defer func(saved staticFields) { globalFields = saved }(globalFields)
+	globalFields = staticFields{}
+	if err := globalFields.Set("DEPLOYMENT=prod"); err != nil {
+		t.Fatal(err)
+	}
+	if err := globalFields.Set("SITE=fra1"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		settings string
+		expected map[string]string
+	}{
+		{
+			"format=rfc5424",
+			map[string]string{"DEPLOYMENT": "prod", "SITE": "fra1", "SYSLOG_HOSTNAME": "host"},
+		},
+		{
+			"field=SITE=ams2,field=RACK=r12",
+			map[string]string{"DEPLOYMENT": "prod", "SITE": "ams2", "RACK": "r12", "SYSLOG_HOSTNAME": "host"},
+		},
+		{
+			"field=SITE=ams2,field-rename=SITE=DATACENTER",
+			map[string]string{"DEPLOYMENT": "prod", "DATACENTER": "ams2", "SYSLOG_HOSTNAME": "host"},
+		},
+	}
+
+	for num, test := range tests {
+		sockets := socketConfigs{}
+		if err := sockets.Set("x:" + test.settings); err != nil {
+			t.Errorf("Failed test %d: %s", num, err.Error())
+			continue
+		}
+		config := sockets.Lookup("x")
+		msg := NewSyslogMessage()
+		msg.StaticFields, msg.FieldMappings = config.StaticFields, config.FieldMappings
+		// The message's own SITE gives way to the configured one.
+		vars := map[string]string{"SYSLOG_HOSTNAME": "host", "SITE": "spoofed"}
+		addStaticFields(vars, msg.StaticFields)
+		applyFieldMappings(vars, msg, msg.FieldMappings)
+		if !reflect.DeepEqual(vars, test.expected) {
+			t.Errorf("Failed test %d: expected %v, got %v", num, test.expected, vars)
+		}
+	}
+
+	for _, bad := range []string{"x:field=SITE", "x:field=site=fra1", "x:field=MESSAGE=x", "x:field==x"} {
+		if err := (socketConfigs{}).Set(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
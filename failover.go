@@ -0,0 +1,210 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// An output which fails is skipped for a second, then for twice as long each
+// time it fails again, up to this long, before it's tried again.
+const maxFailoverBackoff = time.Minute
+
+var (
+	errNoMessage       = errors.New("entry has no syslog message for the sink")
+	errEarlierFailures = errors.New("earlier outputs in the failover chain are unavailable")
+)
+
+// confirmingSink is a sink which can be told to report from Write whether a
+// message was delivered, not just queued, as a link in the chain must for
+// the chain to know when to send it on to the next.
+type confirmingSink interface {
+	Sink
+	confirm()
+}
+
+// failoverLink is one output of a FailoverChain, and its health.
+type failoverLink struct {
+	name string
+	send func(entry *journalEntry, cause error) error
+	// ready, if set, reports whether the output should be tried, besides
+	// its health.
+	ready func() bool
+
+	mu       sync.Mutex
+	failures int
+	retryAt  time.Time
+	lastErr  error
+}
+
+// due reports whether the link should be tried, as it's healthy, or has
+// been failing long enough to be tried again.
+func (l *failoverLink) due(now time.Time) bool {
+	if l.ready != nil && !l.ready() {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.failures == 0 || !now.Before(l.retryAt)
+}
+
+func (l *failoverLink) succeeded() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failures > 0 {
+		log.Printf("%s is taking entries again", l.name)
+	}
+	l.failures = 0
+}
+
+func (l *failoverLink) failed(now time.Time, err error, next string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failures == 0 {
+		if next != "" {
+			log.Printf("%s is failing (%s); sending entries to %s until it's back", l.name, err, next)
+		} else {
+			log.Printf("%s is failing (%s)", l.name, err)
+		}
+	}
+	l.failures++
+	l.lastErr = err
+	l.retryAt = now.Add(min(time.Second<<min(l.failures-1, 6), maxFailoverBackoff))
+}
+
+// FailoverChain sends each entry to the first of an ordered list of outputs
+// which takes it, such as journald, then the spool, then an upstream relay.
+// An output which fails is skipped until it's due to be tried again, backing
+// off while it keeps failing; once it works, entries go back to it. The last
+// output is always tried, healthy or not, as it's the only one left.
+type FailoverChain struct {
+	links []*failoverLink
+}
+
+// failover, if set by -failover, replaces the journald, spool and fallback
+// sequence entries otherwise go through.
+var failover *FailoverChain
+
+// NewFailoverChain returns a chain of the named outputs: journald, spool
+// (-spool-dir), fallback (-fallback), or an open sink which confirms
+// delivery, such as file (-file-output) or relay (every -relay). Each must be
+// set up already.
+func NewFailoverChain(names []string) (*FailoverChain, error) {
+	chain := &FailoverChain{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if seen[name] {
+			return nil, fmt.Errorf("%s is in the failover chain more than once", name)
+		}
+		seen[name] = true
+		link := &failoverLink{name: name}
+		switch name {
+		case "journald":
+			link.send = func(e *journalEntry, cause error) error {
+				return SendJournal(e.namespace, e.message, e.priority, e.vars)
+			}
+			// Entries are replayed from the spool in order, so later
+			// ones must join them there until it's empty.
+			link.ready = func() bool {
+				return !(seen["spool"] && spool.Pending())
+			}
+		case "spool":
+			if spool == nil {
+				return nil, fmt.Errorf("failover to the spool needs -spool-dir")
+			}
+			link.send = func(e *journalEntry, cause error) error {
+				return spool.Add(e)
+			}
+		case "fallback":
+			if fallback == nil {
+				return nil, fmt.Errorf("failover to the fallback needs -fallback")
+			}
+			link.send = func(e *journalEntry, cause error) error {
+				if cause == nil {
+					cause = errEarlierFailures
+				}
+				fallback.Write(e, cause)
+				return nil
+			}
+		default:
+			open := lookupSink(name)
+			if open == nil {
+				return nil, fmt.Errorf("bad failover output %q; expected journald, spool, fallback, or a configured sink such as file or relay", name)
+			}
+			// The chain has to know an entry was delivered, not just
+			// queued to be, to send it on elsewhere if it wasn't.
+			sink, ok := open.(confirmingSink)
+			if !ok {
+				return nil, fmt.Errorf("%s can't be in the failover chain: it queues entries to send in the background, so can't tell whether they were delivered", name)
+			}
+			sink.confirm()
+			link.send = func(e *journalEntry, cause error) error {
+				if e.msg == nil {
+					if isLaterPart(e) {
+						// The sink had the whole message with the first.
+						return nil
+					}
+					return errNoMessage
+				}
+				return sink.Write(e.msg)
+			}
+		}
+		chain.links = append(chain.links, link)
+	}
+	if len(chain.links) == 0 {
+		return nil, fmt.Errorf("empty failover chain")
+	}
+	return chain, nil
+}
+
+// Has reports whether the named output is in the chain, so that it only
+// gets the entries its predecessors can't take.
+func (c *FailoverChain) Has(name string) bool {
+	if c == nil {
+		return false
+	}
+	for _, link := range c.links {
+		if link.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// write sends an entry to the first output in the chain which takes it.
+func (c *FailoverChain) write(e *journalEntry) {
+	now := time.Now()
+	var err error
+	for i, link := range c.links {
+		next := ""
+		if i+1 < len(c.links) {
+			next = c.links[i+1].name
+		}
+		if next != "" && !link.due(now) {
+			link.mu.Lock()
+			if link.lastErr != nil {
+				err = link.lastErr
+			}
+			link.mu.Unlock()
+			continue
+		}
+		if err = link.send(e, err); err == nil {
+			link.succeeded()
+			return
+		}
+		link.failed(now, err, next)
+	}
+	journalDrops.Add(1)
+	if e.msg != nil {
+		sourceVolumes.Drop(e.msg.Source)
+	}
+	log.Printf("dropped an entry no output would take: %s", err)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-systemd/journal"
+)
+
+func TestFailoverChain(t *testing.T) {
+	dir := t.TempDir()
+	defer func(saved func(string) string) { journalSocket = saved }(journalSocket)
+	journalSocket = func(namespace string) string { return filepath.Join(dir, namespace) }
+
+	var out bytes.Buffer
+	defer func(saved *Fallback) { fallback = saved }(fallback)
+	fallback = &Fallback{w: &out}
+
+	chain, err := NewFailoverChain([]string{"journald", "fallback"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved *FailoverChain) { failover = saved }(failover)
+	failover = chain
+	entry := &journalEntry{"failover", "hello", journal.PriInfo, map[string]string{"SYSLOG_TIMESTAMP_RFC3339": "2015-12-15T11:54:41Z"}, nil}
+
+	// Nothing is listening on the namespace's socket yet.
+	entry.write()
+	if expected := "2015-12-15T11:54:41Z: hello\n"; out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "failover"), Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// journald is backed off for now, so it's skipped...
+	out.Reset()
+	entry.write()
+	if out.Len() == 0 {
+		t.Errorf("Expected journald to be skipped while backed off")
+	}
+
+	// ...until it's due to be tried again.
+	journald := chain.links[0]
+	journald.retryAt = time.Now().Add(-time.Second)
+	out.Reset()
+	entry.write()
+	if out.Len() != 0 || journald.failures != 0 {
+		t.Errorf("Expected journald to be used again, got %q", out.String())
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	if n, err := conn.Read(buf); err != nil || string(buf[:n]) != "PRIORITY=6\nMESSAGE=hello\nSYSLOG_TIMESTAMP_RFC3339=2015-12-15T11:54:41Z\n" {
+		t.Errorf("Unexpected entry %q, %v", buf[:n], err)
+	}
+}
+
+func TestFailoverChainLastLink(t *testing.T) {
+	relay, err := NewRelay("udp://127.0.0.1:9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved []*openSink) { sinks = saved }(sinks)
+	sinks = []*openSink{{name: "relay", sink: relayGroup{relay}}}
+
+	chain, err := NewFailoverChain([]string{"relay"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Entries without their message (as from the spool) can't be relayed,
+	// but the last link is tried however it's been failing.
+	link := chain.links[0]
+	chain.write(&journalEntry{"", "hello", journal.PriInfo, nil, nil})
+	if link.failures != 1 || link.lastErr != errNoMessage {
+		t.Errorf("Expected the relay to fail, got %d failures, %v", link.failures, link.lastErr)
+	}
+	msg := NewSyslogMessage()
+	msg.Parse("<13>1 2026-10-16T12:00:00Z host1 app - - - hello", "192.0.2.1:514")
+	chain.write(&journalEntry{"", "hello", journal.PriInfo, nil, msg})
+	if link.failures != 0 {
+		t.Errorf("Expected the relay to take the entry")
+	}
+}
+
+func TestNewFailoverChainErrors(t *testing.T) {
+	defer func(saved *Spool) { spool = saved }(spool)
+	spool = nil
+	defer func(saved *Fallback) { fallback = saved }(fallback)
+	fallback = nil
+	defer func(saved []*openSink) { sinks = saved }(sinks)
+	// NATS only queues entries, so can't confirm them.
+	sinks = []*openSink{{name: "nats", sink: &NATSOutput{}}}
+
+	for num, names := range [][]string{
+		{},
+		{"journald", "journald"},
+		{"journald", "spool"},
+		{"journald", "fallback"},
+		{"journald", "relay"},
+		{"journald", "kafka"},
+		{"journald", "nats"},
+	} {
+		if _, err := NewFailoverChain(names); err == nil {
+			t.Errorf("Failed test %d: expected an error for %q", num, names)
+		}
+	}
+}
+
+func TestFailoverChainUnrelayed(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	relay, err := NewRelay("tcp://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved []*openSink) { sinks = saved }(sinks)
+	sinks = []*openSink{{name: "relay", sink: relayGroup{relay}}}
+	var out bytes.Buffer
+	defer func(saved *Fallback) { fallback = saved }(fallback)
+	fallback = &Fallback{w: &out}
+
+	chain, err := NewFailoverChain([]string{"relay", "fallback"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An entry queued for a relay which can't send it isn't taken as
+	// relayed.
+	msg := NewSyslogMessage()
+	msg.Parse("<13>1 2026-10-16T12:00:00Z host1 app - - - hello", "192.0.2.1:514")
+	chain.write(&journalEntry{"", "hello", journal.PriInfo, map[string]string{"SYSLOG_TIMESTAMP_RFC3339": "2026-10-16T12:00:00Z"}, msg})
+	if link := chain.links[0]; link.failures != 1 {
+		t.Errorf("Expected the relay to fail, got %d failures", link.failures)
+	}
+	if expected := "2026-10-16T12:00:00Z: hello\n"; out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestFailoverChainSplit(t *testing.T) {
+	sink := &memorySink{}
+	defer func(saved []*openSink) { sinks = saved }(sinks)
+	sinks = []*openSink{{name: "memory", sink: sink}}
+	chain, err := NewFailoverChain([]string{"memory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved *FailoverChain) { failover = saved }(failover)
+	failover = chain
+	defer func(saved int) { *maxFieldSize = saved }(*maxFieldSize)
+	*maxFieldSize = 16
+	drops := journalDrops.Load()
+
+	// A message split into parts for journald reaches the sink once.
+	msg := NewSyslogMessage()
+	msg.Parse("<13>1 2026-10-16T12:00:00Z host1 app - - - "+strings.Repeat("x", 40), "192.0.2.1:514")
+	msg.Entry = map[string]string{"SYSLOG_IDENTIFIER": "app"}
+	sendEntry(&journalEntry{"", msg.Message, journal.PriInfo, msg.Entry, msg})
+	if expected := []string{"app: " + strings.Repeat("x", 40)}; !reflect.DeepEqual(sink.messages, expected) {
+		t.Errorf("Expected %q, got %q", expected, sink.messages)
+	}
+	if journalDrops.Load() != drops {
+		t.Errorf("Expected no drops, got %d", journalDrops.Load()-drops)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Fallback writes entries which journald couldn't take, such as when it's
+// down or its socket is missing in a container, as lines of text.
+type Fallback struct {
+	mu      sync.Mutex
+	w       io.Writer
+	failing bool
+}
+
+// OpenFallback returns a Fallback writing to stderr for "stderr", or
+// appending to the named file.
+func OpenFallback(name string) (*Fallback, error) {
+	if name == "stderr" {
+		return &Fallback{w: os.Stderr}, nil
+	}
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, err
+	}
+	return &Fallback{w: file}, nil
+}
+
+// fallback, if set by -fallback, takes the entries journald rejects.
+var fallback *Fallback
+
+// Write writes entry, which journald rejected with err, as a line (see
+// formatEntryLine).
+func (f *Fallback) Write(entry *journalEntry, err error) {
+	line := formatEntryLine(entry.message, entry.vars)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.failing {
+		log.Printf("can't send to journald (%s); writing entries to the fallback until it's back", err)
+		f.failing = true
+	}
+	if _, err := io.WriteString(f.w, line); err != nil {
+		log.Println(err)
+	}
+}
+
+// Recovered notes that journald has taken an entry again.
+func (f *Fallback) Recovered() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failing {
+		log.Println("journald is taking entries again")
+		f.failing = false
+	}
+}
+
+// formatEntryLine formats an entry as a line like journalctl's: the time,
+// hostname, identifier and PID, and the message (with newlines escaped).
+func formatEntryLine(message string, vars map[string]string) string {
+	var b strings.Builder
+	b.WriteString(vars["SYSLOG_TIMESTAMP_RFC3339"])
+	for _, field := range []string{"SYSLOG_HOSTNAME", "SYSLOG_IDENTIFIER"} {
+		if value := vars[field]; value != "" {
+			b.WriteString(" " + value)
+		}
+	}
+	if pid := vars["SYSLOG_PID"]; pid != "" {
+		b.WriteString("[" + pid + "]")
+	}
+	b.WriteString(": ")
+	b.WriteString(strings.ReplaceAll(message, "\n", `\n`))
+	b.WriteByte('\n')
+	return b.String()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/go-systemd/journal"
+)
+
+func TestFallback(t *testing.T) {
+	dir := t.TempDir()
+	defer func(saved func(string) string) { journalSocket = saved }(journalSocket)
+	journalSocket = func(namespace string) string { return filepath.Join(dir, namespace) }
+
+	var out bytes.Buffer
+	defer func(saved *Fallback) { fallback = saved }(fallback)
+	fallback = &Fallback{w: &out}
+
+	// Nothing is listening on the namespace's socket yet.
+	entry := &journalEntry{"fallback", "first\nsecond", journal.PriInfo, map[string]string{
+		"SYSLOG_TIMESTAMP_RFC3339": "2015-12-15T11:54:41Z",
+		"SYSLOG_HOSTNAME":          "host",
+		"SYSLOG_IDENTIFIER":        "app",
+		"SYSLOG_PID":               "42",
+	}, nil}
+	entry.write()
+	(&journalEntry{"fallback", "bare", journal.PriInfo, map[string]string{"SYSLOG_TIMESTAMP_RFC3339": "2015-12-15T11:54:42Z"}, nil}).write()
+	if expected := "2015-12-15T11:54:41Z host app[42]: first\\nsecond\n2015-12-15T11:54:42Z: bare\n"; out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+	if !fallback.failing {
+		t.Errorf("Expected the fallback to be in use")
+	}
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "fallback"), Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	out.Reset()
+	entry.write()
+	if out.Len() != 0 || fallback.failing {
+		t.Errorf("Expected journald to be used again, got %q", out.String())
+	}
+}
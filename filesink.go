@@ -0,0 +1,209 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// FileSink appends entries to a file, as lines like journalctl's or as JSON
+// objects, one per line. The file is rotated once it grows past MaxSize or
+// has been open for MaxAge: it's renamed to PATH.1, any older PATH.1 to
+// PATH.2 and so on, keeping Keep of them.
+type FileSink struct {
+	Path    string
+	JSON    bool
+	MaxSize int64
+	MaxAge  time.Duration
+	Keep    int
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+var (
+	fileSinksMu sync.Mutex
+	fileSinks   = map[string]*FileSink{}
+)
+
+// stdoutSink writes entries for -stdout-json. It's never rotated.
+var stdoutSink = &FileSink{Path: "stdout", JSON: true, file: os.Stdout}
+
+// fileOutputs is the sink writing to -file-output, or the file= of the
+// message's socket.
+type fileOutputs struct{}
+
+func init() {
+	RegisterSink("file", func() (Sink, error) {
+		configured := *fileOutput != ""
+		for _, config := range sockets {
+			configured = configured || config.OutputFile != ""
+		}
+		if !configured {
+			return nil, nil
+		}
+		if *fileFormat != "text" && *fileFormat != "json" {
+			return nil, fmt.Errorf("bad -file-format %q; expected text or json", *fileFormat)
+		}
+		return fileOutputs{}, nil
+	})
+}
+
+func (fileOutputs) Write(msg *SyslogMessage) error {
+	path := msg.OutputFile
+	if path == "" {
+		path = *fileOutput
+	}
+	if path == "" {
+		return nil
+	}
+	return fileSinkFor(path).Write(msg.Message, int(msg.Priority()), msg.Entry)
+}
+
+// confirm does nothing: Write reports whether the entry was written already.
+func (fileOutputs) confirm() {}
+
+// Flush does nothing: entries are written as they come.
+func (fileOutputs) Flush() error {
+	return nil
+}
+
+func (fileOutputs) Close() error {
+	fileSinksMu.Lock()
+	defer fileSinksMu.Unlock()
+	for _, sink := range fileSinks {
+		sink.Close()
+	}
+	return nil
+}
+
+// fileSinkFor returns the FileSink for path, set up by the -file-* flags,
+// shared by every socket writing there.
+func fileSinkFor(path string) *FileSink {
+	fileSinksMu.Lock()
+	defer fileSinksMu.Unlock()
+	if sink, ok := fileSinks[path]; ok {
+		return sink
+	}
+	sink := &FileSink{
+		Path:    path,
+		JSON:    *fileFormat == "json",
+		MaxSize: *fileMaxSize,
+		MaxAge:  *fileMaxAge,
+		Keep:    *fileKeep,
+	}
+	fileSinks[path] = sink
+	return sink
+}
+
+// Write appends an entry with the given message and fields.
+func (s *FileSink) Write(message string, priority int, vars map[string]string) error {
+	var line []byte
+	if s.JSON {
+		var err error
+		if line, err = entryJSON(message, priority, vars); err != nil {
+			return err
+		}
+	} else {
+		line = []byte(formatEntryLine(message, vars))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file != nil && s.due(len(line)) {
+		if err := s.rotate(); err != nil {
+			log.Printf("rotating %s: %s", s.Path, err)
+		}
+	}
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// Close closes the file; it's opened again if anything more is written.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// due reports whether the file should be rotated before n more bytes are
+// written to it. The caller holds mu.
+func (s *FileSink) due(n int) bool {
+	if s.size == 0 {
+		return false
+	}
+	if s.MaxSize > 0 && s.size+int64(n) > s.MaxSize {
+		return true
+	}
+	return s.MaxAge > 0 && time.Since(s.opened) >= s.MaxAge
+}
+
+// open opens the file for appending. The caller holds mu.
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.size, s.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+// rotate closes the file and moves it, and those rotated before it, aside.
+// The caller holds mu.
+func (s *FileSink) rotate() error {
+	s.file.Close()
+	s.file = nil
+	if s.Keep < 1 {
+		return os.Remove(s.Path)
+	}
+	os.Remove(fmt.Sprintf("%s.%d", s.Path, s.Keep))
+	for i := s.Keep - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", s.Path, i), fmt.Sprintf("%s.%d", s.Path, i+1))
+	}
+	return os.Rename(s.Path, s.Path+".1")
+}
+
+// entryJSON encodes an entry as a JSON object of its journal fields,
+// MESSAGE and PRIORITY included, followed by a newline.
+func entryJSON(message string, priority int, vars map[string]string) ([]byte, error) {
+	object := make(map[string]string, len(vars)+2)
+	for k, v := range vars {
+		object[k] = v
+	}
+	object["MESSAGE"] = message
+	object["PRIORITY"] = strconv.Itoa(priority)
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(object); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
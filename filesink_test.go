@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.log")
+	sink := &FileSink{Path: path, MaxSize: 100, Keep: 1}
+	vars := map[string]string{
+		"SYSLOG_TIMESTAMP_RFC3339": "2026-10-16T12:00:00Z",
+		"SYSLOG_HOSTNAME":          "host1",
+		"SYSLOG_IDENTIFIER":        "app",
+	}
+	for _, message := range []string{"message 1", "message 2", "message 3", "message 4", "message 5"} {
+		if err := sink.Write(message, 6, vars); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each line is 42 bytes, so two fit in a file.
+	expected := map[string]string{
+		path:        "2026-10-16T12:00:00Z host1 app: message 5\n",
+		path + ".1": "2026-10-16T12:00:00Z host1 app: message 3\n2026-10-16T12:00:00Z host1 app: message 4\n",
+	}
+	for name, contents := range expected {
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Errorf("Expected %s: %s", name, err)
+		} else if string(got) != contents {
+			t.Errorf("Expected %s to contain %q, got %q", name, contents, got)
+		}
+	}
+	if _, err := os.Stat(path + ".2"); err == nil {
+		t.Errorf("Expected only 1 rotated file")
+	}
+}
+
+func TestFileSinkJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.json")
+	sink := &FileSink{Path: path, JSON: true}
+	if err := sink.Write("a <b> & c", 3, map[string]string{"SYSLOG_HOSTNAME": "host1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `{"MESSAGE":"a <b> & c","PRIORITY":"3","SYSLOG_HOSTNAME":"host1"}`+"\n" {
+		t.Errorf("Unexpected line %q", got)
+	}
+	var object map[string]string
+	if err := json.Unmarshal(got, &object); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"MESSAGE": "a <b> & c", "PRIORITY": "3", "SYSLOG_HOSTNAME": "host1"}
+	if !reflect.DeepEqual(object, expected) {
+		t.Errorf("Expected %v, got %v", expected, object)
+	}
+}
+
+func TestStdoutJSON(t *testing.T) {
+	out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved *FileSink) { stdoutSink = saved }(stdoutSink)
+	defer func(saved bool) { *stdoutJSON = saved }(*stdoutJSON)
+	stdoutSink = &FileSink{Path: "stdout", JSON: true, file: out}
+	*stdoutJSON = true
+
+	msg := NewSyslogMessage()
+	msg.Parse("<11>1 2026-10-16T12:00:00Z host1 app 42 - - hello", "192.0.2.1:514")
+	SendMessage(msg, map[string]string{"SYSLOG_SOURCE": "192.0.2.1:514"})
+
+	got, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var object map[string]string
+	if err := json.Unmarshal(got, &object); err != nil {
+		t.Fatalf("Expected a JSON object, got %q", got)
+	}
+	for k, v := range map[string]string{
+		"MESSAGE":           "hello",
+		"PRIORITY":          "3",
+		"SYSLOG_HOSTNAME":   "host1",
+		"SYSLOG_IDENTIFIER": "app",
+		"SYSLOG_PID":        "42",
+		"SYSLOG_SOURCE":     "192.0.2.1:514",
+	} {
+		if object[k] != v {
+			t.Errorf("Expected %s=%q, got %q", k, v, object[k])
+		}
+	}
+}
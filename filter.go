@@ -0,0 +1,213 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// filterOps are the comparisons a filter term may make, longest first so
+// that "<=" isn't taken for "<".
+var filterOps = []string{"<=", ">=", "!=", "!~", "=", "<", ">", "~"}
+
+// filterTerm is one comparison of a Filter, such as "severity<=warning".
+type filterTerm struct {
+	field   string
+	op      string
+	numbers []int
+	strings []string
+	re      *regexp.Regexp
+
+	// networks are the alternatives of a source term given in CIDR
+	// notation, matching every address in them.
+	networks []*net.IPNet
+}
+
+// Filter decides which messages go to a sink. It's a list of terms joined
+// by "and", all of which must hold: severity and facility (by name or
+// number) compare with =, !=, <, <=, > or >=; hostname, tag, source and
+// message with = or != (exactly), or ~ or !~ (a regular expression). = and
+// != take alternatives separated by "|", as in "facility=local6|local7", and
+// source's may be networks, as in "source=10.2.0.0/24". Lower severities are
+// the more severe, so "severity<=warning" takes warnings and worse.
+type Filter struct {
+	terms []filterTerm
+}
+
+// ParseFilter parses a filter expression.
+func ParseFilter(expr string) (*Filter, error) {
+	filter := &Filter{}
+	for _, term := range strings.Split(expr, " and ") {
+		t, err := parseFilterTerm(strings.TrimSpace(term))
+		if err != nil {
+			return nil, err
+		}
+		filter.terms = append(filter.terms, t)
+	}
+	return filter, nil
+}
+
+func parseFilterTerm(term string) (filterTerm, error) {
+	i := strings.IndexAny(term, "=!<>~")
+	if i <= 0 {
+		return filterTerm{}, fmt.Errorf("bad filter term %q", term)
+	}
+	t := filterTerm{field: strings.TrimSpace(term[:i])}
+	for _, op := range filterOps {
+		if strings.HasPrefix(term[i:], op) {
+			t.op = op
+			break
+		}
+	}
+	value := strings.TrimSpace(term[i+len(t.op):])
+	if t.op == "" || value == "" {
+		return filterTerm{}, fmt.Errorf("bad filter term %q", term)
+	}
+
+	switch t.field {
+	case "severity", "facility":
+		parse := ParseSeverity
+		if t.field == "facility" {
+			parse = ParseFacility
+		}
+		if t.op == "~" || t.op == "!~" {
+			return filterTerm{}, fmt.Errorf("bad filter term %q; %s can't be matched with %s", term, t.field, t.op)
+		}
+		values := []string{value}
+		if t.op == "=" || t.op == "!=" {
+			values = strings.Split(value, "|")
+		}
+		for _, value := range values {
+			number, err := parse(value)
+			if err != nil {
+				return filterTerm{}, err
+			}
+			t.numbers = append(t.numbers, number)
+		}
+	case "hostname", "tag", "source", "message":
+		switch t.op {
+		case "=", "!=":
+			for _, value := range strings.Split(value, "|") {
+				if _, network, err := net.ParseCIDR(value); err == nil && t.field == "source" {
+					t.networks = append(t.networks, network)
+				} else {
+					t.strings = append(t.strings, value)
+				}
+			}
+		case "~", "!~":
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return filterTerm{}, err
+			}
+			t.re = re
+		default:
+			return filterTerm{}, fmt.Errorf("bad filter term %q; %s can't be compared with %s", term, t.field, t.op)
+		}
+	default:
+		return filterTerm{}, fmt.Errorf("bad filter term %q; unknown field %q", term, t.field)
+	}
+	return t, nil
+}
+
+// Match reports whether msg passes the filter. A nil Filter passes
+// everything.
+func (f *Filter) Match(msg *SyslogMessage) bool {
+	if f == nil {
+		return true
+	}
+	for _, t := range f.terms {
+		if !t.match(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *filterTerm) match(msg *SyslogMessage) bool {
+	var value string
+	switch t.field {
+	case "severity":
+		return t.compare(msg.Severity)
+	case "facility":
+		return t.compare(msg.Facility)
+	case "hostname":
+		value = msg.Hostname
+	case "tag":
+		value = msg.AppName
+		if value == "" {
+			value = strings.TrimSuffix(msg.Tag, ":")
+		}
+	case "source":
+		value = sourceHost(msg.Source)
+	case "message":
+		value = msg.Message
+	}
+
+	if t.re != nil {
+		return t.re.MatchString(value) == (t.op == "~")
+	}
+	found := false
+	for _, s := range t.strings {
+		found = found || s == value
+	}
+	if ip := net.ParseIP(value); ip != nil {
+		for _, network := range t.networks {
+			found = found || network.Contains(ip)
+		}
+	}
+	return found == (t.op == "=")
+}
+
+func (t *filterTerm) compare(n int) bool {
+	switch t.op {
+	case "<":
+		return n < t.numbers[0]
+	case "<=":
+		return n <= t.numbers[0]
+	case ">":
+		return n > t.numbers[0]
+	case ">=":
+		return n >= t.numbers[0]
+	}
+	found := false
+	for _, number := range t.numbers {
+		found = found || number == n
+	}
+	return found == (t.op == "=")
+}
+
+// sinkFilters holds the filters given with -filter, by sink name.
+type sinkFilters map[string]*Filter
+
+func (f sinkFilters) String() string {
+	return ""
+}
+
+// Set parses a filter given as SINK=EXPR.
+func (f sinkFilters) Set(value string) error {
+	sink, expr, ok := strings.Cut(value, "=")
+	sink = strings.TrimSpace(sink)
+	if !ok {
+		return fmt.Errorf("bad filter %q; expected SINK=EXPRESSION", value)
+	}
+	if _, registered := sinkOpeners[sink]; !registered && !builtinSinkNames[sink] {
+		return fmt.Errorf("bad filter %q; %q is not journald, stdout or a sink (%s)", value, sink, strings.Join(sinkOrder, ", "))
+	}
+	if _, dup := f[sink]; dup {
+		return fmt.Errorf("more than one filter for %s", sink)
+	}
+	filter, err := ParseFilter(expr)
+	if err != nil {
+		return err
+	}
+	f[sink] = filter
+	return nil
+}
+
+// filters are the sinks' filters; sinks without one take every message.
+var filters = sinkFilters{}
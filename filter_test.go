@@ -0,0 +1,86 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		expr     string
+		buf      string
+		expected bool
+	}{
+		{"severity<=warning", "<12>1 2026-10-16T12:00:00Z host1 app - - - hi", true},
+		{"severity<=warning", "<13>1 2026-10-16T12:00:00Z host1 app - - - hi", false},
+		{"severity<4", "<11>1 2026-10-16T12:00:00Z host1 app - - - hi", true},
+		{"severity>=info", "<190>1 2026-10-16T12:00:00Z host1 app - - - hi", true},
+		{"facility=local7", "<190>1 2026-10-16T12:00:00Z host1 app - - - hi", true},
+		{"facility=local7", "<182>1 2026-10-16T12:00:00Z host1 app - - - hi", false},
+		{"facility=local6|local7", "<182>1 2026-10-16T12:00:00Z host1 app - - - hi", true},
+		{"facility!=auth|authpriv", "<86>1 2026-10-16T12:00:00Z host1 sshd - - - hi", false},
+		{"facility = local7 and severity <= 4", "<188>1 2026-10-16T12:00:00Z host1 app - - - hi", true},
+		{"facility = local7 and severity <= 4", "<189>1 2026-10-16T12:00:00Z host1 app - - - hi", false},
+		{"hostname=host1", "<13>1 2026-10-16T12:00:00Z host1 app - - - hi", true},
+		{"hostname!=host1", "<13>1 2026-10-16T12:00:00Z host1 app - - - hi", false},
+		{"hostname~^web[0-9]+$", "<13>1 2026-10-16T12:00:00Z web12 app - - - hi", true},
+		{"hostname!~^web", "<13>1 2026-10-16T12:00:00Z web12 app - - - hi", false},
+		{"tag=sshd", "<13>Oct 16 12:00:00 host1 sshd[42]: hi", true},
+		{"tag=sshd", "<13>1 2026-10-16T12:00:00Z host1 sshd 42 - - hi", true},
+		{"source=192.0.2.1", "<13>1 2026-10-16T12:00:00Z host1 app - - - hi", true},
+		{"source=192.0.2.0/24", "<13>1 2026-10-16T12:00:00Z host1 app - - - hi", true},
+		{"source=10.2.0.0/24|192.0.2.7", "<13>1 2026-10-16T12:00:00Z host1 app - - - hi", false},
+		{"source!=10.2.0.0/24", "<13>1 2026-10-16T12:00:00Z host1 app - - - hi", true},
+		{"message~(?i)error", "<13>1 2026-10-16T12:00:00Z host1 app - - - An ERROR occurred", true},
+	}
+
+	for num, test := range tests {
+		filter, err := ParseFilter(test.expr)
+		if err != nil {
+			t.Errorf("Failed test %d: %s", num, err)
+			continue
+		}
+		msg := NewSyslogMessage()
+		msg.Parse(test.buf, "192.0.2.1:514")
+		if got := filter.Match(msg); got != test.expected {
+			t.Errorf("Failed test %d: expected %v, got %v", num, test.expected, got)
+		}
+	}
+
+	var none *Filter
+	if !none.Match(NewSyslogMessage()) {
+		t.Errorf("Expected a nil filter to match everything")
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for num, expr := range []string{
+		"",
+		"severity",
+		"severity<=",
+		"severity<=loud",
+		"severity~err",
+		"hostname<host1",
+		"hostname~(",
+		"colour=blue",
+		"severity<=4 and",
+	} {
+		if _, err := ParseFilter(expr); err == nil {
+			t.Errorf("Failed test %d: expected an error for %q", num, expr)
+		}
+	}
+}
+
+func TestSinkFiltersSet(t *testing.T) {
+	f := sinkFilters{}
+	if err := f.Set("kafka=severity<=4"); err != nil {
+		t.Fatal(err)
+	}
+	if f["kafka"] == nil {
+		t.Errorf("Expected a filter for kafka")
+	}
+	for num, value := range []string{"kafka=severity<=3", "syslog-ng=severity<=3", "severity<=3"} {
+		if err := f.Set(value); err == nil {
+			t.Errorf("Failed test %d: expected an error for %q", num, value)
+		}
+	}
+}
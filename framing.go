@@ -0,0 +1,163 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+)
+
+// RFC6587: MSG-LEN is a nonzero decimal; nothing we accept needs more digits
+// than this.
+const maxMsgLenDigits = 9
+
+var (
+	errBadFrameLength = errors.New("invalid octet-counted frame length")
+	errFrameTooLong   = errors.New("octet-counted frame exceeds maximum message size")
+)
+
+// ScanFrames is a bufio.SplitFunc which splits a syslog stream into
+// individual messages. Streams using RFC6587 octet-counted framing
+// ("MSG-LEN SP SYSLOG-MSG") are split on the declared message lengths, no
+// matter how the sender's writes were segmented. Anything else is treated as
+// non-transparent framing, with each message terminated by LF or NUL.
+//
+// The framing is detected per message, since a well-formed syslog message
+// always starts with "<" and a frame length never does.
+func ScanFrames(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if data[0] >= '1' && data[0] <= '9' {
+		return scanOctetCounted(data, atEOF, PACKETSIZE)
+	}
+	return scanNonTransparent(data, atEOF)
+}
+
+// scanNonTransparent extracts a single trailer-terminated message from the
+// start of data. The trailer (LF, or NUL as sent by some older relays) and
+// any CR preceding it are dropped. Empty lines are skipped.
+func scanNonTransparent(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if i := bytes.IndexAny(data, "\n\x00"); i >= 0 {
+		if line := bytes.TrimSuffix(data[:i], []byte{'\r'}); len(line) > 0 {
+			return i + 1, line, nil
+		}
+		return i + 1, nil, nil
+	}
+	if atEOF {
+		// The final message on a stream doesn't need a trailer.
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// scanOctetCounted extracts a single "MSG-LEN SP SYSLOG-MSG" frame, of at most
+// max octets, from the start of data, asking for more data until the whole
+// frame is buffered.
+func scanOctetCounted(data []byte, atEOF bool, max int) (advance int, token []byte, err error) {
+	sp, msgLen, err := frameHeader(data, atEOF)
+	if sp == 0 || err != nil {
+		return 0, nil, err
+	}
+	if msgLen > max {
+		return 0, nil, errFrameTooLong
+	}
+
+	frameEnd := sp + 1 + msgLen
+	if frameEnd > len(data) {
+		if atEOF {
+			return 0, nil, errBadFrameLength
+		}
+		return 0, nil, nil
+	}
+	return frameEnd, data[sp+1 : frameEnd], nil
+}
+
+// frameHeader parses the "MSG-LEN SP" at the start of data, returning the
+// offset of the SP and MSG-LEN. The offset is zero if more data is needed.
+func frameHeader(data []byte, atEOF bool) (sp int, msgLen int, err error) {
+	for sp < len(data) && data[sp] >= '0' && data[sp] <= '9' {
+		sp++
+	}
+	if sp > maxMsgLenDigits {
+		return 0, 0, errBadFrameLength
+	}
+	if sp == len(data) {
+		if atEOF {
+			return 0, 0, errBadFrameLength
+		}
+		return 0, 0, nil
+	}
+	if data[sp] != ' ' {
+		return 0, 0, errBadFrameLength
+	}
+
+	msgLen, err = strconv.Atoi(string(data[:sp]))
+	if err != nil {
+		return 0, 0, errBadFrameLength
+	}
+	return sp, msgLen, nil
+}
+
+// FrameScanner splits a syslog stream just like ScanFrames, except that
+// messages longer than Max are cut short rather than failing the stream: the
+// first Max bytes are returned, with Truncated set, and the rest of the
+// message is skipped.
+type FrameScanner struct {
+	Max int
+
+	// Truncated reports whether the last message returned was cut short.
+	Truncated bool
+
+	skip     int  // octets of a truncated frame still to skip
+	skipLine bool // skipping to the trailer of a truncated line
+}
+
+// Split is a bufio.SplitFunc.
+func (s *FrameScanner) Split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if s.skip > 0 {
+		n := min(s.skip, len(data))
+		s.skip -= n
+		return n, nil, nil
+	}
+	if s.skipLine {
+		i := bytes.IndexAny(data, "\n\x00")
+		if i < 0 {
+			return len(data), nil, nil
+		}
+		s.skipLine = false
+		return i + 1, nil, nil
+	}
+	if len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	s.Truncated = false
+	if data[0] >= '1' && data[0] <= '9' {
+		sp, msgLen, err := frameHeader(data, atEOF)
+		if sp == 0 || err != nil || msgLen <= s.Max {
+			return scanOctetCounted(data, atEOF, s.Max)
+		}
+		end := sp + 1 + s.Max
+		if end > len(data) {
+			if atEOF {
+				return 0, nil, errBadFrameLength
+			}
+			return 0, nil, nil
+		}
+		s.skip = msgLen - s.Max
+		s.Truncated = true
+		return end, data[sp+1 : end], nil
+	}
+
+	if i := bytes.IndexAny(data, "\n\x00"); i >= 0 && i <= s.Max || len(data) <= s.Max {
+		return scanNonTransparent(data, atEOF)
+	}
+	s.skipLine = true
+	s.Truncated = true
+	return s.Max, data[:s.Max], nil
+}
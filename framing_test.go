@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestScanFrames(t *testing.T) {
+	var tests = []struct {
+		stream   string
+		expected []string
+	}{
+		{
+			`38 <13>Dec 15 11:55:02 host user: message`,
+			[]string{`<13>Dec 15 11:55:02 host user: message`},
+		},
+		{
+			`11 <13>1 - - a12 <13>1 - - bc`,
+			[]string{`<13>1 - - a`, `<13>1 - - bc`},
+		},
+		{
+			`9 <13>1 - a`,
+			[]string{`<13>1 - a`},
+		},
+		{
+			"<13>1 - - a\n<13>1 - - b\x00<13>1 - - c\r\n\n<13>1 - - d",
+			[]string{`<13>1 - - a`, `<13>1 - - b`, `<13>1 - - c`, `<13>1 - - d`},
+		},
+		{
+			"11 <13>1 - - a\n<13>1 - - b\n",
+			[]string{`<13>1 - - a`, `<13>1 - - b`},
+		},
+	}
+
+	for num, test := range tests {
+		// Feed the stream a byte at a time to exercise reassembly across reads.
+		scanner := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(test.stream)))
+		scanner.Split(ScanFrames)
+		var got []string
+		for scanner.Scan() {
+			got = append(got, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			t.Errorf("Failed test %d: %s", num, err.Error())
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Failed test %d:\nOriginal: %q\nExpected: %q\n     Got: %q", num, test.stream, test.expected, got)
+		}
+	}
+}
+
+func TestFrameScanner(t *testing.T) {
+	var tests = []struct {
+		stream    string
+		expected  []string
+		truncated []bool
+	}{
+		{
+			"11 <13>1 - - a16 <13>1 - - abcdef11 <13>1 - - b",
+			[]string{`<13>1 - - a`, `<13>1 - - abcd`, `<13>1 - - b`},
+			[]bool{false, true, false},
+		},
+		{
+			"<13>1 - - abcdef\n<13>1 - - b\n<13>1 - - abcdefgh",
+			[]string{`<13>1 - - abcd`, `<13>1 - - b`, `<13>1 - - abcd`},
+			[]bool{true, false, true},
+		},
+		{
+			"<13>1 - - abc\n",
+			[]string{`<13>1 - - abc`},
+			[]bool{false},
+		},
+	}
+
+	for num, test := range tests {
+		frames := &FrameScanner{Max: 14}
+		scanner := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(test.stream)))
+		scanner.Split(frames.Split)
+		var got []string
+		var truncated []bool
+		for scanner.Scan() {
+			got = append(got, scanner.Text())
+			truncated = append(truncated, frames.Truncated)
+		}
+		if err := scanner.Err(); err != nil {
+			t.Errorf("Failed test %d: %s", num, err.Error())
+		}
+		if !reflect.DeepEqual(got, test.expected) || !reflect.DeepEqual(truncated, test.truncated) {
+			t.Errorf("Failed test %d:\nOriginal: %q\nExpected: %q %v\n     Got: %q %v", num, test.stream, test.expected, test.truncated, got, truncated)
+		}
+	}
+}
+
+func TestReadStream(t *testing.T) {
+	client, server := net.Pipe()
+	messages := []string{
+		"<13>1 - - a\n",
+		"12 <13>1 - - bc",
+		"<13>Dec 15 11:55:02 host user: message\n",
+	}
+
+	// Send each message as a separate write, waiting for it to be dispatched
+	// before sending the next, like a client with a long-lived connection.
+	ingested := make(chan string)
+	done := make(chan error)
+	go func() {
+		done <- ReadStream(server, "127.0.0.1", func(buf string, source string) {
+			ingested <- buf
+		})
+	}()
+
+	expected := []string{
+		`<13>1 - - a`,
+		`<13>1 - - bc`,
+		`<13>Dec 15 11:55:02 host user: message`,
+	}
+	for num, message := range messages {
+		if _, err := client.Write([]byte(message)); err != nil {
+			t.Fatalf("Failed write %d: %s", num, err.Error())
+		}
+		if got := <-ingested; got != expected[num] {
+			t.Errorf("Failed message %d:\nExpected: %q\n     Got: %q", num, expected[num], got)
+		}
+	}
+	client.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+}
+
+func TestReadStreamLimitPartialFrames(t *testing.T) {
+	client, server := net.Pipe()
+
+	// Writes which split frames (and frame lengths) across reads, as a busy
+	// TCP connection will.
+	writes := []string{
+		"1",
+		"1 <13>1 - ",
+		"- a<13>1 - - b",
+		"c\n<13>1 - - abcdefghij\n2",
+		"0 <13>1 - - abcdefghij",
+	}
+	expected := []string{`<13>1 - - a`, `<13>1 - - bc`, `<13>1 - - abcd`, `<13>1 - - abcd`}
+	truncated := []bool{false, false, true, true}
+
+	type message struct {
+		buf       string
+		truncated bool
+	}
+	ingested := make(chan message, len(expected))
+	done := make(chan error)
+	go func() {
+		done <- ReadStreamLimit(server, "127.0.0.1", 14, func(buf string, source string, truncated bool) {
+			ingested <- message{buf, truncated}
+		})
+	}()
+
+	for num, write := range writes {
+		if _, err := client.Write([]byte(write)); err != nil {
+			t.Fatalf("Failed write %d: %s", num, err.Error())
+		}
+	}
+	client.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+	close(ingested)
+
+	num := 0
+	for got := range ingested {
+		if num >= len(expected) {
+			t.Errorf("Unexpected message %q", got.buf)
+		} else if got.buf != expected[num] || got.truncated != truncated[num] {
+			t.Errorf("Failed message %d:\nExpected: %q %v\n     Got: %q %v", num, expected[num], truncated[num], got.buf, got.truncated)
+		}
+		num++
+	}
+	if num != len(expected) {
+		t.Errorf("Expected %d messages, got %d", len(expected), num)
+	}
+}
@@ -0,0 +1,279 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// GELF messages may be split into at most this many chunks.
+	maxGELFChunks = 128
+
+	// Chunks of a message must all arrive within this long of the first.
+	gelfChunkTimeout = 5 * time.Second
+
+	// Limit how much memory a decompressed message, or the chunks waiting
+	// to be reassembled, can take up.
+	maxGELFMessageSize = 1 << 20
+	maxGELFPending     = 1024
+)
+
+var (
+	gelfChunkMagic = []byte{0x1e, 0x0f}
+	gzipMagic      = []byte{0x1f, 0x8b}
+
+	errBadGELFChunk    = errors.New("malformed GELF chunk")
+	errGELFTooLarge    = errors.New("GELF message too large")
+	errGELFNoMessage   = errors.New("GELF message has no short_message")
+	errGELFTooMuchData = errors.New("too many GELF messages being reassembled")
+)
+
+// gelfPartial is a chunked GELF message being reassembled.
+type gelfPartial struct {
+	chunks   [][]byte
+	received int
+	size     int
+	first    time.Time
+}
+
+// GELFReassembler collects the chunks of GELF messages sent over UDP,
+// handing back each message once all of its chunks have arrived.
+type GELFReassembler struct {
+	mu      sync.Mutex
+	pending map[string]*gelfPartial
+}
+
+func NewGELFReassembler() *GELFReassembler {
+	return &GELFReassembler{pending: map[string]*gelfPartial{}}
+}
+
+// Add takes a GELF datagram, returning the complete (but possibly still
+// compressed) message once one is available. Unchunked datagrams are returned
+// as they are.
+func (r *GELFReassembler) Add(datagram []byte, now time.Time) ([]byte, error) {
+	if !bytes.HasPrefix(datagram, gelfChunkMagic) {
+		return datagram, nil
+	}
+	if len(datagram) < 12 {
+		return nil, errBadGELFChunk
+	}
+	id := string(datagram[2:10])
+	seq, count := int(datagram[10]), int(datagram[11])
+	if count == 0 || count > maxGELFChunks || seq >= count {
+		return nil, errBadGELFChunk
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	partial, ok := r.pending[id]
+	if ok && now.Sub(partial.first) > gelfChunkTimeout {
+		delete(r.pending, id)
+		ok = false
+	}
+	if !ok {
+		r.expire(now)
+		if len(r.pending) >= maxGELFPending {
+			return nil, errGELFTooMuchData
+		}
+		partial = &gelfPartial{chunks: make([][]byte, count), first: now}
+		r.pending[id] = partial
+	}
+	if len(partial.chunks) != count {
+		delete(r.pending, id)
+		return nil, errBadGELFChunk
+	}
+	if partial.chunks[seq] != nil {
+		// A duplicate; ignore it.
+		return nil, nil
+	}
+
+	chunk := append([]byte(nil), datagram[12:]...)
+	partial.chunks[seq] = chunk
+	partial.received++
+	partial.size += len(chunk)
+	if partial.size > maxGELFMessageSize {
+		delete(r.pending, id)
+		return nil, errGELFTooLarge
+	}
+	if partial.received < count {
+		return nil, nil
+	}
+
+	delete(r.pending, id)
+	return bytes.Join(partial.chunks, nil), nil
+}
+
+// expire drops messages whose chunks didn't all arrive in time.
+func (r *GELFReassembler) expire(now time.Time) {
+	for id, partial := range r.pending {
+		if now.Sub(partial.first) > gelfChunkTimeout {
+			delete(r.pending, id)
+		}
+	}
+}
+
+// DecompressGELF undoes the zlib or gzip compression (if any) of a GELF
+// message.
+func DecompressGELF(buf []byte) ([]byte, error) {
+	var reader io.Reader
+	var err error
+	switch {
+	case bytes.HasPrefix(buf, gzipMagic):
+		reader, err = gzip.NewReader(bytes.NewReader(buf))
+	case len(buf) >= 2 && buf[0]&0x0f == 8 && (uint16(buf[0])<<8|uint16(buf[1]))%31 == 0:
+		// A zlib header: deflate, with a valid check value.
+		reader, err = zlib.NewReader(bytes.NewReader(buf))
+	default:
+		return buf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(reader, maxGELFMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxGELFMessageSize {
+		return nil, errGELFTooLarge
+	}
+	return data, nil
+}
+
+// ParseGELF turns a GELF payload into a SyslogMessage, plus journal fields
+// for whatever doesn't have a syslog equivalent: full_message becomes
+// GELF_FULL_MESSAGE, file and line become CODE_FILE and CODE_LINE, and
+// additional "_name" fields become GELF_NAME.
+func ParseGELF(config *SocketConfig, buf []byte, source string) (*SyslogMessage, map[string]string, error) {
+	var gelf map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+	if err := decoder.Decode(&gelf); err != nil {
+		return nil, nil, err
+	}
+
+	msg := NewSyslogMessage()
+	msg.Source = source
+	msg.Facility = config.Facility
+	msg.StaticFields = config.StaticFields
+	msg.SDFields = config.SDFields
+	msg.FieldMappings = config.FieldMappings
+	msg.Namespace = config.Namespace
+	msg.OutputFile = config.OutputFile
+	// GELF's default level is 1 (alert).
+	msg.Severity = 1
+	extra := map[string]string{}
+
+	for key, value := range gelf {
+		text := jsonString(value)
+		switch key {
+		case "version":
+		case "host":
+			msg.Hostname = text
+		case "short_message":
+			msg.Message = text
+		case "full_message":
+			extra["GELF_FULL_MESSAGE"] = text
+		case "timestamp":
+			if ts, err := strconv.ParseFloat(text, 64); err == nil {
+				sec, frac := math.Modf(ts)
+				msg.Timestamp = time.Unix(int64(sec), int64(frac*1e9)).UTC()
+				msg.OriginalTimestamp = text
+			}
+		case "level":
+			if level, err := strconv.Atoi(text); err == nil && level >= 0 && level <= 7 {
+				msg.Severity = level
+			}
+		case "facility":
+			// Deprecated, and often used for the application name rather
+			// than a syslog facility.
+			if facility, err := ParseFacility(text); err == nil {
+				msg.Facility = facility
+			} else {
+				msg.AppName = text
+			}
+		case "file":
+			extra["CODE_FILE"] = text
+		case "line":
+			extra["CODE_LINE"] = text
+		default:
+			if len(key) > 1 && key[0] == '_' && key != "_id" {
+				if name := JournalFieldName("GELF_", key[1:]); name != "" {
+					extra[name] = text
+				}
+			}
+		}
+	}
+
+	if msg.Message == "" {
+		return nil, nil, errGELFNoMessage
+	}
+	return msg, extra, nil
+}
+
+// HandleGELFPacket takes a UDP socket and repeatedly reads GELF datagrams
+// from it, reassembling chunked messages and logging each complete message
+// to journald. Bad ones are counted as rejected, and only logged each with
+// -log-rejected, so a misbehaving sender can't flood the log. It returns
+// when the socket is closed.
+func HandleGELFPacket(fd net.PacketConn, config *SocketConfig) {
+	reassembler := NewGELFReassembler()
+	buf := make([]byte, MAXDATAGRAMSIZE)
+	for {
+		count, addr, err := fd.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Println(err)
+			continue
+		}
+
+		source := addr.String()
+		message, err := reassembler.Add(buf[:count], time.Now())
+		if err != nil {
+			Reject(config, source, fmt.Errorf("bad GELF: %w", err))
+			continue
+		}
+		if message == nil {
+			continue
+		}
+		// An unchunked message is the datagram itself, so it's copied
+		// out before buf is read into again; reassembled ones are
+		// copies already.
+		if !bytes.HasPrefix(buf[:count], gelfChunkMagic) {
+			message = bytes.Clone(message)
+		}
+		sourceVolumes.Count(source, len(message))
+		drainer.Go(func() {
+			data, err := DecompressGELF(message)
+			if err != nil {
+				Reject(config, source, fmt.Errorf("bad GELF: %w", err))
+				return
+			}
+			msg, extra, err := ParseGELF(config, data, source)
+			if err != nil {
+				Reject(config, source, fmt.Errorf("bad GELF: %w", err))
+				return
+			}
+			SendMessage(msg, transportFields("udp", extra))
+		})
+	}
+}
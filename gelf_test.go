@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func gelfChunk(id string, seq int, count int, data string) []byte {
+	chunk := append([]byte{0x1e, 0x0f}, id...)
+	chunk = append(chunk, byte(seq), byte(count))
+	return append(chunk, data...)
+}
+
+func TestGELFReassembler(t *testing.T) {
+	now := time.Unix(1000, 0)
+	r := NewGELFReassembler()
+
+	if out, err := r.Add([]byte(`{"a":1}`), now); err != nil || string(out) != `{"a":1}` {
+		t.Errorf("Unchunked: got %q, %v", out, err)
+	}
+
+	// Out of order, with a duplicate.
+	for _, chunk := range [][]byte{
+		gelfChunk("ABCDEFGH", 2, 3, "ghi"),
+		gelfChunk("ABCDEFGH", 0, 3, "abc"),
+		gelfChunk("ABCDEFGH", 0, 3, "xxx"),
+	} {
+		if out, err := r.Add(chunk, now); err != nil || out != nil {
+			t.Fatalf("Incomplete message: got %q, %v", out, err)
+		}
+	}
+	out, err := r.Add(gelfChunk("ABCDEFGH", 1, 3, "def"), now)
+	if err != nil || string(out) != "abcdefghi" {
+		t.Errorf("Reassembled: got %q, %v", out, err)
+	}
+
+	// Chunks arriving too late start over.
+	r.Add(gelfChunk("12345678", 0, 2, "old"), now)
+	out, err = r.Add(gelfChunk("12345678", 1, 2, "new"), now.Add(gelfChunkTimeout+time.Second))
+	if err != nil || out != nil {
+		t.Errorf("Expired: got %q, %v", out, err)
+	}
+
+	for _, bad := range [][]byte{
+		{0x1e, 0x0f, 1, 2, 3},
+		gelfChunk("ABCDEFGH", 0, 0, ""),
+		gelfChunk("ABCDEFGH", 3, 3, ""),
+		gelfChunk("ABCDEFGH", 0, maxGELFChunks+1, ""),
+	} {
+		if _, err := r.Add(bad, now); err != errBadGELFChunk {
+			t.Errorf("Chunk %q: expected errBadGELFChunk, got %v", bad, err)
+		}
+	}
+}
+
+func TestDecompressGELF(t *testing.T) {
+	message := `{"short_message":"hello"}`
+
+	var zbuf, gzbuf bytes.Buffer
+	zw := zlib.NewWriter(&zbuf)
+	zw.Write([]byte(message))
+	zw.Close()
+	gw := gzip.NewWriter(&gzbuf)
+	gw.Write([]byte(message))
+	gw.Close()
+
+	for name, buf := range map[string][]byte{
+		"plain": []byte(message),
+		"zlib":  zbuf.Bytes(),
+		"gzip":  gzbuf.Bytes(),
+	} {
+		out, err := DecompressGELF(buf)
+		if err != nil || string(out) != message {
+			t.Errorf("%s: got %q, %v", name, out, err)
+		}
+	}
+}
+
+func TestParseGELF(t *testing.T) {
+	payload := `{"version":"1.1","host":"example.org","short_message":"A short message",
+		"full_message":"Backtrace here\n\nmore stuff","timestamp":1385053862.3072,"level":3,
+		"facility":"myapp","file":"main.go","line":42,
+		"_user_id":9001,"_some.info":"foo","_id":"ignored","_nested":{"a":true}}`
+
+	msg, extra, err := ParseGELF(NewSocketConfig(""), []byte(payload), "192.0.2.1:5000")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	if msg.Hostname != "example.org" || msg.Message != "A short message" || msg.AppName != "myapp" ||
+		msg.Severity != 3 || msg.Facility != 0 || msg.Source != "192.0.2.1:5000" {
+		t.Errorf("Unexpected message: %+v", msg)
+	}
+	if ts := time.Unix(1385053862, 307200000).UTC(); msg.Timestamp.Sub(ts).Abs() > time.Microsecond {
+		t.Errorf("Timestamp: expected %s, got %s", ts, msg.Timestamp)
+	}
+
+	expected := map[string]string{
+		"GELF_FULL_MESSAGE": "Backtrace here\n\nmore stuff",
+		"CODE_FILE":         "main.go",
+		"CODE_LINE":         "42",
+		"GELF_USER_ID":      "9001",
+		"GELF_SOME_INFO":    "foo",
+		"GELF_NESTED":       `{"a":true}`,
+	}
+	if !reflect.DeepEqual(extra, expected) {
+		t.Errorf("Fields:\nExpected: %q\n     Got: %q", expected, extra)
+	}
+
+	// A facility name is taken as a syslog facility.
+	msg, _, err = ParseGELF(NewSocketConfig(""), []byte(`{"short_message":"x","facility":"local3"}`), "")
+	if err != nil || msg.Facility != 19 || msg.AppName != "" || msg.Severity != 1 {
+		t.Errorf("Facility: got %+v, %v", msg, err)
+	}
+
+	if _, _, err := ParseGELF(NewSocketConfig(""), []byte(`{"host":"x"}`), ""); err != errGELFNoMessage {
+		t.Errorf("Expected errGELFNoMessage, got %v", err)
+	}
+}
+
+func TestHandleGELFPacket(t *testing.T) {
+	out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved *FileSink) { stdoutSink = saved }(stdoutSink)
+	defer func(saved bool) { *stdoutJSON = saved }(*stdoutJSON)
+	stdoutSink = &FileSink{Path: "stdout", JSON: true, file: out}
+	*stdoutJSON = true
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved map[string]int) { rejectCounts = saved }(rejectCounts)
+	rejectCounts = map[string]int{}
+	config := NewSocketConfig("gelf-test")
+	if err := config.Set("field=SITE=lab"); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		HandleGELFPacket(conn, config)
+		close(done)
+	}()
+	defer func() {
+		conn.Close()
+		<-done
+	}()
+
+	// The reader's buffer is reused, so each message must be copied out of
+	// it before the next arrives.
+	sender, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	// Datagrams that aren't GELF are counted, not each logged.
+	fmt.Fprint(sender, "not GELF")
+	expected := []string{}
+	for i := 0; i < 20; i++ {
+		message := strings.Repeat(strconv.Itoa(i%10), 10+i)
+		expected = append(expected, "lab "+message)
+		fmt.Fprintf(sender, `{"version":"1.1","host":"example.org","short_message":%q}`, message)
+	}
+
+	var got []string
+	for deadline := time.Now().Add(5 * time.Second); len(got) < len(expected) && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		stdoutSink.mu.Lock()
+		data, _ := os.ReadFile(out.Name())
+		stdoutSink.mu.Unlock()
+		got = nil
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var object map[string]string
+			if json.Unmarshal([]byte(line), &object) == nil {
+				// The socket's settings apply.
+				got = append(got, object["SITE"]+" "+object["MESSAGE"])
+			}
+		}
+	}
+	sort.Strings(got)
+	sort.Strings(expected)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	rejected := 0
+	for deadline := time.Now().Add(5 * time.Second); rejected == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		rejectCountsMu.Lock()
+		rejected = rejectCounts["gelf-test"]
+		rejectCountsMu.Unlock()
+	}
+	if rejected != 1 {
+		t.Errorf("Expected 1 rejected datagram, got %d", rejected)
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GELF datagrams sent are split into chunks of at most this many bytes,
+// header included, which Graylog suggests for networks with jumbo-ish MTUs.
+const gelfChunkSize = 8192
+
+var errGELFTooManyChunks = errors.New("GELF message too large to chunk")
+
+// FormatGELF encodes an entry as a GELF 1.1 message. The first line of the
+// message becomes short_message, and the whole of it full_message if there's
+// more; journal fields become additional fields, named in lowercase (with
+// the GELF_ prefix of those ParseGELF made dropped again).
+func FormatGELF(msg *SyslogMessage, priority int, vars map[string]string) ([]byte, error) {
+	hostname := msg.Hostname
+	if hostname == "" && msg.Source != "" {
+		hostname = sourceHost(msg.Source)
+	}
+	gelf := map[string]interface{}{
+		"version":   "1.1",
+		"host":      hostname,
+		"timestamp": json.Number(fmt.Sprintf("%d.%06d", msg.Timestamp.Unix(), msg.Timestamp.Nanosecond()/1000)),
+		"level":     priority,
+	}
+	short, _, multiline := strings.Cut(msg.Message, "\n")
+	if short == "" {
+		// Graylog insists on a short_message.
+		short = "-"
+	}
+	gelf["short_message"] = short
+	if multiline {
+		gelf["full_message"] = msg.Message
+	}
+	for k, v := range vars {
+		name := "_" + strings.ToLower(strings.TrimPrefix(k, "GELF_"))
+		if name == "_id" || name == "_full_message" {
+			continue
+		}
+		gelf[name] = v
+	}
+
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(gelf); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+// chunkGELF splits a GELF message into datagrams of at most size bytes.
+func chunkGELF(message []byte, size int) ([][]byte, error) {
+	if len(message) <= size {
+		return [][]byte{message}, nil
+	}
+	per := size - 12
+	count := (len(message) + per - 1) / per
+	if count > maxGELFChunks {
+		return nil, errGELFTooManyChunks
+	}
+	var id [8]byte
+	rand.Read(id[:])
+	chunks := make([][]byte, 0, count)
+	for seq := 0; seq < count; seq++ {
+		chunk := append([]byte(nil), gelfChunkMagic...)
+		chunk = append(chunk, id[:]...)
+		chunk = append(chunk, byte(seq), byte(count))
+		chunk = append(chunk, message[seq*per:min((seq+1)*per, len(message))]...)
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// compressGELF compresses a GELF message with gzip or zlib, or returns it as
+// it is for "none".
+func compressGELF(message []byte, compression string) []byte {
+	var b bytes.Buffer
+	switch compression {
+	case "gzip":
+		w := gzip.NewWriter(&b)
+		w.Write(message)
+		w.Close()
+	case "zlib":
+		w := zlib.NewWriter(&b)
+		w.Write(message)
+		w.Close()
+	default:
+		return message
+	}
+	return b.Bytes()
+}
+
+// GELFOutput sends entries to a Graylog GELF input: over UDP, compressed and
+// chunked as needed, or over TCP, uncompressed and NUL-terminated as GELF
+// requires there. Like a Relay, it sends from a queue, so that a slow or
+// missing server doesn't hold up anything else.
+type GELFOutput struct {
+	network     string
+	addr        string
+	compression string
+	dial        func() (net.Conn, error)
+
+	queue  chan []byte
+	done   chan struct{}
+	mu     sync.Mutex
+	closed bool
+}
+
+func init() {
+	RegisterSink("gelf", func() (Sink, error) {
+		if *gelfServer == "" {
+			return nil, nil
+		}
+		return NewGELFOutput(*gelfServer, *gelfCompression)
+	})
+}
+
+// NewGELFOutput returns a GELFOutput to a server given as udp://HOST:PORT or
+// tcp://HOST:PORT, compressing UDP messages with compression ("gzip", "zlib"
+// or "none"), and starts it sending.
+func NewGELFOutput(server string, compression string) (*GELFOutput, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "udp" && u.Scheme != "tcp") || u.Port() == "" || u.Path != "" {
+		return nil, fmt.Errorf("bad GELF output %q; expected udp://HOST:PORT or tcp://HOST:PORT", server)
+	}
+	switch compression {
+	case "gzip", "zlib", "none":
+	default:
+		return nil, fmt.Errorf("bad GELF compression %q; expected gzip, zlib or none", compression)
+	}
+	o := &GELFOutput{network: u.Scheme, addr: u.Host, compression: compression, queue: make(chan []byte, relayQueueSize), done: make(chan struct{})}
+	o.dial = func() (net.Conn, error) {
+		return net.DialTimeout(o.network, o.addr, 10*time.Second)
+	}
+	go o.run()
+	return o, nil
+}
+
+// Write queues a message for the server, dropping it if the queue is full.
+func (o *GELFOutput) Write(msg *SyslogMessage) error {
+	message, err := FormatGELF(msg, int(msg.Priority()), msg.Entry)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return fmt.Errorf("GELF output to %s is closed", o.addr)
+	}
+	select {
+	case o.queue <- message:
+		return nil
+	default:
+		return fmt.Errorf("dropped a message for GELF server %s, which isn't keeping up", o.addr)
+	}
+}
+
+// Flush does nothing: messages are sent as soon as they can be.
+func (o *GELFOutput) Flush() error {
+	return nil
+}
+
+// Close stops the output once it's sent the messages queued, or given up on
+// them after relayCloseTimeout.
+func (o *GELFOutput) Close() error {
+	o.mu.Lock()
+	if !o.closed {
+		o.closed = true
+		close(o.queue)
+	}
+	o.mu.Unlock()
+	select {
+	case <-o.done:
+		return nil
+	case <-time.After(relayCloseTimeout):
+		return fmt.Errorf("gave up sending %d messages to %s", len(o.queue), o.addr)
+	}
+}
+
+func (o *GELFOutput) run() {
+	defer close(o.done)
+	var conn net.Conn
+	for message := range o.queue {
+		if conn == nil {
+			var err error
+			if conn, err = o.dial(); err != nil {
+				log.Printf("can't send GELF to %s: %s", o.addr, err)
+				continue
+			}
+		}
+		if err := o.write(conn, message); err != nil {
+			log.Printf("can't send GELF to %s: %s", o.addr, err)
+			conn.Close()
+			conn = nil
+		}
+	}
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+func (o *GELFOutput) write(conn net.Conn, message []byte) error {
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if o.network == "tcp" {
+		_, err := conn.Write(append(message, 0))
+		return err
+	}
+	chunks, err := chunkGELF(compressGELF(message, o.compression), gelfChunkSize)
+	if err != nil {
+		// Not the connection's fault; keep it.
+		log.Printf("can't send GELF to %s: %s", o.addr, err)
+		return nil
+	}
+	for _, chunk := range chunks {
+		if _, err := conn.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatGELF(t *testing.T) {
+	msg := NewSyslogMessage()
+	msg.Parse("<11>1 2026-10-16T12:00:00.25Z host1 app 42 - - first line\nsecond line", "192.0.2.1:514")
+	vars := map[string]string{"SYSLOG_IDENTIFIER": "app", "GELF_REQUEST_ID": "abc"}
+	data, err := FormatGELF(msg, int(msg.Priority()), vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// What we send, we should be able to read back.
+	got, extra, err := ParseGELF(&SocketConfig{}, data, "192.0.2.1:12201")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Hostname != "host1" || got.Message != "first line" || got.Severity != 3 {
+		t.Errorf("Unexpected message %+v", got)
+	}
+	if !got.Timestamp.Equal(time.Date(2026, 10, 16, 12, 0, 0, 250000000, time.UTC)) {
+		t.Errorf("Unexpected timestamp %s", got.Timestamp)
+	}
+	expected := map[string]string{
+		"GELF_FULL_MESSAGE":      "first line\nsecond line",
+		"GELF_SYSLOG_IDENTIFIER": "app",
+		"GELF_REQUEST_ID":        "abc",
+	}
+	if !reflect.DeepEqual(extra, expected) {
+		t.Errorf("Expected %v, got %v", expected, extra)
+	}
+}
+
+func TestChunkGELF(t *testing.T) {
+	message := []byte(strings.Repeat("0123456789", 100))
+	chunks, err := chunkGELF(message, 112)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 10 {
+		t.Fatalf("Expected 10 chunks, got %d", len(chunks))
+	}
+
+	// Reassemble them out of order.
+	reassembler := NewGELFReassembler()
+	var got []byte
+	for i := len(chunks) - 1; i >= 0; i-- {
+		if len(chunks[i]) > 112 {
+			t.Errorf("Chunk %d is %d bytes", i, len(chunks[i]))
+		}
+		if got, err = reassembler.Add(chunks[i], time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if string(got) != string(message) {
+		t.Errorf("Expected %q, got %q", message, got)
+	}
+
+	if _, err := chunkGELF(make([]byte, 200*100), 112); err != errGELFTooManyChunks {
+		t.Errorf("Expected %v, got %v", errGELFTooManyChunks, err)
+	}
+}
+
+func TestGELFOutputUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, compression := range []string{"gzip", "zlib", "none"} {
+		output, err := NewGELFOutput("udp://"+conn.LocalAddr().String(), compression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := NewSyslogMessage()
+		msg.Parse("<13>1 2026-10-16T12:00:00Z host1 app - - - hello "+compression, "192.0.2.1:514")
+		if err := output.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, MAXDATAGRAMSIZE)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := DecompressGELF(buf[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _, err := ParseGELF(&SocketConfig{}, data, "127.0.0.1:12201")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Message != "hello "+compression {
+			t.Errorf("Expected %q, got %q", "hello "+compression, got.Message)
+		}
+	}
+}
+
+func TestGELFOutputTCP(t *testing.T) {
+	// Each dial gets the next connection sent here, or fails for nil.
+	dials := make(chan net.Conn)
+	output := &GELFOutput{network: "tcp", addr: "graylog:12201", queue: make(chan []byte, relayQueueSize), done: make(chan struct{})}
+	output.dial = func() (net.Conn, error) {
+		if conn := <-dials; conn != nil {
+			return conn, nil
+		}
+		return nil, errors.New("connection refused")
+	}
+	go output.run()
+
+	write := func(text string) {
+		msg := NewSyslogMessage()
+		msg.Parse("<13>1 2026-10-16T12:00:00Z host1 app - - - "+text, "192.0.2.1:514")
+		if err := output.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(server net.Conn) string {
+		server.SetReadDeadline(time.Now().Add(5 * time.Second))
+		data, err := bufio.NewReader(server).ReadBytes(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _, err := ParseGELF(&SocketConfig{}, data[:len(data)-1], "127.0.0.1:12201")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.Message
+	}
+
+	// A message that can't be sent is dropped, not held up for a retry.
+	write("lost while down")
+	dials <- nil
+	write("sent")
+	client, server := net.Pipe()
+	dials <- client
+	if got := read(server); got != "sent" {
+		t.Errorf("Expected %q, got %q", "sent", got)
+	}
+
+	// Once the connection fails, the output dials again for the next one.
+	server.Close()
+	write("lost with the connection")
+	write("sent again")
+	client, server = net.Pipe()
+	dials <- client
+	if got := read(server); got != "sent again" {
+		t.Errorf("Expected %q, got %q", "sent again", got)
+	}
+
+	if err := output.Close(); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+	msg := NewSyslogMessage()
+	msg.Parse("<13>1 2026-10-16T12:00:00Z host1 app - - - late", "192.0.2.1:514")
+	if err := output.Write(msg); err == nil {
+		t.Errorf("Expected an error writing after Close")
+	}
+}
+
+func TestNewGELFOutputErrors(t *testing.T) {
+	tests := []struct {
+		server      string
+		compression string
+	}{
+		{"tls://host:12201", "gzip"},
+		{"udp://host", "gzip"},
+		{"udp://host:12201", "bzip2"},
+	}
+
+	for num, test := range tests {
+		if _, err := NewGELFOutput(test.server, test.compression); err == nil {
+			t.Errorf("Failed test %d: expected an error", num)
+		}
+	}
+}
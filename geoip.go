@@ -0,0 +1,81 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoRecord holds what GeoIP looks up in a MaxMind database: the country
+// and city from a Country or City database, and the autonomous system from
+// an ASN one.
+type geoRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	ASN   uint   `maxminddb:"autonomous_system_number"`
+	ASOrg string `maxminddb:"autonomous_system_organization"`
+}
+
+// geoReader is a MaxMind database, as maxminddb.Reader reads them.
+type geoReader interface {
+	Lookup(ip net.IP, result interface{}) error
+}
+
+// GeoIP looks senders' addresses up in MaxMind (GeoIP2 or GeoLite2)
+// databases, for the country, city and autonomous system they're in.
+type GeoIP struct {
+	readers []geoReader
+}
+
+// geoIP, if -geoip-db is given, adds where senders are to their entries.
+var geoIP *GeoIP
+
+// OpenGeoIP opens the MaxMind databases at paths, typically a City or
+// Country database and an ASN one.
+func OpenGeoIP(paths []string) (*GeoIP, error) {
+	g := &GeoIP{}
+	for _, path := range paths {
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		g.readers = append(g.readers, reader)
+	}
+	return g, nil
+}
+
+// Fields returns what the databases know of ip as journal fields:
+// SYSLOG_SOURCE_COUNTRY (an ISO 3166 code), SYSLOG_SOURCE_CITY (its English
+// name), SYSLOG_SOURCE_ASN and SYSLOG_SOURCE_AS_ORG. Private addresses, and
+// others the databases don't know, get none.
+func (g *GeoIP) Fields(ip net.IP) map[string]string {
+	var record geoRecord
+	for _, reader := range g.readers {
+		// A database without the address leaves record as it was.
+		reader.Lookup(ip, &record)
+	}
+
+	fields := map[string]string{}
+	if record.Country.ISOCode != "" {
+		fields["SYSLOG_SOURCE_COUNTRY"] = record.Country.ISOCode
+	}
+	if city := record.City.Names["en"]; city != "" {
+		fields["SYSLOG_SOURCE_CITY"] = city
+	}
+	if record.ASN != 0 {
+		fields["SYSLOG_SOURCE_ASN"] = strconv.FormatUint(uint64(record.ASN), 10)
+	}
+	if record.ASOrg != "" {
+		fields["SYSLOG_SOURCE_AS_ORG"] = record.ASOrg
+	}
+	return fields
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+// fakeGeoReader answers lookups from a table, as a MaxMind database would.
+type fakeGeoReader map[string]func(*geoRecord)
+
+func (r fakeGeoReader) Lookup(ip net.IP, result interface{}) error {
+	if fill, ok := r[ip.String()]; ok {
+		fill(result.(*geoRecord))
+	}
+	return nil
+}
+
+func TestGeoIPFields(t *testing.T) {
+	city := fakeGeoReader{
+		"192.0.2.1": func(r *geoRecord) {
+			r.Country.ISOCode = "DE"
+			r.City.Names = map[string]string{"de": "Frankfurt am Main", "en": "Frankfurt"}
+		},
+		"2001:db8::1": func(r *geoRecord) { r.Country.ISOCode = "NL" },
+	}
+	asn := fakeGeoReader{
+		"192.0.2.1": func(r *geoRecord) { r.ASN, r.ASOrg = 64496, "Example Networks" },
+	}
+	g := &GeoIP{readers: []geoReader{city, asn}}
+
+	var tests = []struct {
+		ip       string
+		expected map[string]string
+	}{
+		{"192.0.2.1", map[string]string{"SYSLOG_SOURCE_COUNTRY": "DE", "SYSLOG_SOURCE_CITY": "Frankfurt", "SYSLOG_SOURCE_ASN": "64496", "SYSLOG_SOURCE_AS_ORG": "Example Networks"}},
+		{"2001:db8::1", map[string]string{"SYSLOG_SOURCE_COUNTRY": "NL"}},
+		{"10.0.0.1", map[string]string{}},
+	}
+
+	for num, test := range tests {
+		if got := g.Fields(net.ParseIP(test.ip)); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Failed test %d: expected %v, got %v", num, test.expected, got)
+		}
+	}
+}
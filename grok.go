@@ -0,0 +1,120 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// grokPatterns are the named patterns %{NAME} and %{NAME:field} expand to,
+// a subset of Logstash's grok patterns.
+var grokPatterns = map[string]string{
+	"INT":          `[+-]?\d+`,
+	"POSINT":       `\d+`,
+	"NUMBER":       `[+-]?(?:\d+(?:\.\d*)?|\.\d+)`,
+	"WORD":         `\b\w+\b`,
+	"NOTSPACE":     `\S+`,
+	"DATA":         `.*?`,
+	"GREEDYDATA":   `.*`,
+	"QUOTEDSTRING": `"(?:[^"\\]|\\.)*"`,
+	"USERNAME":     `[a-zA-Z0-9._-]+`,
+	"IPV4":         `(?:\d{1,3}\.){3}\d{1,3}`,
+	"IPV6":         `[0-9A-Fa-f]*:[0-9A-Fa-f:]*(?:\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3})?`,
+	"IP":           `(?:[0-9A-Fa-f]*:[0-9A-Fa-f:]*(?:\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3})?|(?:\d{1,3}\.){3}\d{1,3})`,
+	"HOSTNAME":     `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?\b`,
+	"MAC":          `(?:[0-9A-Fa-f]{2}[:-]){5}[0-9A-Fa-f]{2}`,
+}
+
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::(\w+))?\}`)
+
+// CompilePattern compiles a regular expression which may refer to
+// grokPatterns as %{NAME}, or as %{NAME:field} to capture what it matches as
+// field.
+func CompilePattern(pattern string) (*regexp.Regexp, error) {
+	var unknown string
+	expanded := grokReference.ReplaceAllStringFunc(pattern, func(ref string) string {
+		parts := grokReference.FindStringSubmatch(ref)
+		expansion, ok := grokPatterns[parts[1]]
+		if !ok {
+			unknown = parts[1]
+			return ref
+		}
+		if parts[2] == "" {
+			return "(?:" + expansion + ")"
+		}
+		return "(?P<" + parts[2] + ">" + expansion + ")"
+	})
+	if unknown != "" {
+		return nil, fmt.Errorf("unknown pattern %%{%s}", unknown)
+	}
+	return regexp.Compile(expanded)
+}
+
+// Pattern extracts fields from the messages of a program (or, if Program
+// is "*", of any program) with the named groups of Regexp.
+type Pattern struct {
+	Program string
+	Regexp  *regexp.Regexp
+}
+
+// LoadPatterns reads Patterns from a file of "PROGRAM PATTERN" lines (see
+// CompilePattern). Blank lines and those starting with # are skipped.
+func LoadPatterns(name string) ([]Pattern, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []Pattern
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		program, pattern, ok := strings.Cut(text, " ")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected PROGRAM PATTERN", name, line)
+		}
+		re, err := CompilePattern(strings.TrimLeft(pattern, " \t"))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, line, err)
+		}
+		patterns = append(patterns, Pattern{program, re})
+	}
+	return patterns, scanner.Err()
+}
+
+// PatternFields returns journal fields for what the named groups of the
+// socket's Patterns for msg's program capture from its MSG. Where several
+// patterns capture the same field, the first wins.
+func (config *SocketConfig) PatternFields(msg *SyslogMessage) map[string]string {
+	fields := map[string]string{}
+	for _, pattern := range config.Patterns {
+		if pattern.Program != "*" && pattern.Program != msg.AppName {
+			continue
+		}
+		match := pattern.Regexp.FindStringSubmatch(msg.Message)
+		if match == nil {
+			continue
+		}
+		for i, group := range pattern.Regexp.SubexpNames() {
+			if group == "" || match[i] == "" {
+				continue
+			}
+			if name := JournalFieldName("", group); name != "" && name != "MESSAGE" && name != "PRIORITY" {
+				if _, ok := fields[name]; !ok {
+					fields[name] = match[i]
+				}
+			}
+		}
+	}
+	return fields
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCompilePattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		in       string
+		expected []string
+	}{
+		{`SRC=%{IP:src} DST=%{IP:dst}`, "IN=eth0 SRC=10.0.0.1 DST=2001:db8::1 LEN=60", []string{"SRC=10.0.0.1 DST=2001:db8::1", "10.0.0.1", "2001:db8::1"}},
+		{`user %{USERNAME:user} from %{IPV4}`, "Failed password for user root from 192.0.2.1", []string{"user root from 192.0.2.1", "root"}},
+		{`took %{NUMBER:ms}ms`, "request took 12.5ms", []string{"took 12.5ms", "12.5"}},
+		{`(?P<code>\d{3}) %{GREEDYDATA:reason}`, "HTTP 404 Not Found", []string{"404 Not Found", "404", "Not Found"}},
+	}
+
+	for num, test := range tests {
+		re, err := CompilePattern(test.pattern)
+		if err != nil {
+			t.Errorf("Failed test %d: %s", num, err.Error())
+			continue
+		}
+		if got := re.FindStringSubmatch(test.in); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Failed test %d: expected %q, got %q", num, test.expected, got)
+		}
+	}
+
+	for _, bad := range []string{`%{NOPE:x}`, `%{IP:src} (unclosed`} {
+		if _, err := CompilePattern(bad); err == nil {
+			t.Errorf("Expected an error compiling %q", bad)
+		}
+	}
+}
+
+func TestPatternFields(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "patterns")
+	if err := os.WriteFile(name, []byte("# firewall\nkernel SRC=%{IP:src} DST=%{IP:dst}\n\n* id=%{INT:src}\nkernel %{INT:message}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	sockets := socketConfigs{}
+	if err := sockets.Set("fw:patterns=" + name); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	config := sockets.Lookup("fw")
+
+	tests := []struct {
+		in       string
+		expected map[string]string
+	}{
+		{"<4>Dec 15 11:55:02 fw1 kernel: IN=eth0 SRC=10.0.0.1 DST=10.0.0.2 id=7", map[string]string{"SRC": "10.0.0.1", "DST": "10.0.0.2"}},
+		{"<4>Dec 15 11:55:02 fw1 sshd[1]: id=7", map[string]string{"SRC": "7"}},
+		{"<4>Dec 15 11:55:02 fw1 sshd[1]: SRC=10.0.0.1 DST=10.0.0.2", map[string]string{}},
+	}
+
+	for num, test := range tests {
+		msg := NewSyslogMessage()
+		msg.Parse(test.in, "192.0.2.1:514")
+		if got := config.PatternFields(msg); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Failed test %d: expected %v, got %v", num, test.expected, got)
+		}
+	}
+
+	if err := os.WriteFile(name, []byte("kernel\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPatterns(name); err == nil {
+		t.Errorf("Expected an error loading a line without a pattern")
+	}
+}
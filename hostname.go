@@ -0,0 +1,199 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long reverse DNS answers are remembered (failures for less time, so a
+// sender whose PTR record is fixed is soon named), and how many senders'
+// answers at most.
+const (
+	reverseDNSTTL         = 5 * time.Minute
+	reverseDNSNegativeTTL = time.Minute
+	maxReverseDNSSize     = 4096
+)
+
+// lookupAddr is net.LookupAddr, replaceable in tests.
+var lookupAddr = net.LookupAddr
+
+var reverseDNS = &hostnameCache{entries: map[string]hostnameEntry{}}
+
+// hostnameCache remembers reverse DNS answers, so that a busy sender doesn't
+// cost a lookup per message.
+type hostnameCache struct {
+	mu      sync.Mutex
+	entries map[string]hostnameEntry
+	// pending are the addresses LookupAsync is resolving.
+	pending map[string]bool
+}
+
+type hostnameEntry struct {
+	// name is empty if the address doesn't resolve.
+	name    string
+	expires time.Time
+}
+
+// LookupAsync returns the name ip resolves to if it's known, without
+// waiting: if it isn't, or has expired, it's looked up in the background
+// for next time, and "" returned meanwhile, as it is for addresses which
+// don't resolve.
+func (c *hostnameCache) LookupAsync(ip string, now time.Time) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[ip]
+	if ok && now.Before(entry.expires) {
+		return entry.name
+	}
+	if c.pending == nil {
+		c.pending = map[string]bool{}
+	}
+	if !c.pending[ip] && len(c.pending) < maxReverseDNSSize {
+		c.pending[ip] = true
+		go func() {
+			c.resolve(ip, now)
+			c.mu.Lock()
+			delete(c.pending, ip)
+			c.mu.Unlock()
+		}()
+	}
+	// An expired name is better than none while it's looked up again.
+	return entry.name
+}
+
+// resolve looks ip up and remembers the answer, returning the name or "".
+func (c *hostnameCache) resolve(ip string, now time.Time) string {
+	entry := hostnameEntry{expires: now.Add(reverseDNSNegativeTTL)}
+	if names, err := lookupAddr(ip); err == nil && len(names) > 0 {
+		entry = hostnameEntry{strings.TrimSuffix(names[0], "."), now.Add(reverseDNSTTL)}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxReverseDNSSize {
+		c.entries = map[string]hostnameEntry{}
+	}
+	c.entries[ip] = entry
+	return entry.name
+}
+
+// HostnameFor returns the hostname to record for messages from source which
+// don't name one, according to DefaultHostname: the sender's address for
+// "source", the name it resolves to for "dns" (or its address, until it's
+// been looked up), and otherwise DefaultHostname itself (so none, if it's
+// empty).
+func (config *SocketConfig) HostnameFor(source string) string {
+	switch config.DefaultHostname {
+	case "source", "dns":
+	default:
+		return config.DefaultHostname
+	}
+
+	host := sourceHost(source)
+	if config.DefaultHostname == "dns" && net.ParseIP(host) != nil {
+		if name := reverseDNS.LookupAsync(host, time.Now()); name != "" {
+			return name
+		}
+	}
+	return host
+}
+
+// validHostnameFrom reports whether from is a hostname precedence.
+func validHostnameFrom(from string) bool {
+	return from == "claimed" || from == "dns" || from == "source"
+}
+
+// ChooseHostname returns the hostname to record for a message from source
+// claiming to be from claimed, according to the precedence for source (see
+// HostnameFromFor): the claimed hostname; the name source resolves to in
+// reverse DNS, or failing that (or until it's been looked up, which isn't
+// waited for), the claimed hostname; or source's address.
+// Senders which aren't IP addresses, such as those on unix sockets, keep
+// the hostname they claim.
+func (config *SocketConfig) ChooseHostname(claimed string, source string) string {
+	host := sourceHost(source)
+	if net.ParseIP(host) == nil {
+		return claimed
+	}
+	switch config.HostnameFromFor(host) {
+	case "dns":
+		if name := reverseDNS.LookupAsync(host, time.Now()); name != "" {
+			return name
+		}
+	case "source":
+		return host
+	}
+	return claimed
+}
+
+// HostnameFromFor returns the hostname precedence for messages from host:
+// that of the first SourceHostnameFrom network containing it, or
+// HostnameFrom, or -hostname-from's.
+func (config *SocketConfig) HostnameFromFor(host string) string {
+	if len(config.SourceHostnameFrom) > 0 {
+		if ip := net.ParseIP(host); ip != nil {
+			for _, from := range config.SourceHostnameFrom {
+				if from.network.Contains(ip) {
+					return from.from
+				}
+			}
+		}
+	}
+	if config.HostnameFrom != "" {
+		return config.HostnameFrom
+	}
+	return *hostnameFrom
+}
+
+// sourceHost returns the address of source without its port.
+func sourceHost(source string) string {
+	host, _, err := net.SplitHostPort(source)
+	if err != nil {
+		return source
+	}
+	return host
+}
+
+// CheckHostname applies the socket's Hostname setting to a HOSTNAME claimed
+// by a message from source: with "validate", anything other than an RFC1123
+// hostname or an IP address is replaced by the sender's address; "normalize"
+// also lowercases it and strips any trailing dot.
+func (config *SocketConfig) CheckHostname(hostname string, source string) string {
+	if hostname == "" || (config.Hostname != "validate" && config.Hostname != "normalize") {
+		return hostname
+	}
+	if config.Hostname == "normalize" {
+		hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	}
+	if !isHostname(strings.TrimSuffix(hostname, ".")) && net.ParseIP(hostname) == nil {
+		return sourceHost(source)
+	}
+	return hostname
+}
+
+// isHostname reports whether s is a valid hostname (RFC1123, section 2.1):
+// dot-separated labels of letters, digits and hyphens, neither starting nor
+// ending with a hyphen.
+func isHostname(s string) bool {
+	if s == "" || len(s) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
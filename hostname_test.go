@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHostnameFor(t *testing.T) {
+	defer func(f func(string) ([]string, error)) { lookupAddr = f }(lookupAddr)
+	defer func(saved *hostnameCache) { reverseDNS = saved }(reverseDNS)
+	reverseDNS = &hostnameCache{entries: map[string]hostnameEntry{}}
+	lookupAddr = func(addr string) ([]string, error) {
+		if addr == "192.0.2.1" {
+			return []string{"router1.example.com."}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	// Until a sender's been looked up, its address stands in for its name.
+	dns := &SocketConfig{DefaultHostname: "dns"}
+	if got := dns.HostnameFor("192.0.2.1:514"); got != "192.0.2.1" {
+		t.Errorf("Expected the address while it's looked up, got %q", got)
+	}
+	resolveAll(t, "192.0.2.1", "192.0.2.2")
+
+	var tests = []struct {
+		setting  string
+		source   string
+		expected string
+	}{
+		{"", "192.0.2.1:514", ""},
+		{"source", "192.0.2.1:514", "192.0.2.1"},
+		{"source", "[2001:db8::1]:514", "2001:db8::1"},
+		{"source", "/run/syslog.sock", "/run/syslog.sock"},
+		{"dns", "192.0.2.1:514", "router1.example.com"},
+		{"dns", "192.0.2.2:514", "192.0.2.2"},
+		{"dns", "192.0.2.1:1514", "router1.example.com"},
+		{"unknown-host", "192.0.2.1:514", "unknown-host"},
+	}
+
+	for num, test := range tests {
+		config := &SocketConfig{DefaultHostname: test.setting}
+		if got := config.HostnameFor(test.source); got != test.expected {
+			t.Errorf("Failed test %d: expected %q, got %q", num, test.expected, got)
+		}
+	}
+}
+
+// resolveAll waits for reverseDNS to have looked up each of ips.
+func resolveAll(t *testing.T, ips ...string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, ip := range ips {
+		for {
+			reverseDNS.LookupAsync(ip, time.Now())
+			reverseDNS.mu.Lock()
+			_, ok := reverseDNS.entries[ip]
+			reverseDNS.mu.Unlock()
+			if ok {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out looking up %s", ip)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func TestCheckHostname(t *testing.T) {
+	var tests = []struct {
+		setting  string
+		hostname string
+		expected string
+	}{
+		{"", "<garbage>", "<garbage>"},
+		{"keep", "Host.Example.COM.", "Host.Example.COM."},
+		{"validate", "Host.Example.COM.", "Host.Example.COM."},
+		{"validate", "host-1", "host-1"},
+		{"validate", "192.0.2.7", "192.0.2.7"},
+		{"validate", "2001:db8::7", "2001:db8::7"},
+		{"validate", "-host", "192.0.2.1"},
+		{"validate", "host..example.com", "192.0.2.1"},
+		{"validate", "host_1", "192.0.2.1"},
+		{"validate", "<garbage>", "192.0.2.1"},
+		{"normalize", "Host.Example.COM.", "host.example.com"},
+		{"normalize", "???", "192.0.2.1"},
+		{"normalize", "", ""},
+	}
+
+	for num, test := range tests {
+		config := &SocketConfig{Hostname: test.setting}
+		if got := config.CheckHostname(test.hostname, "192.0.2.1:514"); got != test.expected {
+			t.Errorf("Failed test %d: expected %q, got %q", num, test.expected, got)
+		}
+	}
+}
+
+func TestLookupAsync(t *testing.T) {
+	lookups := make(chan string, 10)
+	release := make(chan struct{})
+	defer func(f func(string) ([]string, error)) { lookupAddr = f }(lookupAddr)
+	lookupAddr = func(addr string) ([]string, error) {
+		lookups <- addr
+		<-release
+		if addr == "192.0.2.1" {
+			return []string{"router1.example.com."}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	cache := &hostnameCache{entries: map[string]hostnameEntry{}}
+	now := time.Now()
+
+	// Nothing is known at first, and lookups aren't waited for, or
+	// repeated while they're under way.
+	for i := 0; i < 3; i++ {
+		if got := cache.LookupAsync("192.0.2.1", now); got != "" {
+			t.Errorf("Expected no name yet, got %q", got)
+		}
+	}
+	cache.LookupAsync("192.0.2.2", now)
+	seen := map[string]bool{<-lookups: true, <-lookups: true}
+	if !seen["192.0.2.1"] || !seen["192.0.2.2"] {
+		t.Errorf("Expected both addresses to be looked up, got %v", seen)
+	}
+	close(release)
+	for deadline := time.Now().Add(5 * time.Second); cache.LookupAsync("192.0.2.1", now) == ""; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for a lookup")
+		}
+	}
+	if got := cache.LookupAsync("192.0.2.1", now); got != "router1.example.com" {
+		t.Errorf("Expected router1.example.com, got %q", got)
+	}
+	if len(lookups) != 0 {
+		t.Errorf("Expected no more lookups, got %d", len(lookups))
+	}
+
+	// Failures are remembered for less time than answers.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		cache.mu.Lock()
+		_, done := cache.entries["192.0.2.2"]
+		cache.mu.Unlock()
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for a lookup")
+		}
+	}
+	if got := cache.LookupAsync("192.0.2.2", now.Add(reverseDNSNegativeTTL/2)); got != "" || len(lookups) != 0 {
+		t.Errorf("Expected a remembered failure, got %q", got)
+	}
+	cache.LookupAsync("192.0.2.2", now.Add(reverseDNSNegativeTTL))
+	if got := <-lookups; got != "192.0.2.2" {
+		t.Errorf("Expected 192.0.2.2 to be looked up again, got %s", got)
+	}
+	if got := cache.LookupAsync("192.0.2.1", now.Add(reverseDNSNegativeTTL)); got != "router1.example.com" {
+		t.Errorf("Expected router1.example.com to be remembered, got %q", got)
+	}
+}
+
+func TestChooseHostname(t *testing.T) {
+	defer func(f func(string) ([]string, error)) { lookupAddr = f }(lookupAddr)
+	defer func(saved *hostnameCache) { reverseDNS = saved }(reverseDNS)
+	reverseDNS = &hostnameCache{entries: map[string]hostnameEntry{}}
+	lookupAddr = func(addr string) ([]string, error) {
+		if addr == "192.0.2.1" || addr == "198.51.100.1" {
+			return []string{"router1.example.com."}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	defer func(saved string) { *hostnameFrom = saved }(*hostnameFrom)
+
+	// The claimed hostname stands in until the sender's been looked up.
+	config := &SocketConfig{HostnameFrom: "dns"}
+	if got := config.ChooseHostname("localhost", "192.0.2.1:514"); got != "localhost" {
+		t.Errorf("Expected the claimed hostname while it's looked up, got %q", got)
+	}
+	resolveAll(t, "192.0.2.1", "192.0.2.2", "198.51.100.1")
+
+	var tests = []struct {
+		global   string
+		settings string
+		source   string
+		expected string
+	}{
+		{"claimed", "", "192.0.2.1:514", "localhost"},
+		{"dns", "", "192.0.2.1:514", "router1.example.com"},
+		{"dns", "", "192.0.2.2:514", "localhost"},
+		{"source", "", "192.0.2.1:514", "192.0.2.1"},
+		{"source", "", "/dev/log", "localhost"},
+		{"claimed", "hostname-from=source", "192.0.2.1:514", "192.0.2.1"},
+		{"source", "hostname-from=claimed", "192.0.2.1:514", "localhost"},
+		{"claimed", "source-hostname-from=198.51.100.0/24=dns", "198.51.100.1:514", "router1.example.com"},
+		{"claimed", "source-hostname-from=198.51.100.0/24=dns", "192.0.2.1:514", "localhost"},
+		{"claimed", "hostname-from=source,source-hostname-from=198.51.100.0/24=claimed", "198.51.100.1:514", "localhost"},
+	}
+
+	for num, test := range tests {
+		*hostnameFrom = test.global
+		sockets := socketConfigs{}
+		if err := sockets.Set("x:" + test.settings); test.settings != "" && err != nil {
+			t.Errorf("Failed test %d: %s", num, err.Error())
+			continue
+		}
+		if got := sockets.Lookup("x").ChooseHostname("localhost", test.source); got != test.expected {
+			t.Errorf("Failed test %d: expected %q, got %q", num, test.expected, got)
+		}
+	}
+
+	for _, bad := range []string{"x:hostname-from=ptr", "x:source-hostname-from=10.0.0.0/8", "x:source-hostname-from=10.0.0.0/8=ptr"} {
+		if err := (socketConfigs{}).Set(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/coreos/go-systemd/journal"
+	"golang.org/x/sys/unix"
+)
+
+// journalSocket returns the path of the native protocol socket of a
+// journald namespace (see systemd-journald@.service), or of the system
+// journal for the empty namespace.
+var journalSocket = func(namespace string) string {
+	if namespace == "" {
+		return "/run/systemd/journal/socket"
+	}
+	return "/run/systemd/journal." + namespace + "/socket"
+}
+
+// validNamespace reports whether name could be a journal namespace, which
+// systemd names a unit instance after.
+func validNamespace(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+	for _, c := range name {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && !strings.ContainsRune("-_.", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// SendJournal sends an entry to a journald namespace, or to the system
+// journal if namespace is empty. Errors which may well pass, such as a full
+// socket buffer or journald restarting, are retried -journal-retries times,
+// backing off from -journal-retry-backoff. Meanwhile, the connection or
+// queue worker sending the entry waits, and so holds back further messages
+// from stream senders, rather than taking more than journald can.
+func SendJournal(namespace string, message string, priority journal.Priority, vars map[string]string) error {
+	backoff := *journalBackoff
+	for attempt := 0; ; attempt++ {
+		err := sendJournal(namespace, message, priority, vars)
+		if err == nil || attempt >= *journalRetries || !transientJournalError(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// sendJournal makes one attempt at sending an entry.
+func sendJournal(namespace string, message string, priority journal.Priority, vars map[string]string) error {
+	return journalWriterFor(namespace).send(message, priority, vars)
+}
+
+// transientJournalError reports whether an error sending to journald is
+// worth retrying.
+func transientJournalError(err error) bool {
+	return errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.ENOBUFS) ||
+		errors.Is(err, unix.EINTR) || errors.Is(err, unix.ECONNREFUSED)
+}
+
+// journalDrops counts the entries lost because journald wouldn't take them,
+// and nothing else would either.
+var journalDrops atomic.Int64
+
+// ReportJournalDrops logs the number of entries lost every interval, if
+// any were, until stop is closed.
+func ReportJournalDrops(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if dropped := journalDrops.Swap(0); dropped > 0 {
+			log.Printf("dropped %d entries journald wouldn't take in the last %s", dropped, interval)
+		}
+	}
+}
+
+// journalWriter sends entries to journald over its native protocol: each is
+// a datagram, or if it's too big for one, a sealed memfd passed in one.
+// Sends share one unbound socket, and don't wait on each other.
+type journalWriter struct {
+	addr *net.UnixAddr
+
+	mu   sync.Mutex
+	conn *net.UnixConn
+}
+
+var (
+	journalWritersMu sync.Mutex
+	journalWriters   = map[string]*journalWriter{}
+)
+
+func journalWriterFor(namespace string) *journalWriter {
+	journalWritersMu.Lock()
+	defer journalWritersMu.Unlock()
+	if writer, ok := journalWriters[namespace]; ok {
+		return writer
+	}
+	writer := &journalWriter{addr: &net.UnixAddr{Name: journalSocket(namespace), Net: "unixgram"}}
+	journalWriters[namespace] = writer
+	return writer
+}
+
+func (w *journalWriter) send(message string, priority journal.Priority, vars map[string]string) error {
+	size := len(message) + 32
+	for k, v := range vars {
+		size += len(k) + len(v) + 10
+	}
+	data := bytes.NewBuffer(make([]byte, 0, size))
+	appendJournalField(data, "PRIORITY", strconv.Itoa(int(priority)))
+	appendJournalField(data, "MESSAGE", message)
+	for k, v := range vars {
+		appendJournalField(data, k, v)
+	}
+
+	conn, err := w.socket()
+	if err != nil {
+		return err
+	}
+	_, _, err = conn.WriteMsgUnix(data.Bytes(), nil, w.addr)
+	if errors.Is(err, unix.EMSGSIZE) || errors.Is(err, unix.ENOBUFS) {
+		// Too big for a datagram: pass it in a sealed memfd instead.
+		err = w.sendMemfd(conn, data.Bytes())
+	}
+	return err
+}
+
+// socket returns the socket entries are sent from, opening it the first
+// time.
+func (w *journalWriter) socket() (*net.UnixConn, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+		if err != nil {
+			return nil, err
+		}
+		w.conn = conn
+	}
+	return w.conn, nil
+}
+
+func (w *journalWriter) sendMemfd(conn *net.UnixConn, data []byte) error {
+	file, err := journalDataFile()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	// An unlinked temporary file has no seals to add; journald reads it
+	// rather than mapping it.
+	if _, err := unix.FcntlInt(file.Fd(), unix.F_ADD_SEALS, unix.F_SEAL_SHRINK|unix.F_SEAL_GROW|unix.F_SEAL_WRITE|unix.F_SEAL_SEAL); err != nil && !errors.Is(err, unix.EINVAL) {
+		return err
+	}
+	_, _, err = conn.WriteMsgUnix(nil, unix.UnixRights(int(file.Fd())), w.addr)
+	return err
+}
+
+// journalDataFile returns a file to pass a large entry in: a memfd, or on
+// kernels without memfd_create, an unlinked file in /dev/shm, as journald
+// accepted before memfds.
+func journalDataFile() (*os.File, error) {
+	fd, err := unix.MemfdCreate("journal-message", unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err == nil {
+		return os.NewFile(uintptr(fd), "journal-message"), nil
+	}
+	if !errors.Is(err, unix.ENOSYS) && !errors.Is(err, unix.EPERM) {
+		return nil, err
+	}
+	file, err := os.CreateTemp("/dev/shm", "journal-message.")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(file.Name())
+	return file, nil
+}
+
+// appendJournalField serializes a field in the journal's native protocol:
+// NAME=value on a line, or for values containing newlines, the name on a
+// line followed by the value's length as a little-endian 64-bit integer and
+// the value itself.
+func appendJournalField(b *bytes.Buffer, name string, value string) {
+	if !strings.ContainsRune(value, '\n') {
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteString(name)
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-systemd/journal"
+	"golang.org/x/sys/unix"
+)
+
+func TestAppendJournalField(t *testing.T) {
+	var b bytes.Buffer
+	appendJournalField(&b, "MESSAGE", "one line")
+	appendJournalField(&b, "SYSLOG_RAW", "two\nlines")
+	expected := "MESSAGE=one line\nSYSLOG_RAW\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\n"
+	if got := b.String(); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestSendJournalNamespace(t *testing.T) {
+	dir := t.TempDir()
+	defer func(saved func(string) string) { journalSocket = saved }(journalSocket)
+	journalSocket = func(namespace string) string { return filepath.Join(dir, namespace) }
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "remote"), Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.SetReadBuffer(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SendJournal("remote", "hello", journal.PriInfo, map[string]string{"SYSLOG_HOSTNAME": "host"}); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "PRIORITY=6\nMESSAGE=hello\nSYSLOG_HOSTNAME=host\n"; string(buf[:n]) != expected {
+		t.Errorf("Expected %q, got %q", expected, buf[:n])
+	}
+
+	// Entries too big for a datagram are passed in a memfd.
+	big := strings.Repeat("x", 1<<20)
+	if err := SendJournal("remote", big, journal.PriInfo, nil); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("Expected a file descriptor, got %v, %v", msgs, err)
+	}
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		t.Fatalf("Expected a file descriptor, got %v, %v", fds, err)
+	}
+	file := os.NewFile(uintptr(fds[0]), "memfd")
+	defer file.Close()
+	data, err := os.ReadFile("/proc/self/fd/" + strconv.Itoa(fds[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "PRIORITY=6\nMESSAGE=" + big + "\n"; string(data) != expected {
+		t.Errorf("Expected %d bytes of entry, got %d", len(expected), len(data))
+	}
+}
+
+func TestValidNamespace(t *testing.T) {
+	for name, expected := range map[string]bool{
+		"remote":                true,
+		"remote-dmz.1":          true,
+		"":                      false,
+		"../etc":                false,
+		"with space":            false,
+		strings.Repeat("x", 65): false,
+	} {
+		if got := validNamespace(name); got != expected {
+			t.Errorf("%q: expected %v, got %v", name, expected, got)
+		}
+	}
+}
+
+func TestSendJournalRetries(t *testing.T) {
+	dir := t.TempDir()
+	defer func(saved func(string) string) { journalSocket = saved }(journalSocket)
+	journalSocket = func(namespace string) string { return filepath.Join(dir, namespace) }
+	defer func(retries int, backoff time.Duration) { *journalRetries, *journalBackoff = retries, backoff }(*journalRetries, *journalBackoff)
+	*journalRetries, *journalBackoff = 8, 5*time.Millisecond
+
+	// A socket nobody's reading from any more refuses entries, as while
+	// journald restarts.
+	path := filepath.Join(dir, "restarting")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if err := sendJournal("restarting", "hello", journal.PriInfo, nil); !transientJournalError(err) {
+		t.Fatalf("Expected a transient error, got %v", err)
+	}
+
+	restarted := make(chan *net.UnixConn)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		os.Remove(path)
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+		if err != nil {
+			t.Error(err)
+		}
+		restarted <- conn
+	}()
+	if err := SendJournal("restarting", "hello", journal.PriInfo, nil); err != nil {
+		t.Errorf("Expected the entry to be sent once journald was back, got %v", err)
+	}
+	if conn := <-restarted; conn != nil {
+		conn.Close()
+	}
+
+	// Other errors aren't retried, and entries with nowhere else to go
+	// are counted as dropped.
+	*journalBackoff = time.Hour
+	dropped := journalDrops.Load()
+	(&journalEntry{"missing", "hello", journal.PriInfo, nil, nil}).write()
+	if got := journalDrops.Load() - dropped; got != 1 {
+		t.Errorf("Expected 1 entry dropped, got %d", got)
+	}
+}
+
+func TestSendJournalSystem(t *testing.T) {
+	if got := journalSocket(""); got != "/run/systemd/journal/socket" {
+		t.Errorf("Expected the system journal's socket, got %q", got)
+	}
+
+	// The system journal is written to as directly as namespaces are.
+	dir := t.TempDir()
+	defer func(saved func(string) string) { journalSocket = saved }(journalSocket)
+	journalSocket = func(namespace string) string { return filepath.Join(dir, "system"+namespace) }
+	defer func(saved map[string]*journalWriter) { journalWriters = saved }(journalWriters)
+	journalWriters = map[string]*journalWriter{}
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "system"), Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	done := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func(i int) {
+			done <- SendJournal("", strconv.Itoa(i), journal.PriInfo, nil) == nil
+		}(i)
+	}
+	buf := make([]byte, 4096)
+	seen := map[string]bool{}
+	for i := 0; i < 4; i++ {
+		if !<-done {
+			t.Errorf("Expected entries to be sent")
+		}
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[string(buf[:n])] = true
+	}
+	for i := 0; i < 4; i++ {
+		if entry := "PRIORITY=6\nMESSAGE=" + strconv.Itoa(i) + "\n"; !seen[entry] {
+			t.Errorf("Expected %q among %v", entry, seen)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/coreos/go-systemd/journal"
 	"github.com/jonboulle/clockwork"
 )
 
@@ -28,32 +29,65 @@ func TestParseSyslog(t *testing.T) {
 			`<13>1 2015-12-15T11:54:41.946675-08:00 host.domain.com user - - [timeQuality tzKnown="1" isSynced="1" syncAccuracy="380797"] message`,
 			"127.0.0.1",
 			&SyslogMessage{
-				Version:        1,
-				Facility:       1,
-				Severity:       5,
-				Timestamp:      time.Date(2015, 12, 15, 11, 54, 41, 946675000, PST),
-				Hostname:       "host.domain.com",
-				Tag:            "user - -",
-				StructuredData: `[timeQuality tzKnown="1" isSynced="1" syncAccuracy="380797"`,
-				Message:        "message",
-				Source:         "127.0.0.1",
-				clock:          clock,
+				Version:           1,
+				Facility:          1,
+				Severity:          5,
+				Timestamp:         time.Date(2015, 12, 15, 11, 54, 41, 946675000, PST),
+				OriginalTimestamp: "2015-12-15T11:54:41.946675-08:00",
+				Hostname:          "host.domain.com",
+				AppName:           "user",
+				StructuredData:    StructuredData{"timeQuality": {"tzKnown": "1", "isSynced": "1", "syncAccuracy": "380797"}},
+				Message:           "message",
+				Source:            "127.0.0.1",
+				clock:             clock,
+			},
+		},
+		{
+			`<13>2 2015-12-15T11:54:41.946675-08:00 host.domain.com user 42 ID7 - message`,
+			"127.0.0.1",
+			&SyslogMessage{
+				Version:           2,
+				Facility:          1,
+				Severity:          5,
+				Timestamp:         time.Date(2015, 12, 15, 11, 54, 41, 946675000, PST),
+				OriginalTimestamp: "2015-12-15T11:54:41.946675-08:00",
+				Hostname:          "host.domain.com",
+				AppName:           "user",
+				ProcID:            "42",
+				MsgID:             "ID7",
+				Message:           "message",
+				Source:            "127.0.0.1",
+				clock:             clock,
 			},
 		},
 		{
 			`<13>Dec 15 11:55:02 host user: message`,
 			"127.0.0.1",
 			&SyslogMessage{
-				Version:        0,
-				Facility:       1,
-				Severity:       5,
-				Timestamp:      time.Date(0000, 12, 15, 11, 55, 02, 0, time.UTC),
-				Hostname:       "host",
-				Tag:            "user:",
-				StructuredData: "",
-				Message:        "message",
-				Source:         "127.0.0.1",
-				clock:          clock,
+				Version:           0,
+				Facility:          1,
+				Severity:          5,
+				Timestamp:         time.Date(1983, 12, 15, 11, 55, 02, 0, time.UTC),
+				OriginalTimestamp: "Dec 15 11:55:02",
+				Hostname:          "host",
+				Tag:               "user:",
+				AppName:           "user",
+				StructuredData:    nil,
+				Message:           "message",
+				Source:            "127.0.0.1",
+				clock:             clock,
+			},
+		},
+		{
+			`<13>404 not found`,
+			"127.0.0.1",
+			&SyslogMessage{
+				Facility:  1,
+				Severity:  5,
+				Timestamp: clock.Now(),
+				Message:   "404 not found",
+				Source:    "127.0.0.1",
+				clock:     clock,
 			},
 		},
 		{
@@ -66,7 +100,7 @@ func TestParseSyslog(t *testing.T) {
 				Timestamp:      clock.Now(),
 				Hostname:       "",
 				Tag:            "",
-				StructuredData: "",
+				StructuredData: nil,
 				Message:        "- host.domain.com user - - - message",
 				Source:         "127.0.0.1",
 				clock:          clock,
@@ -76,32 +110,34 @@ func TestParseSyslog(t *testing.T) {
 			`<13>1 2015-12-15T11:56:01.776597-08:00 host.domain.com user - - - message`,
 			"127.0.0.1",
 			&SyslogMessage{
-				Version:        1,
-				Facility:       1,
-				Severity:       5,
-				Timestamp:      time.Date(2015, 12, 15, 11, 56, 01, 776597000, PST),
-				Hostname:       "host.domain.com",
-				Tag:            "user - -",
-				StructuredData: "",
-				Message:        "- message",
-				Source:         "127.0.0.1",
-				clock:          clock,
+				Version:           1,
+				Facility:          1,
+				Severity:          5,
+				Timestamp:         time.Date(2015, 12, 15, 11, 56, 01, 776597000, PST),
+				OriginalTimestamp: "2015-12-15T11:56:01.776597-08:00",
+				Hostname:          "host.domain.com",
+				AppName:           "user",
+				StructuredData:    nil,
+				Message:           "message",
+				Source:            "127.0.0.1",
+				clock:             clock,
 			},
 		},
 		{
 			`<13>1 2015-12-15T11:56:13.555187-08:00 - user - - [timeQuality tzKnown="1" isSynced="1" syncAccuracy="426797"] message`,
 			"127.0.0.1",
 			&SyslogMessage{
-				Version:        1,
-				Facility:       1,
-				Severity:       5,
-				Timestamp:      time.Date(2015, 12, 15, 11, 56, 13, 555187000, PST),
-				Hostname:       "-",
-				Tag:            "user - -",
-				StructuredData: `[timeQuality tzKnown="1" isSynced="1" syncAccuracy="426797"`,
-				Message:        "message",
-				Source:         "127.0.0.1",
-				clock:          clock,
+				Version:           1,
+				Facility:          1,
+				Severity:          5,
+				Timestamp:         time.Date(2015, 12, 15, 11, 56, 13, 555187000, PST),
+				OriginalTimestamp: "2015-12-15T11:56:13.555187-08:00",
+				Hostname:          "",
+				AppName:           "user",
+				StructuredData:    StructuredData{"timeQuality": {"tzKnown": "1", "isSynced": "1", "syncAccuracy": "426797"}},
+				Message:           "message",
+				Source:            "127.0.0.1",
+				clock:             clock,
 			},
 		},
 	}
@@ -116,3 +152,317 @@ func TestParseSyslog(t *testing.T) {
 		}
 	}
 }
+
+func TestParseErrors(t *testing.T) {
+	for buf, expected := range map[string]error{
+		`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog 1234 ID47 - message`: nil,
+		`<13>Dec 15 11:55:02 host sshd[1234]: message`:                                       nil,
+		``:                                    ErrShortMessage,
+		`<`:                                   ErrBadPRI,
+		`message`:                             ErrBadPRI,
+		`<1234>Dec 15 11:55:02 host app: a`:   ErrBadPRI,
+		`<13>`:                                ErrShortMessage,
+		`<13>Dec 15`:                          ErrShortMessage,
+		`<13>Dec 15 11:55:02 host`:            ErrShortMessage,
+		`<13>1 2003-10-11T22:14:15.003Z host`: ErrShortMessage,
+		`<13>1 2003-10-11`:                    ErrShortMessage,
+		`<13>1 yesterday host app - - - a`:    ErrBadTimestamp,
+		`<13>Smarch 15 11:55:02 host app: a`:  ErrBadTimestamp,
+	} {
+		if _, err := ParseSyslog(buf, "127.0.0.1"); err != expected {
+			t.Errorf("ParseSyslog(%q): expected %v, got %v", buf, expected, err)
+		}
+	}
+
+	msg := NewSyslogMessage()
+	if err := msg.ParseFormat("message", "127.0.0.1", "rfc3164/raw"); err != nil {
+		t.Errorf("Unexpected error for raw message: %s", err.Error())
+	}
+
+	// Out-of-range PRIs are recorded, but otherwise ignored.
+	msg, err := ParseSyslog(`<999>Dec 15 11:55:02 host app: a`, "127.0.0.1")
+	if err != ErrBadPRI || msg.OriginalPRI != "999" || msg.Facility != 0 || msg.Severity != 5 || msg.Hostname != "host" || msg.Message != "a" {
+		t.Errorf("Unexpected parse of out-of-range PRI: %v %+v", err, msg)
+	}
+	if got := msg.Fields()["SYSLOG_ORIGINAL_PRI"]; got != "999" {
+		t.Errorf("Expected SYSLOG_ORIGINAL_PRI=999, got %q", got)
+	}
+}
+
+func TestTrimMessage(t *testing.T) {
+	for buf, expected := range map[string]string{
+		"<13>1 - - a\n":         "<13>1 - - a",
+		"<13>1 - - a\r\n\x00":   "<13>1 - - a",
+		"\n <13>1 - - a b ":     "<13>1 - - a b ",
+		"<13>Jan  1 00:00:00 a": "<13>Jan  1 00:00:00 a",
+		"\x00\n":                "",
+	} {
+		if got := TrimMessage(buf); got != expected {
+			t.Errorf("TrimMessage(%q): expected %q, got %q", buf, expected, got)
+		}
+	}
+}
+
+func TestMessageFields(t *testing.T) {
+	msg := NewSyslogMessage()
+	msg.Parse(`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog 1234 ID47 [exampleSDID@32473 iut="3"] message`, "127.0.0.1")
+	fields := msg.Fields()
+
+	expected := map[string]string{
+		"SYSLOG_IDENTIFIER":      "evntslog",
+		"SYSLOG_PID":             "1234",
+		"SYSLOG_MSGID":           "ID47",
+		"MESSAGE_ID":             "dc87f92f940f5809bbaf8608ad4fa283",
+		"SYSLOG_HOSTNAME":        "mymachine.example.com",
+		"SYSLOG_FACILITY":        "20",
+		"SYSLOG_FACILITY_NAME":   "local4",
+		"SYSLOG_SEVERITY":        "5",
+		"SYSLOG_SEVERITY_NAME":   "notice",
+		"SYSLOG_STRUCTURED_DATA": `[exampleSDID@32473 iut="3"]`,
+	}
+	for name, value := range expected {
+		if fields[name] != value {
+			t.Errorf("%s: expected %q, got %q", name, value, fields[name])
+		}
+	}
+
+	msg = NewSyslogMessage()
+	msg.Parse(`<13>Dec 15 11:55:02 host user: message`, "127.0.0.1")
+	if fields := msg.Fields(); fields["SYSLOG_IDENTIFIER"] != "user" {
+		t.Errorf("SYSLOG_IDENTIFIER: expected %q, got %q", "user", fields["SYSLOG_IDENTIFIER"])
+	}
+
+	// RFC3164 tags are split like journald does.
+	for tag, expected := range map[string][2]string{
+		"sshd[1234]:":   {"sshd", "1234"},
+		"sshd[1234]":    {"sshd", "1234"},
+		"kernel:":       {"kernel", ""},
+		"postfix/smtpd": {"", ""},
+		"[1234]:":       {"[1234]", ""},
+	} {
+		msg = NewSyslogMessage()
+		msg.Parse("<13>Dec 15 11:55:02 host "+tag+" message", "127.0.0.1")
+		if msg.AppName != expected[0] || msg.ProcID != expected[1] {
+			t.Errorf("%s: expected %q, got %q %q", tag, expected, msg.AppName, msg.ProcID)
+		}
+	}
+	msg = NewSyslogMessage()
+	msg.Parse("<13>Dec 15 11:55:02 host postfix/smtpd message", "127.0.0.1")
+	if fields := msg.Fields(); fields["SYSLOG_IDENTIFIER"] != "host postfix/smtpd" {
+		t.Errorf("SYSLOG_IDENTIFIER: expected %q, got %q", "host postfix/smtpd", fields["SYSLOG_IDENTIFIER"])
+	}
+
+	// A BOM marks the message as UTF-8, and isn't part of it.
+	msg = NewSyslogMessage()
+	msg.Parse("<13>1 2003-10-11T22:14:15.003Z host app - - - \xef\xbb\xbfm\xc3\xa9ssage", "127.0.0.1")
+	if msg.Message != "m\xc3\xa9ssage" || msg.Fields()["SYSLOG_MSG_CHARSET"] != "UTF-8" {
+		t.Errorf("Expected a UTF-8 message without BOM, got %q, %v", msg.Message, msg.Fields())
+	}
+
+	// Fields given as NILVALUE are left out.
+	msg = NewSyslogMessage()
+	msg.Parse(`<13>1 2003-10-11T22:14:15.003Z - - - - - message`, "127.0.0.1")
+	for _, name := range []string{"SYSLOG_IDENTIFIER", "SYSLOG_PID", "SYSLOG_MSGID", "MESSAGE_ID", "SYSLOG_HOSTNAME", "SYSLOG_STRUCTURED_DATA"} {
+		if value, ok := msg.Fields()[name]; ok {
+			t.Errorf("%s: expected no field, got %q", name, value)
+		}
+	}
+}
+
+func TestParseStampYear(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("Could not set up timezone: %s", err.Error())
+	}
+
+	var tests = []struct {
+		now      time.Time
+		location *time.Location
+		buf      string
+		expected time.Time
+	}{
+		{time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC), nil, "<13>Jun  1 11:59:00 host app: x",
+			time.Date(2016, 6, 1, 11, 59, 0, 0, time.UTC)},
+		{time.Date(2016, 1, 1, 0, 0, 5, 0, time.UTC), nil, "<13>Dec 31 23:59:59 host app: x",
+			time.Date(2015, 12, 31, 23, 59, 59, 0, time.UTC)},
+		{time.Date(2015, 12, 31, 23, 59, 58, 0, time.UTC), nil, "<13>Jan  1 00:00:01 host app: x",
+			time.Date(2016, 1, 1, 0, 0, 1, 0, time.UTC)},
+		{time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC), berlin, "<13>Jun  1 13:59:00 host app: x",
+			time.Date(2016, 6, 1, 11, 59, 0, 0, time.UTC)},
+	}
+
+	for num, test := range tests {
+		msg := NewSyslogMessage()
+		msg.clock = clockwork.NewFakeClockAt(test.now)
+		msg.Location = test.location
+		msg.ParseFormat(test.buf, "", "rfc3164")
+		if !msg.Timestamp.Equal(test.expected) {
+			t.Errorf("Failed test %d: expected %s, got %s", num, test.expected, msg.Timestamp)
+		}
+	}
+
+	// Too short to hold a timestamp.
+	msg := NewSyslogMessage()
+	msg.ParseFormat("<13>Dec 15", "", "")
+	if msg.Message != "Dec 15" {
+		t.Errorf("Expected the message to be kept, got %q", msg.Message)
+	}
+}
+
+func TestParseFormatChain(t *testing.T) {
+	var tests = []struct {
+		format   string
+		buf      string
+		severity int
+		version  int
+		hostname string
+		message  string
+	}{
+		{"", `<11>Dec 15 11:55:02 host user: message`, 3, 0, "host", "message"},
+		{"", `<11>1 2015-12-15T11:55:02Z host user - - - message`, 3, 1, "host", "message"},
+		{"", `<11>message`, 3, 0, "", "message"},
+		{"", `message`, 6, 0, "", "message"},
+		{"rfc5424", `<11>Dec 15 11:55:02 host user: message`, 3, 0, "", "Dec 15 11:55:02 host user: message"},
+		{"rfc3164/raw", `<11>Dec 15 11:55:02 host user: message`, 3, 0, "host", "message"},
+		{"rfc3164/raw", `<11>1 2015-12-15T11:55:02Z host user - - - message`, 6, 0, "", "<11>1 2015-12-15T11:55:02Z host user - - - message"},
+		{"rfc3164/rfc5424", `<11>1 2015-12-15T11:55:02Z host user - - - message`, 3, 1, "host", "message"},
+		{"raw", `<11>Dec 15 11:55:02 host user: message`, 6, 0, "", "<11>Dec 15 11:55:02 host user: message"},
+		{"", ``, 6, 0, "", ""},
+		{"", `<11>1`, 3, 0, "", "1"},
+	}
+
+	for num, test := range tests {
+		msg := NewSyslogMessage()
+		msg.Severity = 6
+		msg.ParseFormat(test.buf, "", test.format)
+		if msg.Severity != test.severity || msg.Version != test.version || msg.Hostname != test.hostname || msg.Message != test.message {
+			t.Errorf("Failed test %d: got severity %d, version %d, hostname %q, message %q", num, msg.Severity, msg.Version, msg.Hostname, msg.Message)
+		}
+	}
+}
+
+// Parsing works on the string each packet is copied into once as it's read,
+// slicing it up rather than copying it again: the header takes no
+// allocations at all. Structured data does, for its maps.
+func TestParseAllocs(t *testing.T) {
+	clock := clockwork.NewFakeClock()
+	for buf, expected := range map[string]float64{
+		`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog 1234 ID47 - message`:                           0,
+		`<13>Dec 15 11:55:02 host sshd[1234]: message`:                                                                 0,
+		`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog 1234 ID47 [exampleSDID@32473 iut="3"] message`: 4,
+	} {
+		allocs := testing.AllocsPerRun(100, func() {
+			msg := SyslogMessage{clock: clock}
+			msg.ParseFormat(buf, "127.0.0.1", "")
+		})
+		if allocs > expected {
+			t.Errorf("Expected at most %v allocations parsing %q, got %v", expected, buf, allocs)
+		}
+	}
+}
+
+func BenchmarkParseFormat(b *testing.B) {
+	clock := clockwork.NewFakeClock()
+	for name, buf := range map[string]string{
+		"rfc5424":    `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog 1234 ID47 - message`,
+		"rfc5424-sd": `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog 1234 ID47 [exampleSDID@32473 iut="3"] message`,
+		"rfc3164":    `<13>Dec 15 11:55:02 host sshd[1234]: message`,
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				msg := SyslogMessage{clock: clock}
+				msg.ParseFormat(buf, "127.0.0.1", "")
+			}
+		})
+	}
+}
+
+func FuzzParseSyslog(f *testing.F) {
+	for _, seed := range []string{
+		"",
+		"<",
+		"<13>",
+		"<13>1",
+		"<13>1 ",
+		"<13>Dec 15",
+		"<13>Dec 15 11:55:02",
+		`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog 1234 ID47 [exampleSDID@32473 iut="3"] message`,
+		`<13>Dec 15 11:55:02 host sshd[1234]: message`,
+		`<189>123: router1: *Mar  1 00:00:00.123 UTC: %LINK-3-UPDOWN: Interface up`,
+		`<13>1 2003-10-11T22:14:15Z - - - - - CEF:0|Vendor|Product|1.0|100|Name|5|src=10.0.0.1 msg=a\=b`,
+		`<13>1 2003-10-11T22:14:15Z - - - - - LEEF:2.0|Vendor|Product|1.0|100|^|src=10.0.0.1^dst=10.0.0.2`,
+	} {
+		f.Add(seed)
+	}
+	clock := clockwork.NewFakeClock()
+	f.Fuzz(func(t *testing.T, buf string) {
+		for _, format := range []string{"", "raw", "rfc3164/raw"} {
+			msg := SyslogMessage{clock: clock, TimestampLayouts: []string{"2006-01-02 15:04:05", "unix"}}
+			msg.ParseFormat(buf, "127.0.0.1", format)
+			msg.Fields()
+		}
+		for _, config := range []*SocketConfig{
+			{Strict: true},
+			{Strict: true, Format: "rfc3164"},
+		} {
+			config.Validate(buf)
+		}
+		ParseJSON(buf, "JSON_")
+		ParseKeyValues(buf)
+	})
+}
+
+func TestPriority(t *testing.T) {
+	floors := map[int]int{0: 4, 10: 3}
+	tests := []struct {
+		facility int
+		severity int
+		floors   map[int]int
+		expected journal.Priority
+	}{
+		{1, 6, nil, journal.PriInfo},
+		{0, 6, nil, journal.PriInfo},
+		{0, 6, floors, journal.PriWarning},
+		{0, 2, floors, journal.PriCrit},
+		{10, 7, floors, journal.PriErr},
+		{4, 7, floors, journal.PriDebug},
+		{1, 9, nil, journal.PriDebug},
+		{1, -1, nil, journal.PriEmerg},
+	}
+
+	for num, test := range tests {
+		msg := NewSyslogMessage()
+		msg.Facility, msg.Severity, msg.FacilityPriorities = test.facility, test.severity, test.floors
+		if got := msg.Priority(); got != test.expected {
+			t.Errorf("Failed test %d: expected %d, got %d", num, test.expected, got)
+		}
+	}
+}
+
+func TestTimestampFields(t *testing.T) {
+	clock := clockwork.NewFakeClockAt(time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC))
+	tests := []struct {
+		buf      string
+		original string
+		rfc3339  string
+	}{
+		{"<13>Dec 15 11:55:02 host user: message", "Dec 15 11:55:02", "2015-12-15T11:55:02Z"},
+		{"<13>Dec  5 11:55:02 host user: message", "Dec  5 11:55:02", "2015-12-05T11:55:02Z"},
+		{"<13>1 2015-12-15T11:54:41.946675-08:00 host user - - - message", "2015-12-15T11:54:41.946675-08:00", "2015-12-15T11:54:41.946675-08:00"},
+		{"<13>1425213296 host user: message", "1425213296", "2015-03-01T12:34:56Z"},
+		{"no header at all", "", "2016-01-01T00:00:00Z"},
+	}
+
+	for num, test := range tests {
+		msg := NewSyslogMessage()
+		msg.clock = clock
+		msg.Timestamp = clock.Now()
+		msg.Parse(test.buf, "127.0.0.1")
+		fields := msg.Fields()
+		original, ok := fields["SYSLOG_TIMESTAMP"]
+		if original != test.original || ok != (test.original != "") || fields["SYSLOG_TIMESTAMP_RFC3339"] != test.rfc3339 {
+			t.Errorf("Failed test %d: expected %q and %q, got %q and %q", num, test.original, test.rfc3339, original, fields["SYSLOG_TIMESTAMP_RFC3339"])
+		}
+	}
+}
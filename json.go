@@ -0,0 +1,72 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ParseJSON recognizes a message consisting of a JSON object, returning its
+// top-level keys as journal fields named with prefix (which may be empty,
+// though the fields journald fills in from the message itself, MESSAGE and
+// PRIORITY, are never set). Nested objects and arrays are kept as JSON. It
+// returns nil for anything else.
+func ParseJSON(payload string, prefix string) map[string]string {
+	payload = strings.TrimSpace(payload)
+	if !strings.HasPrefix(payload, "{") {
+		return nil
+	}
+	var object map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		// Trailing garbage.
+		return nil
+	}
+
+	fields := map[string]string{}
+	for key, value := range object {
+		name := JournalFieldName(prefix, key)
+		if name == "" || name == "MESSAGE" || name == "PRIORITY" {
+			continue
+		}
+		fields[name] = jsonString(value)
+	}
+	return fields
+}
+
+// ParseCEE recognizes a Lumberjack (CEE) payload, the cookie "@cee:" followed
+// by a JSON object, returning its top-level keys as CEE_<KEY>. It returns nil
+// for anything else.
+func ParseCEE(payload string) map[string]string {
+	payload = strings.TrimLeft(payload, " ")
+	if !strings.HasPrefix(payload, "@cee:") {
+		return nil
+	}
+	return ParseJSON(payload[len("@cee:"):], "CEE_")
+}
+
+// jsonString formats a decoded JSON value for the journal.
+func jsonString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case nil:
+		return ""
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
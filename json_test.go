@@ -0,0 +1,52 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseJSON(t *testing.T) {
+	payload := ` {"level":"info","user id":42,"ok":true,"nested":{"a":[1,2]},"none":null,"message":"hello"}`
+	expected := map[string]string{
+		"JSON_LEVEL":   "info",
+		"JSON_USER_ID": "42",
+		"JSON_OK":      "true",
+		"JSON_NESTED":  `{"a":[1,2]}`,
+		"JSON_NONE":    "",
+		"JSON_MESSAGE": "hello",
+	}
+	if fields := ParseJSON(payload, "JSON_"); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected: %q\n     Got: %q", expected, fields)
+	}
+
+	// Without a prefix, the message itself can't be replaced.
+	expected = map[string]string{"LEVEL": "info"}
+	if fields := ParseJSON(`{"level":"info","message":"hello","priority":0}`, ""); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected: %q\n     Got: %q", expected, fields)
+	}
+
+	for _, other := range []string{"message", `["a"]`, `{"a":1} trailing`, `{"a":`} {
+		if fields := ParseJSON(other, "JSON_"); fields != nil {
+			t.Errorf("%q: expected no fields, got %q", other, fields)
+		}
+	}
+}
+
+func TestParseCEE(t *testing.T) {
+	expected := map[string]string{"CEE_PNAME": "auditd", "CEE_MSG": "login", "CEE_UID": "1000"}
+	if fields := ParseCEE(`@cee: {"pname":"auditd","msg":"login","uid":1000}`); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected: %q\n     Got: %q", expected, fields)
+	}
+
+	msg := NewSyslogMessage()
+	msg.Parse(`<13>Dec 15 11:55:02 host auditd: @cee:{"msg":"login"}`, "127.0.0.1")
+	if got := msg.Fields()["CEE_MSG"]; got != "login" {
+		t.Errorf("Expected CEE_MSG=login, got %q", got)
+	}
+
+	for _, other := range []string{`{"msg":"login"}`, `@cee: not json`, `@cee`} {
+		if fields := ParseCEE(other); fields != nil {
+			t.Errorf("%q: expected no fields, got %q", other, fields)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Messages are handed to the Kafka writer in batches of up to
+// kafkaBatchSize, or every kafkaBatchWait.
+const (
+	kafkaBatchSize = 100
+	kafkaBatchWait = 100 * time.Millisecond
+)
+
+// KafkaSink publishes entries, as JSON objects of their journal fields, to a
+// Kafka topic. Messages are queued, and sent in batches in the background,
+// so that a broker that's down or slow doesn't hold up anything else; ones
+// Kafka won't take are logged and dropped.
+type KafkaSink struct {
+	writer  kafkaWriter
+	key     string
+	batches *batcher[kafka.Message]
+}
+
+// kafkaWriter is the part of a *kafka.Writer KafkaSink uses.
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+func init() {
+	RegisterSink("kafka", func() (Sink, error) {
+		if *kafkaBrokers == "" {
+			return nil, nil
+		}
+		return NewKafkaSink(*kafkaBrokers, *kafkaTopic, *kafkaKey)
+	})
+}
+
+// NewKafkaSink returns a KafkaSink publishing to topic on the given brokers
+// (a comma-separated list of HOST:PORT), and starts it sending. Messages are
+// keyed by key, one of "hostname", "tag" or "none", so that those with the
+// same key land in the same partition, in order; with "none" they're spread
+// evenly.
+func NewKafkaSink(brokers string, topic string, key string) (*KafkaSink, error) {
+	if brokers == "" || topic == "" {
+		return nil, fmt.Errorf("need both Kafka brokers and a topic")
+	}
+	var balancer kafka.Balancer
+	switch key {
+	case "hostname", "tag":
+		balancer = &kafka.Hash{}
+	case "none":
+		balancer = &kafka.RoundRobin{}
+	default:
+		return nil, fmt.Errorf("bad Kafka key %q; expected hostname, tag or none", key)
+	}
+	s := &KafkaSink{key: key}
+	s.writer = &kafka.Writer{
+		Addr:     kafka.TCP(strings.Split(brokers, ",")...),
+		Topic:    topic,
+		Balancer: balancer,
+		// The batcher's gathered the messages already, so the writer
+		// needn't wait for more.
+		BatchSize:    kafkaBatchSize,
+		BatchTimeout: time.Millisecond,
+		RequiredAcks: kafka.RequireOne,
+	}
+	s.batches = newBatcher(kafkaBatchSize, kafkaBatchWait, s.send)
+	return s, nil
+}
+
+// Write queues a message for the topic, dropping it if the queue is full.
+func (s *KafkaSink) Write(msg *SyslogMessage) error {
+	vars := msg.Entry
+	value, err := entryJSON(msg.Message, int(msg.Priority()), vars)
+	if err != nil {
+		return err
+	}
+	message := kafka.Message{Value: value}
+	switch s.key {
+	case "hostname":
+		message.Key = []byte(vars["SYSLOG_HOSTNAME"])
+	case "tag":
+		message.Key = []byte(vars["SYSLOG_IDENTIFIER"])
+	}
+	if !s.batches.Add(message) {
+		return fmt.Errorf("dropped a message for Kafka, which isn't keeping up")
+	}
+	return nil
+}
+
+// send publishes a batch of messages, logging them as dropped if Kafka won't
+// take them.
+func (s *KafkaSink) send(batch []kafka.Message) {
+	if err := s.writer.WriteMessages(context.Background(), batch...); err != nil {
+		log.Printf("dropped %d messages for Kafka: %s", len(batch), err)
+	}
+}
+
+// Flush sends the messages queued and batched up, giving up on waiting for
+// them after relayCloseTimeout.
+func (s *KafkaSink) Flush() error {
+	if !s.batches.Flush(relayCloseTimeout) {
+		return fmt.Errorf("gave up waiting for Kafka to take the messages queued for it")
+	}
+	return nil
+}
+
+// Close flushes the sink, and closes the writer.
+func (s *KafkaSink) Close() error {
+	err := s.Flush()
+	if closeErr := s.writer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// fakeKafkaWriter records the messages written, fails writes while err is
+// set, and with block set, waits for it to be closed first, as for a broker
+// that's slow to answer.
+type fakeKafkaWriter struct {
+	written []string
+	err     error
+	block   chan struct{}
+	closed  bool
+}
+
+func (f *fakeKafkaWriter) WriteMessages(ctx context.Context, messages ...kafka.Message) error {
+	if f.block != nil {
+		<-f.block
+	}
+	if f.err != nil {
+		return f.err
+	}
+	for _, message := range messages {
+		f.written = append(f.written, string(message.Key)+" "+strings.TrimSpace(string(message.Value)))
+	}
+	return nil
+}
+
+func (f *fakeKafkaWriter) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestKafkaSinkWrite(t *testing.T) {
+	msg := NewSyslogMessage()
+	msg.Parse("<34>1 2026-10-16T12:00:00Z host1 su - - - hello", "192.0.2.1:514")
+	msg.Entry = map[string]string{"SYSLOG_HOSTNAME": "host1", "SYSLOG_IDENTIFIER": "su"}
+
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"hostname", "host1"},
+		{"tag", "su"},
+		{"none", ""},
+	}
+	for num, test := range tests {
+		sink, err := NewKafkaSink("kafka1:9092", "syslog", test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer := &fakeKafkaWriter{}
+		sink.writer = writer
+
+		if err := sink.Write(msg); err != nil {
+			t.Errorf("Failed test %d: %s", num, err)
+		}
+		if err := sink.Flush(); err != nil {
+			t.Errorf("Failed test %d: %s", num, err)
+		}
+		// Messages Kafka won't take are dropped in the background.
+		writer.err = errors.New("broker down")
+		if err := sink.Write(msg); err != nil {
+			t.Errorf("Failed test %d: %s", num, err)
+		}
+		if err := sink.Flush(); err != nil {
+			t.Errorf("Failed test %d: %s", num, err)
+		}
+		// Once it's back, they go through again.
+		writer.err = nil
+		if err := sink.Write(msg); err != nil {
+			t.Errorf("Failed test %d: %s", num, err)
+		}
+		if err := sink.Close(); err != nil || !writer.closed {
+			t.Errorf("Failed test %d: expected the writer closed, got %v", num, err)
+		}
+
+		value := `{"MESSAGE":"hello","PRIORITY":"2","SYSLOG_HOSTNAME":"host1","SYSLOG_IDENTIFIER":"su"}`
+		expected := []string{test.expected + " " + value, test.expected + " " + value}
+		if !reflect.DeepEqual(writer.written, expected) {
+			t.Errorf("Failed test %d: expected %q, got %q", num, expected, writer.written)
+		}
+	}
+}
+
+func TestNewKafkaSink(t *testing.T) {
+	tests := []struct {
+		brokers string
+		topic   string
+		key     string
+		ok      bool
+	}{
+		{"kafka1:9092,kafka2:9092", "syslog", "hostname", true},
+		{"kafka1:9092", "syslog", "tag", true},
+		{"kafka1:9092", "syslog", "none", true},
+		{"kafka1:9092", "syslog", "facility", false},
+		{"kafka1:9092", "", "hostname", false},
+		{"", "syslog", "hostname", false},
+	}
+
+	for num, test := range tests {
+		_, err := NewKafkaSink(test.brokers, test.topic, test.key)
+		if (err == nil) != test.ok {
+			t.Errorf("Failed test %d: expected ok %v, got %v", num, test.ok, err)
+		}
+	}
+}
+
+func TestKafkaSinkFlush(t *testing.T) {
+	sink, err := NewKafkaSink("kafka1:9092", "syslog", "hostname")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := &fakeKafkaWriter{block: make(chan struct{})}
+	sink.writer = writer
+	if err := sink.Flush(); err != nil {
+		t.Errorf("Expected nothing to wait for, got %s", err)
+	}
+
+	// A broker that's slow to answer doesn't hold up writes, but once too
+	// many are waiting, they're dropped.
+	msg := NewSyslogMessage()
+	msg.Parse("<13>1 2026-10-16T12:00:00Z host1 app - - - hello", "192.0.2.1:514")
+	msg.Entry = msg.Fields()
+	queued := 0
+	for ; queued < 2*relayQueueSize; queued++ {
+		if sink.Write(msg) != nil {
+			break
+		}
+	}
+	if queued == 2*relayQueueSize {
+		t.Errorf("Expected writes to fail once the queue was full")
+	}
+
+	// The flush waits until the writer's done with all of them.
+	flushed := make(chan error)
+	go func() {
+		flushed <- sink.Flush()
+	}()
+	select {
+	case err := <-flushed:
+		t.Fatalf("Expected the flush to wait for the writer, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(writer.block)
+	select {
+	case err := <-flushed:
+		if err != nil {
+			t.Errorf("Expected the flush to finish, got %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the flush")
+	}
+	if len(writer.written) != queued {
+		t.Errorf("Expected %d messages written, got %d", queued, len(writer.written))
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"strings"
+)
+
+// ParseKeyValues splits a payload of space-separated key=value pairs, in the
+// style of logfmt and FortiGate logs. Values may be double-quoted (with
+// backslash escapes) to include spaces. Words which aren't key=value pairs
+// are skipped.
+func ParseKeyValues(payload string) map[string]string {
+	pairs := map[string]string{}
+	s := payload
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return pairs
+		}
+		end := strings.IndexAny(s, " \t=")
+		if end < 0 {
+			return pairs
+		}
+		if s[end] != '=' || end == 0 {
+			s = s[end+1:]
+			continue
+		}
+		key := s[:end]
+		s = s[end+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			value, s = scanQuoted(s[1:])
+		} else {
+			end := strings.IndexAny(s, " \t")
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+		}
+		pairs[key] = value
+	}
+}
+
+// scanQuoted returns the unescaped contents of a double-quoted value whose
+// opening quote has been consumed, and what follows the closing quote. An
+// unterminated value runs to the end of s.
+func scanQuoted(s string) (string, string) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' {
+			return b.String(), s[i+1:]
+		}
+		if c == '\\' && i+1 < len(s) {
+			i++
+			c = s[i]
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), ""
+}
+
+// KeyValues returns journal fields for the keys of a key=value payload which
+// are listed in KeyValueFields.
+func (config *SocketConfig) KeyValues(payload string) map[string]string {
+	fields := map[string]string{}
+	for key, value := range ParseKeyValues(payload) {
+		for _, allowed := range config.KeyValueFields {
+			if allowed == key || allowed == "*" {
+				if name := JournalFieldName(config.KeyValuePrefix, key); name != "" && name != "MESSAGE" && name != "PRIORITY" {
+					fields[name] = value
+				}
+				break
+			}
+		}
+	}
+	return fields
+}
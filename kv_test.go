@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKeyValues(t *testing.T) {
+	payload := `date=2016-01-04 time=16:31:51 devname="FG100D \"main\"" type=traffic  srcip=10.0.0.1 not-a-pair =x msg="Connection  closed" empty= trailing="unterminated`
+	expected := map[string]string{
+		"date":     "2016-01-04",
+		"time":     "16:31:51",
+		"devname":  `FG100D "main"`,
+		"type":     "traffic",
+		"srcip":    "10.0.0.1",
+		"msg":      "Connection  closed",
+		"empty":    "",
+		"trailing": "unterminated",
+	}
+	if pairs := ParseKeyValues(payload); !reflect.DeepEqual(pairs, expected) {
+		t.Errorf("Expected: %q\n     Got: %q", expected, pairs)
+	}
+
+	config := NewSocketConfig("")
+	config.KeyValueFields = []string{"srcip", "devname", "dstip"}
+	expected = map[string]string{"KV_SRCIP": "10.0.0.1", "KV_DEVNAME": `FG100D "main"`}
+	if fields := config.KeyValues(payload); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected: %q\n     Got: %q", expected, fields)
+	}
+
+	config.KeyValueFields = []string{"*"}
+	config.KeyValuePrefix = ""
+	expected = map[string]string{"LEVEL": "info", "USER": "joe"}
+	if fields := config.KeyValues(`level=info user=joe message=hi`); !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected: %q\n     Got: %q", expected, fields)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The LEEF header fields following the version, in order.
+var leefHeaderFields = []string{
+	"LEEF_VENDOR", "LEEF_PRODUCT", "LEEF_PRODUCT_VERSION", "LEEF_EVENT_ID",
+}
+
+// ParseLEEF recognizes an IBM QRadar Log Event Extended Format payload,
+// "LEEF:Version|Vendor|Product|Version|EventID|Attributes", returning its
+// header as LEEF_VERSION, LEEF_VENDOR and so on, and each key=value
+// attribute as LEEF_<KEY>. Attributes are separated by tabs, or in LEEF 2.0,
+// by the character given in an extra header field (either literally, or in
+// hex as xHH or 0xHH). It returns nil for anything else.
+func ParseLEEF(payload string) map[string]string {
+	payload = strings.TrimLeft(payload, " ")
+	if !strings.HasPrefix(payload, "LEEF:") {
+		return nil
+	}
+	header := strings.SplitN(payload[len("LEEF:"):], "|", len(leefHeaderFields)+2)
+	if len(header) != len(leefHeaderFields)+2 {
+		return nil
+	}
+	attributes := header[len(header)-1]
+
+	delimiter := "\t"
+	if header[0] == "2.0" {
+		parts := strings.SplitN(attributes, "|", 2)
+		if len(parts) == 2 {
+			if d, ok := leefDelimiter(parts[0]); ok {
+				delimiter, attributes = d, parts[1]
+			}
+		}
+	}
+
+	fields := map[string]string{"LEEF_VERSION": header[0]}
+	for i, name := range leefHeaderFields {
+		fields[name] = header[i+1]
+	}
+	for _, attribute := range strings.Split(attributes, delimiter) {
+		parts := strings.SplitN(attribute, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if name := JournalFieldName("LEEF_", parts[0]); name != "" {
+			fields[name] = parts[1]
+		}
+	}
+	return fields
+}
+
+// leefDelimiter interprets the delimiter field of a LEEF 2.0 header.
+func leefDelimiter(field string) (string, bool) {
+	switch {
+	case field == "":
+		return "\t", true
+	case len(field) == 1:
+		return field, true
+	case strings.HasPrefix(field, "0x") || strings.HasPrefix(field, "x"):
+		code, err := strconv.ParseUint(field[strings.IndexByte(field, 'x')+1:], 16, 8)
+		if err != nil || code == 0 {
+			return "", false
+		}
+		return string(rune(code)), true
+	}
+	return "", false
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLEEF(t *testing.T) {
+	var tests = []struct {
+		payload  string
+		expected map[string]string
+	}{
+		{
+			"LEEF:1.0|Microsoft|MSExchange|4.0 SP1|15345|src=192.0.2.1\tdst=172.50.123.1\tsev=5\tusrName=joe.bloggs",
+			map[string]string{
+				"LEEF_VERSION":         "1.0",
+				"LEEF_VENDOR":          "Microsoft",
+				"LEEF_PRODUCT":         "MSExchange",
+				"LEEF_PRODUCT_VERSION": "4.0 SP1",
+				"LEEF_EVENT_ID":        "15345",
+				"LEEF_SRC":             "192.0.2.1",
+				"LEEF_DST":             "172.50.123.1",
+				"LEEF_SEV":             "5",
+				"LEEF_USRNAME":         "joe.bloggs",
+			},
+		},
+		{
+			"LEEF:2.0|Lancope|StealthWatch|1.0|41|^|src=10.0.1.8^dst=10.0.0.5^msg=a=b c",
+			map[string]string{
+				"LEEF_VERSION":         "2.0",
+				"LEEF_VENDOR":          "Lancope",
+				"LEEF_PRODUCT":         "StealthWatch",
+				"LEEF_PRODUCT_VERSION": "1.0",
+				"LEEF_EVENT_ID":        "41",
+				"LEEF_SRC":             "10.0.1.8",
+				"LEEF_DST":             "10.0.0.5",
+				"LEEF_MSG":             "a=b c",
+			},
+		},
+		{
+			"LEEF:2.0|Vendor|Product|1|2|0x7c|a=1|b=2",
+			map[string]string{
+				"LEEF_VERSION":         "2.0",
+				"LEEF_VENDOR":          "Vendor",
+				"LEEF_PRODUCT":         "Product",
+				"LEEF_PRODUCT_VERSION": "1",
+				"LEEF_EVENT_ID":        "2",
+				"LEEF_A":               "1",
+				"LEEF_B":               "2",
+			},
+		},
+		{"LEEF:1.0|Vendor|Product", nil},
+		{"message", nil},
+	}
+
+	for num, test := range tests {
+		if fields := ParseLEEF(test.payload); !reflect.DeepEqual(fields, test.expected) {
+			t.Errorf("Failed test %d:\nExpected: %q\n     Got: %q", num, test.expected, fields)
+		}
+	}
+}
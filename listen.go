@@ -0,0 +1,267 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/coreos/go-systemd/activation"
+	"golang.org/x/sys/unix"
+)
+
+// stringList is a flag.Value collecting every occurrence of a repeatable
+// flag, so e.g. -listen-udp can be given once per address.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// removeStaleSocket deletes a unix socket left behind at path by a previous
+// instance, so that we can bind to it again. Anything other than a socket is
+// left alone, and the subsequent bind will fail.
+func removeStaleSocket(path string) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+}
+
+// isAbstractSocket reports whether path names a socket in the abstract
+// namespace ("@name"), which has no file to clean up or set permissions on,
+// and is reachable from anywhere sharing our network namespace.
+func isAbstractSocket(path string) bool {
+	return strings.HasPrefix(path, "@")
+}
+
+// ListenUnixgram binds an AF_UNIX SOCK_DGRAM socket at path that any local
+// process may write to, in the manner of /dev/log. A path beginning with "@"
+// binds in the abstract namespace instead.
+func ListenUnixgram(path string) (*net.UnixConn, error) {
+	if !isAbstractSocket(path) {
+		removeStaleSocket(path)
+	}
+	fd, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	if !isAbstractSocket(path) {
+		if err := os.Chmod(path, 0666); err != nil {
+			fd.Close()
+			return nil, err
+		}
+	}
+	return fd, nil
+}
+
+// ListenUnix binds an AF_UNIX SOCK_STREAM socket at path that any local
+// process may connect to. As with ListenUnixgram, "@name" is abstract.
+func ListenUnix(path string) (*net.UnixListener, error) {
+	if !isAbstractSocket(path) {
+		removeStaleSocket(path)
+	}
+	fd, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if !isAbstractSocket(path) {
+		if err := os.Chmod(path, 0666); err != nil {
+			fd.Close()
+			return nil, err
+		}
+	}
+	return fd, nil
+}
+
+// ListenReusePort binds n UDP sockets to the same address with SO_REUSEPORT,
+// letting the kernel spread incoming datagrams across them (and so across
+// the goroutines reading them).
+func ListenReusePort(addr string, n int) ([]net.PacketConn, error) {
+	config := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(s uintptr) {
+				sockErr = unix.SetsockoptInt(int(s), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		},
+	}
+
+	fds := make([]net.PacketConn, 0, n)
+	for i := 0; i < n; i++ {
+		fd, err := config.ListenPacket(context.Background(), "udp", addr)
+		if err != nil {
+			for _, fd := range fds {
+				fd.Close()
+			}
+			return nil, err
+		}
+		fds = append(fds, fd)
+	}
+	return fds, nil
+}
+
+// ActivatedSockets returns the sockets passed in by systemd, keyed by their
+// FileDescriptorName= (sockets without one are named LISTEN_FD_<n>). Most
+// are returned as listeners or packet sockets, but one-to-many SCTP sockets,
+// which the net package can't handle, are returned as raw descriptors. Any
+// other kind of socket is reported and skipped.
+func ActivatedSockets() (map[string][]net.Listener, map[string][]net.PacketConn, map[string][]int) {
+	listeners := map[string][]net.Listener{}
+	packetConns := map[string][]net.PacketConn{}
+	sctpSockets := map[string][]int{}
+	for _, f := range activation.Files(false) {
+		if fd, err := net.FileListener(f); err == nil {
+			listeners[f.Name()] = append(listeners[f.Name()], fd)
+		} else if fd, err := net.FilePacketConn(f); err == nil {
+			packetConns[f.Name()] = append(packetConns[f.Name()], fd)
+		} else if IsSCTPSeqPacket(int(f.Fd())) {
+			if fd, err := unix.Dup(int(f.Fd())); err == nil {
+				unix.CloseOnExec(fd)
+				sctpSockets[f.Name()] = append(sctpSockets[f.Name()], fd)
+			} else {
+				log.Printf("ignoring socket %s: %s", f.Name(), os.NewSyscallError("dup", err))
+			}
+		} else {
+			log.Printf("ignoring socket %s: unsupported socket type", f.Name())
+		}
+		f.Close()
+	}
+	return listeners, packetConns, sctpSockets
+}
+
+// timeoutConn enforces read and idle timeouts on a stream connection. Each
+// Read must make progress within readTimeout, and the connection as a whole
+// must produce a complete message (see MessageDone) within idleTimeout.
+// Either may be zero to disable it. Once draining, reads are also cut off
+// at the drain deadline.
+type timeoutConn struct {
+	net.Conn
+	readTimeout  time.Duration
+	idleTimeout  time.Duration
+	idleDeadline time.Time
+
+	mu            sync.Mutex
+	drainDeadline time.Time
+}
+
+func newTimeoutConn(conn net.Conn, readTimeout time.Duration, idleTimeout time.Duration) *timeoutConn {
+	c := &timeoutConn{Conn: conn, readTimeout: readTimeout, idleTimeout: idleTimeout}
+	c.MessageDone()
+	return c
+}
+
+// Deadline returns the time by which the next Read must complete.
+func (c *timeoutConn) Deadline() time.Time {
+	var deadline time.Time
+	if c.readTimeout > 0 {
+		deadline = time.Now().Add(c.readTimeout)
+	}
+	if !c.idleDeadline.IsZero() && (deadline.IsZero() || c.idleDeadline.Before(deadline)) {
+		deadline = c.idleDeadline
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.drainDeadline.IsZero() && (deadline.IsZero() || c.drainDeadline.Before(deadline)) {
+		deadline = c.drainDeadline
+	}
+	return deadline
+}
+
+// Drain cuts off reads from the connection at deadline, interrupting any
+// Read in progress if need be. It's safe to call from any goroutine.
+func (c *timeoutConn) Drain(deadline time.Time) {
+	c.mu.Lock()
+	c.drainDeadline = deadline
+	c.mu.Unlock()
+	c.Conn.SetReadDeadline(c.Deadline())
+}
+
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(c.Deadline()); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+// MessageDone restarts the idle timeout, once a complete message has been
+// read from the connection.
+func (c *timeoutConn) MessageDone() {
+	if c.idleTimeout > 0 {
+		c.idleDeadline = time.Now().Add(c.idleTimeout)
+	}
+}
+
+// isTimeout reports whether err is the result of a read deadline passing.
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// connectionSlots limits the number of stream connections being served at
+// once, across all listeners; nil means no limit.
+var connectionSlots chan struct{}
+
+// The range of delays between retries of a failing Accept.
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+)
+
+// AcceptConnections repeatedly accepts new connections from a stream
+// listener, and hands each one off to its own handle goroutine. Once the
+// -max-connections limit is reached, it stops accepting until a connection
+// closes, leaving new clients queued in the kernel's backlog. Failing Accepts
+// (e.g. from running out of file descriptors) are retried with exponential
+// backoff. It returns when the listener is closed.
+func AcceptConnections(fd net.Listener, handle func(net.Conn)) {
+	slots := connectionSlots
+	backoff := time.Duration(0)
+	for {
+		if slots != nil {
+			slots <- struct{}{}
+		}
+		conn, err := fd.Accept()
+		if err != nil {
+			if slots != nil {
+				<-slots
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if backoff == 0 {
+				backoff = minAcceptBackoff
+			} else if backoff *= 2; backoff > maxAcceptBackoff {
+				backoff = maxAcceptBackoff
+			}
+			log.Printf("%s; retrying in %s", err, backoff)
+			time.Sleep(backoff)
+			continue
+		}
+		backoff = 0
+
+		drainer.Go(func() {
+			if slots != nil {
+				defer func() { <-slots }()
+			}
+			runRecovered("connection from "+conn.RemoteAddr().String(), func() { handle(conn) })
+		})
+	}
+}
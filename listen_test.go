@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTimeoutConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	tconn := newTimeoutConn(server, 0, 50*time.Millisecond)
+
+	// A client trickling in a partial message doesn't reset the idle
+	// timeout; whatever it did send is still ingested when it's cut off.
+	go client.Write([]byte("<13>1 - - "))
+	var ingested []string
+	err := ReadStream(tconn, "127.0.0.1", func(buf string, source string) {
+		tconn.MessageDone()
+		ingested = append(ingested, buf)
+	})
+	if !isTimeout(err) {
+		t.Errorf("Expected a timeout, got: %v", err)
+	}
+	if len(ingested) != 1 || ingested[0] != "<13>1 - - " {
+		t.Errorf("Expected the partial message, got %q", ingested)
+	}
+}
+
+func TestAcceptConnectionsLimit(t *testing.T) {
+	fd, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err.Error())
+	}
+	connectionSlots = make(chan struct{}, 1)
+	defer func() { connectionSlots = nil }()
+
+	handled := make(chan net.Conn)
+	done := make(chan struct{})
+	go func() {
+		AcceptConnections(fd, func(conn net.Conn) {
+			handled <- conn
+			<-done
+		})
+	}()
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", fd.Addr().String())
+		if err != nil {
+			t.Fatalf("Could not connect: %s", err.Error())
+		}
+		defer conn.Close()
+	}
+
+	<-handled
+	select {
+	case <-handled:
+		t.Fatal("Second connection handled while at the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Once the first connection is done, the second gets its turn.
+	done <- struct{}{}
+	<-handled
+	fd.Close()
+	done <- struct{}{}
+}
+
+func TestListenAbstractUnix(t *testing.T) {
+	path := fmt.Sprintf("@journald-syslog-test-%d", os.Getpid())
+
+	fd, err := ListenUnixgram(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer fd.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected no file at %s, got %v", path, err)
+	}
+
+	client, err := net.Dial("unixgram", path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	defer client.Close()
+	client.Write([]byte("<13>hello"))
+
+	buf := make([]byte, PACKETSIZE)
+	fd.SetReadDeadline(time.Now().Add(time.Second))
+	count, err := fd.Read(buf)
+	if err != nil || string(buf[:count]) != "<13>hello" {
+		t.Errorf("Expected <13>hello, got %q, %v", buf[:count], err)
+	}
+}
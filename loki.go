@@ -0,0 +1,177 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pushes to Loki which fail with a server error (or a 429) are tried this
+// many times in all, backing off between tries, before the batch is dropped.
+const lokiAttempts = 5
+
+// lokiLabels are the journal fields entries are labelled with in Loki, and
+// the names of those labels.
+var lokiLabels = []struct{ field, label string }{
+	{"SYSLOG_HOSTNAME", "host"},
+	{"SYSLOG_FACILITY_NAME", "facility"},
+	{"SYSLOG_SEVERITY_NAME", "severity"},
+}
+
+// lokiEntry is an entry waiting to be pushed to Loki.
+type lokiEntry struct {
+	labels string
+	stream map[string]string
+	time   time.Time
+	line   string
+}
+
+// LokiSink pushes entries to Grafana Loki's push API, in batches of up to
+// BatchSize or every BatchWait, whichever comes first. Each is labelled with
+// its hostname, facility and severity; the line is the message, preceded by
+// the program that sent it.
+type LokiSink struct {
+	url     string
+	client  *http.Client
+	batches *batcher[*lokiEntry]
+}
+
+func init() {
+	RegisterSink("loki", func() (Sink, error) {
+		if *lokiURL == "" {
+			return nil, nil
+		}
+		return NewLokiSink(*lokiURL, *lokiBatchSize, *lokiBatchWait)
+	})
+}
+
+// NewLokiSink returns a LokiSink pushing to url, such as
+// http://loki:3100/loki/api/v1/push, and starts it sending.
+func NewLokiSink(url string, batchSize int, batchWait time.Duration) (*LokiSink, error) {
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("bad Loki URL %q", url)
+	}
+	if batchSize < 1 {
+		return nil, fmt.Errorf("bad Loki batch size %d", batchSize)
+	}
+	s := &LokiSink{url: url, client: &http.Client{Timeout: 30 * time.Second}}
+	s.batches = newBatcher(batchSize, batchWait, s.send)
+	return s, nil
+}
+
+// Write queues a message for Loki, dropping it if the queue is full.
+func (s *LokiSink) Write(msg *SyslogMessage) error {
+	vars := msg.Entry
+	entry := &lokiEntry{stream: map[string]string{}, time: msg.Timestamp}
+	var key strings.Builder
+	for _, label := range lokiLabels {
+		if value := vars[label.field]; value != "" {
+			entry.stream[label.label] = value
+			fmt.Fprintf(&key, "%s=%q,", label.label, value)
+		}
+	}
+	entry.labels = key.String()
+
+	var line strings.Builder
+	if ident := vars["SYSLOG_IDENTIFIER"]; ident != "" {
+		line.WriteString(ident)
+		if pid := vars["SYSLOG_PID"]; pid != "" {
+			line.WriteString("[" + pid + "]")
+		}
+		line.WriteString(": ")
+	}
+	line.WriteString(msg.Message)
+	entry.line = line.String()
+
+	if !s.batches.Add(entry) {
+		return fmt.Errorf("dropped a message for Loki, which isn't keeping up")
+	}
+	return nil
+}
+
+// Flush sends the messages queued and batched up, giving up on waiting for
+// them after relayCloseTimeout.
+func (s *LokiSink) Flush() error {
+	if !s.batches.Flush(relayCloseTimeout) {
+		return fmt.Errorf("gave up waiting for Loki to take the messages queued for it")
+	}
+	return nil
+}
+
+// Close flushes the sink.
+func (s *LokiSink) Close() error {
+	return s.Flush()
+}
+
+// send pushes a batch of entries, logging them as dropped if Loki won't
+// take them.
+func (s *LokiSink) send(batch []*lokiEntry) {
+	if err := s.push(batch); err != nil {
+		log.Printf("dropped %d messages for Loki: %s", len(batch), err)
+	}
+}
+
+// push sends a batch of entries to Loki, trying again while it's having
+// trouble.
+func (s *LokiSink) push(batch []*lokiEntry) error {
+	body, err := lokiPushBody(batch)
+	if err != nil {
+		return err
+	}
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		retry, err := s.post(body)
+		if err == nil || !retry || attempt == lokiAttempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post makes a push request, reporting whether it's worth trying again if it
+// fails.
+func (s *LokiSink) post(body []byte) (bool, error) {
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return false, nil
+	}
+	reason, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("Loki returned %s: %s", resp.Status, bytes.TrimSpace(reason))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5, err
+}
+
+// lokiPushBody encodes a batch as the JSON body of a push request, with the
+// entries grouped into streams by their labels.
+func lokiPushBody(batch []*lokiEntry) ([]byte, error) {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	var streams []*stream
+	byLabels := map[string]*stream{}
+	for _, entry := range batch {
+		st, ok := byLabels[entry.labels]
+		if !ok {
+			st = &stream{Stream: entry.stream}
+			byLabels[entry.labels] = st
+			streams = append(streams, st)
+		}
+		st.Values = append(st.Values, [2]string{strconv.FormatInt(entry.time.UnixNano(), 10), entry.line})
+	}
+	return json.Marshal(map[string]interface{}{"streams": streams})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLokiSink(t *testing.T) {
+	bodies := make(chan []byte, 10)
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failures > 0 {
+			// Loki's briefly unavailable; we should try again.
+			failures--
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies <- body
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, err := NewLokiSink(server.URL+"/loki/api/v1/push", 3, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, buf := range []string{
+		"<13>1 2026-10-16T12:00:00Z host1 app 42 - - first",
+		"<11>1 2026-10-16T12:00:01Z host1 app 42 - - second",
+		"<13>1 2026-10-16T12:00:02Z host1 app 42 - - third",
+	} {
+		msg := NewSyslogMessage()
+		msg.Parse(buf, "192.0.2.1:514")
+		msg.Entry = msg.Fields()
+		if err := sink.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var body []byte
+	select {
+	case body = <-bodies:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for a push")
+	}
+	var got interface{}
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	var expected interface{}
+	json.Unmarshal([]byte(`{"streams": [
+		{"stream": {"host": "host1", "facility": "user", "severity": "notice"},
+		 "values": [["1792152000000000000", "app[42]: first"], ["1792152002000000000", "app[42]: third"]]},
+		{"stream": {"host": "host1", "facility": "user", "severity": "err"},
+		 "values": [["1792152001000000000", "app[42]: second"]]}
+	]}`), &expected)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestNewLokiSinkErrors(t *testing.T) {
+	if _, err := NewLokiSink("loki:3100", 100, time.Second); err == nil {
+		t.Errorf("Expected an error for a URL without a scheme")
+	}
+	if _, err := NewLokiSink("http://loki:3100/loki/api/v1/push", 0, time.Second); err == nil {
+		t.Errorf("Expected an error for a batch size of 0")
+	}
+}
+
+func TestLokiSinkFlush(t *testing.T) {
+	var pushes atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushes.Add(1)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	sink, err := NewLokiSink(server.URL+"/loki/api/v1/push", 100, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := NewSyslogMessage()
+	msg.Parse("<13>1 2026-10-16T12:00:00Z host1 app - - - hello", "192.0.2.1:514")
+	msg.Entry = msg.Fields()
+	if err := sink.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing would be pushed for an hour, but for the flush.
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if got := pushes.Load(); got != 1 {
+		t.Errorf("Expected 1 push, got %d", got)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// FieldMapping renames, drops or sets a journal field as entries are sent,
+// after every other field has been worked out.
+type FieldMapping struct {
+	Field string
+
+	// Exactly one of these applies: Field is renamed to Rename, removed if
+	// Drop is set, or otherwise set from Template (see expand).
+	Rename   string
+	Drop     bool
+	Template string
+}
+
+// NewFieldMapping checks the names in a mapping of the given kind ("drop",
+// "rename" or "template") and returns it.
+func NewFieldMapping(kind string, field string, value string) (FieldMapping, error) {
+	mapping := FieldMapping{Field: field}
+	switch kind {
+	case "drop":
+		mapping.Drop = true
+	case "rename":
+		mapping.Rename = value
+		if err := checkMappedField(value); err != nil {
+			return mapping, err
+		}
+	case "template":
+		mapping.Template = value
+	}
+	return mapping, checkMappedField(field)
+}
+
+// checkMappedField rejects names which aren't valid journal fields, and
+// MESSAGE and PRIORITY, which are sent separately.
+func checkMappedField(name string) error {
+	if name == "" || JournalFieldName("", name) != name {
+		return fmt.Errorf("bad journal field name %q", name)
+	}
+	if name == "MESSAGE" || name == "PRIORITY" {
+		return fmt.Errorf("journal field %s can't be mapped", name)
+	}
+	return nil
+}
+
+// applyFieldMappings applies mappings to an entry's fields, in order.
+func applyFieldMappings(vars map[string]string, msg *SyslogMessage, mappings []FieldMapping) {
+	for _, mapping := range mappings {
+		value, ok := vars[mapping.Field]
+		switch {
+		case mapping.Drop:
+			delete(vars, mapping.Field)
+		case mapping.Rename != "":
+			if ok {
+				delete(vars, mapping.Field)
+				vars[mapping.Rename] = value
+			}
+		default:
+			if value := msg.expand(mapping.Template, vars); value != "" {
+				vars[mapping.Field] = value
+			} else {
+				delete(vars, mapping.Field)
+			}
+		}
+	}
+}
+
+// expand replaces ${FIELD} in a template with the value of an entry's
+// field, and the lowercase ${hostname}, ${tag}, ${appname}, ${procid},
+// ${msgid} and ${source} with those parts of the message. Anything else
+// expands to nothing.
+func (msg *SyslogMessage) expand(template string, vars map[string]string) string {
+	return os.Expand(template, func(name string) string {
+		switch name {
+		case "hostname":
+			return msg.Hostname
+		case "tag":
+			return msg.Tag
+		case "appname":
+			return msg.AppName
+		case "procid":
+			return msg.ProcID
+		case "msgid":
+			return msg.MsgID
+		case "source":
+			return msg.Source
+		}
+		return vars[name]
+	})
+}
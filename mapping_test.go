@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldMappings(t *testing.T) {
+	tests := []struct {
+		settings string
+		expected map[string]string
+	}{
+		{
+			"field-template=SYSLOG_IDENTIFIER=${tag}",
+			map[string]string{"SYSLOG_IDENTIFIER": "postfix/smtpd", "SYSLOG_HOSTNAME": "host", "SYSLOG_SOURCE": "192.0.2.1:514"},
+		},
+		{
+			"field-rename=SYSLOG_SOURCE=REMOTE_ADDR,field-drop=SYSLOG_HOSTNAME",
+			map[string]string{"SYSLOG_IDENTIFIER": "host postfix/smtpd", "REMOTE_ADDR": "192.0.2.1:514"},
+		},
+		{
+			"field-template=ORIGIN=${SYSLOG_HOSTNAME} (${source}),field-template=SYSLOG_SOURCE=${NOPE}",
+			map[string]string{"SYSLOG_IDENTIFIER": "host postfix/smtpd", "SYSLOG_HOSTNAME": "host", "ORIGIN": "host (192.0.2.1:514)"},
+		},
+		{
+			"field-rename=MISSING=OTHER",
+			map[string]string{"SYSLOG_IDENTIFIER": "host postfix/smtpd", "SYSLOG_HOSTNAME": "host", "SYSLOG_SOURCE": "192.0.2.1:514"},
+		},
+	}
+
+	for num, test := range tests {
+		sockets := socketConfigs{}
+		if err := sockets.Set("x:" + test.settings); err != nil {
+			t.Errorf("Failed test %d: %s", num, err.Error())
+			continue
+		}
+		msg := NewSyslogMessage()
+		msg.Hostname, msg.Tag, msg.Source = "host", "postfix/smtpd", "192.0.2.1:514"
+		vars := map[string]string{"SYSLOG_IDENTIFIER": "host postfix/smtpd", "SYSLOG_HOSTNAME": "host", "SYSLOG_SOURCE": "192.0.2.1:514"}
+		applyFieldMappings(vars, msg, sockets.Lookup("x").FieldMappings)
+		if !reflect.DeepEqual(vars, test.expected) {
+			t.Errorf("Failed test %d: expected %v, got %v", num, test.expected, vars)
+		}
+	}
+
+	for _, bad := range []string{"x:field-drop=A=B", "x:field-rename=A", "x:field-template=MESSAGE=x", "x:field-rename=A=lower", "x:field-drop=_SYSTEMD_UNIT"} {
+		if err := (socketConfigs{}).Set(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
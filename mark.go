@@ -0,0 +1,72 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-systemd/journal"
+)
+
+// IsMark reports whether msg is one of the "-- MARK --" messages syslogd
+// -m and rsyslog's immark send periodically to show they're alive. Without
+// a tag, the first "--" is taken for one, hence Payload.
+func IsMark(msg *SyslogMessage) bool {
+	return strings.TrimSpace(msg.Payload()) == "-- MARK --"
+}
+
+// markCounts tallies the MARK messages counted from each sender since they
+// were last reported.
+var (
+	markCountsMu sync.Mutex
+	markCounts   = map[string]int{}
+)
+
+// HandleMark applies the socket's Mark setting to a MARK message from
+// source, returning the fields to send it with, or false to drop it.
+func (config *SocketConfig) HandleMark(msg *SyslogMessage, source string, extra map[string]string) (map[string]string, bool) {
+	switch config.Mark {
+	case "drop":
+		return nil, false
+	case "count":
+		markCountsMu.Lock()
+		markCounts[sourceHost(source)]++
+		markCountsMu.Unlock()
+		return nil, false
+	case "heartbeat":
+		msg.Severity = int(journal.PriDebug)
+		return underlay(map[string]string{"SYSLOG_HEARTBEAT": "1"}, extra), true
+	}
+	return extra, true
+}
+
+// ReportMarks logs the number of MARK messages counted from each sender
+// every interval, for any sender which has sent some, until stop is closed.
+func ReportMarks(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		markCountsMu.Lock()
+		sources := make([]string, 0, len(markCounts))
+		for source := range markCounts {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+		for _, source := range sources {
+			log.Printf("received %d MARK messages from %s in the last %s", markCounts[source], source, interval)
+		}
+		markCounts = map[string]int{}
+		markCountsMu.Unlock()
+	}
+}
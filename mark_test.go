@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsMark(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected bool
+	}{
+		{"<13>Dec 15 11:55:02 host1 -- MARK --", true},
+		{"<13>Dec 15 11:55:02 host1 rsyslogd: -- MARK --", true},
+		{"<13>1 2003-10-11T22:14:15.003Z host1 - - - - -- MARK --", true},
+		{"<13>Dec 15 11:55:02 host1 app: -- MARK -- and more", false},
+	}
+
+	for num, test := range tests {
+		msg := NewSyslogMessage()
+		msg.Parse(test.in, "192.0.2.1:514")
+		if got := IsMark(msg); got != test.expected {
+			t.Errorf("Failed test %d: expected %v, got %v", num, test.expected, got)
+		}
+	}
+}
+
+func TestHandleMark(t *testing.T) {
+	tests := []struct {
+		mark     string
+		keep     bool
+		severity int
+		extra    map[string]string
+	}{
+		{"", true, 5, map[string]string{"SYSLOG_TRANSPORT": "udp"}},
+		{"keep", true, 5, map[string]string{"SYSLOG_TRANSPORT": "udp"}},
+		{"drop", false, 5, nil},
+		{"count", false, 5, nil},
+		{"heartbeat", true, 7, map[string]string{"SYSLOG_TRANSPORT": "udp", "SYSLOG_HEARTBEAT": "1"}},
+	}
+
+	for num, test := range tests {
+		config := NewSocketConfig("")
+		config.Mark = test.mark
+		msg := NewSyslogMessage()
+		msg.Parse("<13>Dec 15 11:55:02 host1 -- MARK --", "192.0.2.1:514")
+		extra, keep := config.HandleMark(msg, "192.0.2.1:514", map[string]string{"SYSLOG_TRANSPORT": "udp"})
+		if keep != test.keep || msg.Severity != test.severity || !reflect.DeepEqual(extra, test.extra) {
+			t.Errorf("Failed test %d: expected %v, %d, %v, got %v, %d, %v", num, test.keep, test.severity, test.extra, keep, msg.Severity, extra)
+		}
+	}
+
+	markCountsMu.Lock()
+	defer markCountsMu.Unlock()
+	if markCounts["192.0.2.1"] != 1 {
+		t.Errorf("Expected 1 MARK counted, got %d", markCounts["192.0.2.1"])
+	}
+}
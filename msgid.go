@@ -0,0 +1,33 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+)
+
+// messageIDNamespace is the RFC4122 namespace of MESSAGE_IDs derived from
+// MSGIDs (a174ffd3-8618-48fe-a1ef-f882ab8e1af2). Changing it would change
+// every MESSAGE_ID, so it mustn't be.
+var messageIDNamespace = [16]byte{
+	0xa1, 0x74, 0xff, 0xd3, 0x86, 0x18, 0x48, 0xfe,
+	0xa1, 0xef, 0xf8, 0x82, 0xab, 0x8e, 0x1a, 0xf2,
+}
+
+// MessageID derives a journald MESSAGE_ID from an RFC5424 APP-NAME and
+// MSGID: a version 5 (name-based) UUID, as the 32 hex digits journald uses.
+// Every instance of an event gets the same ID, whichever host sends it.
+func MessageID(appName string, msgID string) string {
+	h := sha1.New()
+	h.Write(messageIDNamespace[:])
+	h.Write([]byte(appName))
+	h.Write([]byte{0})
+	h.Write([]byte(msgID))
+	sum := h.Sum(nil)[:16]
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+	return hex.EncodeToString(sum)
+}
@@ -0,0 +1,54 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// JoinMulticastGroups subscribes a UDP socket to each of the given IPv4 or
+// IPv6 multicast groups, on the named interface (or the kernel's choice, if
+// iface is empty). The socket must be bound to the wildcard address or to
+// the group itself for the group's datagrams to be delivered to it.
+func JoinMulticastGroups(fd *net.UDPConn, groups []net.IP, iface string) error {
+	ifindex := 0
+	if iface != "" {
+		ifi, err := net.InterfaceByName(iface)
+		if err != nil {
+			return err
+		}
+		ifindex = ifi.Index
+	}
+
+	raw, err := fd.SyscallConn()
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		var joinErr error
+		err := raw.Control(func(s uintptr) {
+			if ip4 := group.To4(); ip4 != nil {
+				mreq := &unix.IPMreqn{Ifindex: int32(ifindex)}
+				copy(mreq.Multiaddr[:], ip4)
+				joinErr = unix.SetsockoptIPMreqn(int(s), unix.IPPROTO_IP, unix.IP_ADD_MEMBERSHIP, mreq)
+			} else {
+				mreq := &unix.IPv6Mreq{Interface: uint32(ifindex)}
+				copy(mreq.Multiaddr[:], group.To16())
+				joinErr = unix.SetsockoptIPv6Mreq(int(s), unix.IPPROTO_IPV6, unix.IPV6_JOIN_GROUP, mreq)
+			}
+		})
+		if err == nil {
+			err = os.NewSyscallError("setsockopt", joinErr)
+		}
+		if err != nil {
+			return fmt.Errorf("joining multicast group %s on %s: %s", group, fd.LocalAddr(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+// Continuation lines are no longer joined onto an entry once it's this big.
+const maxMultilineSize = 65536
+
+// MultilineAggregator joins continuation lines (see isContinuation), such as
+// those of Java stack traces and Python tracebacks sent one line per message,
+// onto the message before them from the same sender and program. Each entry
+// is held back until no continuation has arrived for the window: Run sends
+// those whose windows have passed, and Flush the lot.
+type MultilineAggregator struct {
+	window time.Duration
+	send   func(*SyslogMessage, map[string]string)
+	clock  clockwork.Clock
+
+	mu      sync.Mutex
+	pending map[string]*multilineEntry
+}
+
+type multilineEntry struct {
+	msg      *SyslogMessage
+	extra    map[string]string
+	deadline time.Time
+}
+
+func NewMultilineAggregator(window time.Duration, send func(*SyslogMessage, map[string]string)) *MultilineAggregator {
+	return &MultilineAggregator{
+		window:  window,
+		send:    send,
+		clock:   clockwork.NewRealClock(),
+		pending: map[string]*multilineEntry{},
+	}
+}
+
+var (
+	multilineAggregatorsMu sync.Mutex
+	multilineAggregators   = map[*SocketConfig]*MultilineAggregator{}
+)
+
+// multilineFor returns the MultilineAggregator for a socket with Multiline
+// set, which sends its entries on, whole, to have repeats suppressed. It
+// runs until draining starts, and is flushed once it's done.
+func multilineFor(config *SocketConfig) *MultilineAggregator {
+	multilineAggregatorsMu.Lock()
+	defer multilineAggregatorsMu.Unlock()
+	if aggregator, ok := multilineAggregators[config]; ok {
+		return aggregator
+	}
+	aggregator := NewMultilineAggregator(config.Multiline, func(msg *SyslogMessage, extra map[string]string) {
+		suppressRepeats(config, msg, extra)
+	})
+	multilineAggregators[config] = aggregator
+	go aggregator.Run(drainer.Stopping())
+	drainer.OnDrain(drainMultiline, aggregator.Flush)
+	return aggregator
+}
+
+// isContinuation reports whether a message continues the one before it:
+// whether it's indented, like the frames of a stack trace, or starts a Java
+// exception's cause.
+func isContinuation(message string) bool {
+	return strings.HasPrefix(message, " ") || strings.HasPrefix(message, "\t") ||
+		strings.HasPrefix(message, "Caused by: ")
+}
+
+// Add joins msg onto the pending entry from the same sender and program if
+// it's a continuation, and otherwise sends that entry and holds msg back in
+// its place.
+func (a *MultilineAggregator) Add(msg *SyslogMessage, extra map[string]string) {
+	key := strings.Join([]string{msg.Source, msg.Hostname, msg.Tag, msg.AppName, msg.ProcID}, "\x00")
+
+	now := a.clock.Now()
+	a.mu.Lock()
+	previous, ok := a.pending[key]
+	if ok && isContinuation(msg.Message) && len(previous.msg.Message)+len(msg.Message) < maxMultilineSize {
+		previous.msg.Message += "\n" + msg.Message
+		previous.deadline = now.Add(a.window)
+		a.mu.Unlock()
+		return
+	}
+	a.pending[key] = &multilineEntry{msg, extra, now.Add(a.window)}
+	a.mu.Unlock()
+
+	if ok {
+		a.send(previous.msg, previous.extra)
+	}
+}
+
+// Sweep sends the entries no continuation has arrived for in the window.
+func (a *MultilineAggregator) Sweep() {
+	a.sweep(false)
+}
+
+// Flush sends every entry held back.
+func (a *MultilineAggregator) Flush() {
+	a.sweep(true)
+}
+
+func (a *MultilineAggregator) sweep(all bool) {
+	now := a.clock.Now()
+	var due []*multilineEntry
+	a.mu.Lock()
+	for key, entry := range a.pending {
+		if all || !now.Before(entry.deadline) {
+			due = append(due, entry)
+			delete(a.pending, key)
+		}
+	}
+	a.mu.Unlock()
+	for _, entry := range due {
+		a.send(entry.msg, entry.extra)
+	}
+}
+
+// Run sweeps the entries a few times a window, until stop is closed.
+func (a *MultilineAggregator) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-a.clock.After(a.window / 4):
+			a.Sweep()
+		}
+	}
+}
+
+// serialQueues run functions one after another for each key, in the order
+// they were queued, and those for different keys concurrently.
+type serialQueues struct {
+	mu     sync.Mutex
+	queues map[string][]func()
+}
+
+// orderedIngest ingests the datagrams from each sender in the order they
+// arrived, for sockets which join or count consecutive messages.
+var orderedIngest = &serialQueues{queues: map[string][]func(){}}
+
+// Go queues f to run after the functions already queued for key, starting a
+// goroutine (which draining waits for) to run them if there isn't one.
+func (q *serialQueues) Go(key string, f func()) {
+	q.mu.Lock()
+	queue, running := q.queues[key]
+	q.queues[key] = append(queue, f)
+	q.mu.Unlock()
+	if running {
+		return
+	}
+	drainer.Go(func() {
+		for {
+			q.mu.Lock()
+			queue := q.queues[key]
+			if len(queue) == 0 {
+				delete(q.queues, key)
+				q.mu.Unlock()
+				return
+			}
+			f := queue[0]
+			q.queues[key] = queue[1:]
+			q.mu.Unlock()
+			runRecovered("goroutine", f)
+		}
+	})
+}
+
+// ingestPacket ingests a datagram in a goroutine of its own, or for sockets
+// with Multiline or Repeat set, after the ones before it from the same
+// sender, so that continuations and repeats are seen in order.
+func ingestPacket(config *SocketConfig, packet string, source string, extra map[string]string) {
+	ingest := func() { ingestMessage(config, packet, source, extra) }
+	if config.Multiline > 0 || config.Repeat > 0 {
+		orderedIngest.Go(source, ingest)
+		return
+	}
+	drainer.Go(ingest)
+}
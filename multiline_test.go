@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+func TestMultilineAggregator(t *testing.T) {
+	var sent []string
+	aggregator := NewMultilineAggregator(50*time.Millisecond, func(msg *SyslogMessage, extra map[string]string) {
+		sent = append(sent, msg.Hostname+": "+msg.Message)
+	})
+	clock := clockwork.NewFakeClock()
+	aggregator.clock = clock
+	add := func(lines ...string) {
+		for _, line := range lines {
+			msg := NewSyslogMessage()
+			msg.Parse(line, "192.0.2.1:514")
+			aggregator.Add(msg, nil)
+		}
+	}
+
+	add(
+		"<13>Dec 15 11:55:02 host1 java[1]: java.lang.IllegalStateException: oops",
+		"<13>Dec 15 11:55:02 host2 java[1]: unrelated",
+		"<13>Dec 15 11:55:02 host1 java[1]: \tat Main.main(Main.java:5)",
+		"<13>Dec 15 11:55:02 host1 java[1]: Caused by: java.io.IOException: gone",
+		"<13>Dec 15 11:55:02 host1 java[1]: \t... 1 more",
+		"<13>Dec 15 11:55:02 host1 java[1]: next",
+		"<13>Dec 15 11:55:02 host1 cron[2]:   indented, but from another program",
+	)
+
+	// The first entry is sent as soon as the next starts; the rest once
+	// the window has passed.
+	expected := []string{
+		"host1: java.lang.IllegalStateException: oops\n\tat Main.main(Main.java:5)\nCaused by: java.io.IOException: gone\n\t... 1 more",
+	}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected %q, got %q", expected, sent)
+	}
+	clock.Advance(49 * time.Millisecond)
+	aggregator.Sweep()
+	if len(sent) != 1 {
+		t.Errorf("Expected nothing more before the window passed, got %q", sent[1:])
+	}
+	clock.Advance(time.Millisecond)
+	aggregator.Sweep()
+	rest := append([]string(nil), sent[1:]...)
+	sort.Strings(rest)
+	if expected := []string{"host1:   indented, but from another program", "host1: next", "host2: unrelated"}; !reflect.DeepEqual(rest, expected) {
+		t.Errorf("Expected %q, got %q", expected, rest)
+	}
+
+	// Flushing sends what's held without waiting.
+	sent = nil
+	add(
+		"<13>Dec 15 11:55:03 host1 java[1]: java.lang.NullPointerException",
+		"<13>Dec 15 11:55:03 host1 java[1]: \tat Main.main(Main.java:7)",
+	)
+	aggregator.Flush()
+	if expected := []string{"host1: java.lang.NullPointerException\n\tat Main.main(Main.java:7)"}; !reflect.DeepEqual(sent, expected) || len(aggregator.pending) != 0 {
+		t.Errorf("Expected %q and nothing held, got %q and %v", expected, sent, aggregator.pending)
+	}
+}
+
+func TestSerialQueues(t *testing.T) {
+	q := &serialQueues{queues: map[string][]func(){}}
+	results := make(chan int, 100)
+	for i := 0; i < 100; i++ {
+		i := i
+		q.Go("192.0.2.1:514", func() {
+			if i%10 == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			results <- i
+		})
+	}
+	for i := 0; i < 100; i++ {
+		select {
+		case got := <-results:
+			if got != i {
+				t.Fatalf("Expected %d, got %d", i, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out")
+		}
+	}
+}
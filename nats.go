@@ -0,0 +1,129 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// natsFlushTimeout is how long Flush and Close wait for the server to
+	// have what's been published.
+	natsFlushTimeout = 5 * time.Second
+
+	// With JetStream, up to relayQueueSize messages may be waiting to be
+	// acknowledged; beyond that, a publish waits this long for room before
+	// the message is dropped.
+	natsStallWait = 50 * time.Millisecond
+)
+
+// natsConn is the part of a NATS connection the output uses, and natsStream
+// the part of its JetStream context, so tests can stand in their own.
+type natsConn interface {
+	Publish(subject string, data []byte) error
+	FlushTimeout(timeout time.Duration) error
+	Close()
+}
+
+type natsStream interface {
+	PublishAsync(subject string, data []byte, opts ...nats.PubOpt) (nats.PubAckFuture, error)
+	PublishAsyncPending() int
+	PublishAsyncComplete() <-chan struct{}
+}
+
+// NATSOutput publishes entries, as JSON objects of their journal fields, to
+// NATS subjects named for their facility and severity under a prefix, as
+// "syslog.local4.warning". The client buffers them while reconnecting, so a
+// server that's down doesn't hold up anything else. With JetStream, a stream
+// taking the subjects keeps them, and publishes are acknowledged; otherwise
+// only subscribers listening at the time get them.
+type NATSOutput struct {
+	conn    natsConn
+	js      natsStream
+	subject string
+}
+
+func init() {
+	RegisterSink("nats", func() (Sink, error) {
+		if *natsURL == "" {
+			return nil, nil
+		}
+		return NewNATSOutput(*natsURL, *natsSubject, *natsJetStream)
+	})
+}
+
+// NewNATSOutput returns a NATSOutput to the servers given (a comma-separated
+// list of nats:// or tls:// URLs), publishing under subject, with JetStream
+// if jetStream is set. Servers which can't be reached yet are tried again in
+// the background.
+func NewNATSOutput(servers string, subject string, jetStream bool) (*NATSOutput, error) {
+	if subject == "" {
+		return nil, fmt.Errorf("need a NATS subject")
+	}
+	conn, err := nats.Connect(servers,
+		nats.Name("journald-syslog"),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			log.Printf("NATS: %s", err)
+		}))
+	if err != nil {
+		return nil, err
+	}
+	o := &NATSOutput{conn: conn, subject: subject}
+	if jetStream {
+		js, err := conn.JetStream(
+			nats.PublishAsyncMaxPending(relayQueueSize),
+			nats.PublishAsyncErrHandler(func(_ nats.JetStream, msg *nats.Msg, err error) {
+				log.Printf("JetStream didn't take a message for %s: %s", msg.Subject, err)
+			}))
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		o.js = js
+	}
+	return o, nil
+}
+
+// Write publishes a message, to be sent in the background. With JetStream,
+// it fails if too many messages are waiting to be acknowledged.
+func (o *NATSOutput) Write(msg *SyslogMessage) error {
+	data, err := entryJSON(msg.Message, int(msg.Priority()), msg.Entry)
+	if err != nil {
+		return err
+	}
+	subject := o.subject + "." + routingKey(msg)
+	if o.js != nil {
+		_, err = o.js.PublishAsync(subject, data, nats.StallWait(natsStallWait))
+		return err
+	}
+	return o.conn.Publish(subject, data)
+}
+
+// Flush waits for the server to have (or with JetStream, to acknowledge)
+// what's been published.
+func (o *NATSOutput) Flush() error {
+	if o.js != nil {
+		select {
+		case <-o.js.PublishAsyncComplete():
+			return nil
+		case <-time.After(natsFlushTimeout):
+			return fmt.Errorf("gave up waiting for JetStream to acknowledge %d messages", o.js.PublishAsyncPending())
+		}
+	}
+	return o.conn.FlushTimeout(natsFlushTimeout)
+}
+
+// Close flushes the output and disconnects.
+func (o *NATSOutput) Close() error {
+	err := o.Flush()
+	o.conn.Close()
+	return err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// fakeNATS records what's published, plainly or with JetStream, and can be
+// made to refuse JetStream publishes, as when too many are waiting to be
+// acknowledged.
+type fakeNATS struct {
+	published []string
+	stalled   bool
+	pending   chan struct{}
+	closed    bool
+}
+
+func (f *fakeNATS) Publish(subject string, data []byte) error {
+	f.published = append(f.published, subject+" "+strings.TrimSpace(string(data)))
+	return nil
+}
+
+func (f *fakeNATS) FlushTimeout(timeout time.Duration) error {
+	return nil
+}
+
+func (f *fakeNATS) Close() {
+	f.closed = true
+}
+
+func (f *fakeNATS) PublishAsync(subject string, data []byte, opts ...nats.PubOpt) (nats.PubAckFuture, error) {
+	if f.stalled {
+		return nil, errors.New("nats: stalled with too many outstanding async published messages")
+	}
+	f.published = append(f.published, "js "+subject+" "+strings.TrimSpace(string(data)))
+	return nil, nil
+}
+
+func (f *fakeNATS) PublishAsyncPending() int {
+	return 1
+}
+
+func (f *fakeNATS) PublishAsyncComplete() <-chan struct{} {
+	return f.pending
+}
+
+func TestNATSOutputWrite(t *testing.T) {
+	msg := NewSyslogMessage()
+	msg.Parse("<34>1 2026-10-16T12:00:00Z host1 su - - - hello", "192.0.2.1:514")
+	msg.Entry = map[string]string{}
+
+	conn := &fakeNATS{}
+	o := &NATSOutput{conn: conn, subject: "syslog"}
+	if err := o.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{`syslog.auth.crit {"MESSAGE":"hello","PRIORITY":"2"}`}
+	if !reflect.DeepEqual(conn.published, expected) || !conn.closed {
+		t.Errorf("Expected %q and the connection closed, got %q", expected, conn.published)
+	}
+
+	// With JetStream, a publish that would stall fails rather than waiting.
+	conn = &fakeNATS{pending: make(chan struct{})}
+	o = &NATSOutput{conn: conn, js: conn, subject: "syslog"}
+	if err := o.Write(msg); err != nil {
+		t.Fatal(err)
+	}
+	conn.stalled = true
+	if err := o.Write(msg); err == nil {
+		t.Errorf("Expected an error for a stalled publish")
+	}
+	expected = []string{`js syslog.auth.crit {"MESSAGE":"hello","PRIORITY":"2"}`}
+	if !reflect.DeepEqual(conn.published, expected) {
+		t.Errorf("Expected %q, got %q", expected, conn.published)
+	}
+	close(conn.pending)
+	if err := o.Flush(); err != nil {
+		t.Errorf("Expected the flush to finish once acknowledged, got %s", err)
+	}
+}
+
+func TestNewNATSOutputErrors(t *testing.T) {
+	if _, err := NewNATSOutput("nats://nats:4222", "", false); err == nil {
+		t.Errorf("Expected an error without a subject")
+	}
+}
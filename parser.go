@@ -0,0 +1,80 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Parser is a syslog dialect beyond the built-in ones, compiled in with
+// RegisterParser. A socket's format names it like any other, e.g.
+// format=mydevice/rfc3164.
+type Parser interface {
+	// Name is the parser's name in formats.
+	Name() string
+
+	// Detect reports whether buf looks like a message in the dialect, to
+	// be passed to Parse.
+	Detect(buf []byte) bool
+
+	// Parse parses a message received from source. The message returned
+	// should come from NewSyslogMessage; what it parsed (its header
+	// fields, Message, Extra and the Original ones) replaces that of the
+	// one being parsed, while the socket's settings, such as Location,
+	// are kept.
+	Parse(buf []byte, source string) (*SyslogMessage, error)
+}
+
+var (
+	parsersMu sync.RWMutex
+	parsers   = map[string]Parser{}
+)
+
+// builtinFormats are the formats ParseFormat handles itself.
+var builtinFormats = map[string]bool{"rfc5424": true, "rfc3164": true, "cisco": true, "raw": true}
+
+// RegisterParser makes a Parser available to formats, typically from an
+// init function. It panics if the name is taken, as database/sql's Register
+// does.
+func RegisterParser(parser Parser) {
+	parsersMu.Lock()
+	defer parsersMu.Unlock()
+	name := parser.Name()
+	if _, ok := parsers[name]; ok || builtinFormats[name] {
+		panic(fmt.Sprintf("RegisterParser called twice for parser %q", name))
+	}
+	parsers[name] = parser
+}
+
+// LookupParser returns the registered Parser with the given name, or nil.
+func LookupParser(name string) Parser {
+	parsersMu.RLock()
+	defer parsersMu.RUnlock()
+	return parsers[name]
+}
+
+// parseWith parses buf with a registered parser, taking what it parsed into
+// msg if it detects its dialect. It returns the MSG, as the built-in parsers
+// do.
+func (msg *SyslogMessage) parseWith(parser Parser, buf string) (string, bool, error) {
+	if !parser.Detect([]byte(buf)) {
+		return "", false, nil
+	}
+	parsed, err := parser.Parse([]byte(buf), msg.Source)
+	if err != nil {
+		return "", false, err
+	}
+	msg.Version = parsed.Version
+	msg.Facility, msg.Severity = parsed.Facility, parsed.Severity
+	msg.Timestamp = parsed.Timestamp
+	msg.Hostname, msg.Tag = parsed.Hostname, parsed.Tag
+	msg.AppName, msg.ProcID, msg.MsgID = parsed.AppName, parsed.ProcID, parsed.MsgID
+	msg.StructuredData = parsed.StructuredData
+	msg.MessageCharset = parsed.MessageCharset
+	msg.Extra = parsed.Extra
+	msg.OriginalTimestamp, msg.OriginalPRI = parsed.OriginalTimestamp, parsed.OriginalPRI
+	return parsed.Message, true, nil
+}
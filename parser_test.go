@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipeParser parses messages like "PIPE|host|app|text".
+type pipeParser struct{}
+
+func (pipeParser) Name() string { return "pipe" }
+
+func (pipeParser) Detect(buf []byte) bool {
+	return strings.HasPrefix(string(buf), "PIPE|")
+}
+
+func (pipeParser) Parse(buf []byte, source string) (*SyslogMessage, error) {
+	parts := strings.SplitN(string(buf), "|", 4)
+	if len(parts) != 4 {
+		return nil, errors.New("too few fields")
+	}
+	msg := NewSyslogMessage()
+	msg.Hostname, msg.AppName, msg.Message = parts[1], parts[2], parts[3]
+	return msg, nil
+}
+
+func init() {
+	RegisterParser(pipeParser{})
+}
+
+func TestRegisteredParser(t *testing.T) {
+	tests := []struct {
+		in       string
+		hostname string
+		appName  string
+		message  string
+		err      bool
+	}{
+		{"PIPE|host1|app|hello", "host1", "app", "hello", false},
+		{"<13>Dec 15 11:55:02 host2 sshd: hi", "host2", "sshd", "hi", false},
+		{"PIPE|short", "", "", "PIPE|short", true},
+	}
+
+	chicago := time.FixedZone("CST", -6*60*60)
+	for num, test := range tests {
+		msg := NewSyslogMessage()
+		msg.Location, msg.SDFields = chicago, "json"
+		err := msg.ParseFormat(test.in, "192.0.2.1:514", "pipe/rfc3164")
+		if (err != nil) != test.err || msg.Hostname != test.hostname || msg.AppName != test.appName || msg.Message != test.message {
+			t.Errorf("Failed test %d: got %q %q %q, %v", num, msg.Hostname, msg.AppName, msg.Message, err)
+		}
+		if msg.Source != "192.0.2.1:514" || msg.Location != chicago || msg.SDFields != "json" {
+			t.Errorf("Failed test %d: lost the source or settings, got %q %v %q", num, msg.Source, msg.Location, msg.SDFields)
+		}
+	}
+
+	config := NewSocketConfig("")
+	if err := config.Set("format=pipe"); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+	if err := ValidateFormat("PIPE|host1|app|hello", "pipe"); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+	if err := ValidateFormat("<13>Dec 15 11:55:02 host2 sshd: hi", "pipe"); err == nil {
+		t.Errorf("Expected an error validating RFC3164 as pipe")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected registering pipe twice to panic")
+		}
+	}()
+	RegisterParser(pipeParser{})
+}
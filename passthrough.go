@@ -0,0 +1,54 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"net"
+	"sort"
+)
+
+// PassthroughFields returns the parameters of the SD element id as journal
+// fields named as they are, so that a sender can set fields of its own
+// ([journald@32473 TRACE_ID="4bf92f35"] becomes TRACE_ID=4bf92f35).
+// Parameters which aren't valid journal field names, or are MESSAGE or
+// PRIORITY, are left out; they still appear among the SYSLOG_SD_ fields.
+func (sd StructuredData) PassthroughFields(id string) map[string]string {
+	params, ok := sd[id]
+	if !ok {
+		return nil
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := map[string]string{}
+	for _, name := range names {
+		if checkMappedField(name) == nil {
+			fields[name] = params[name]
+		}
+	}
+	return fields
+}
+
+// TrustsFields reports whether source may set journal fields with the
+// -passthrough-sd-id element: whether the socket trusts every sender, or
+// source's address is in one of its TrustedNetworks.
+func (config *SocketConfig) TrustsFields(source string) bool {
+	if config.TrustAll {
+		return true
+	}
+	if len(config.TrustedNetworks) > 0 {
+		if ip := net.ParseIP(sourceHost(source)); ip != nil {
+			for _, network := range config.TrustedNetworks {
+				if network.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
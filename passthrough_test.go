@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPassthroughFields(t *testing.T) {
+	tests := []struct {
+		sd       string
+		expected map[string]string
+	}{
+		{`-`, nil},
+		{`[origin ip="192.0.2.1"]`, nil},
+		{`[journald@32473 TRACE_ID="4bf92f35" DEPLOY="v42"]`, map[string]string{"TRACE_ID": "4bf92f35", "DEPLOY": "v42"}},
+		{`[journald@32473 trace="x" _PID="1" MESSAGE="spoofed" PRIORITY="0" OK="1"][origin ip="192.0.2.1"]`, map[string]string{"OK": "1"}},
+	}
+
+	for num, test := range tests {
+		sd, _, err := ParseStructuredData(test.sd + " msg")
+		if err != nil {
+			t.Errorf("Failed test %d: %s", num, err)
+			continue
+		}
+		if got := sd.PassthroughFields("journald@32473"); !reflect.DeepEqual(got, test.expected) && len(got)+len(test.expected) > 0 {
+			t.Errorf("Failed test %d: expected %v, got %v", num, test.expected, got)
+		}
+	}
+}
+
+func TestTrustsFields(t *testing.T) {
+	tests := []struct {
+		settings string
+		source   string
+		expected bool
+	}{
+		{"format=rfc5424", "192.0.2.1:514", false},
+		{"trust-fields=192.0.2.0/24", "192.0.2.1:514", true},
+		{"trust-fields=192.0.2.0/24", "198.51.100.1:514", false},
+		{"trust-fields=192.0.2.0/24,trust-fields=2001:db8::/32", "[2001:db8::1]:514", true},
+		{"trust-fields=192.0.2.0/24", "", false},
+		{"trust-fields=all", "", true},
+	}
+
+	for num, test := range tests {
+		sockets := socketConfigs{}
+		if err := sockets.Set("x:" + test.settings); err != nil {
+			t.Errorf("Failed test %d: %s", num, err.Error())
+			continue
+		}
+		if got := sockets.Lookup("x").TrustsFields(test.source); got != test.expected {
+			t.Errorf("Failed test %d: expected %v, got %v", num, test.expected, got)
+		}
+	}
+
+	if err := (socketConfigs{}).Set("x:trust-fields=192.0.2.1"); err == nil {
+		t.Errorf("Expected an error for a trust-fields address without a prefix length")
+	}
+}
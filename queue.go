@@ -0,0 +1,139 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/coreos/go-systemd/journal"
+)
+
+// errDrainTimeout is why entries still queued when draining times out go to
+// the fallback.
+var errDrainTimeout = errors.New("timed out draining before journald took them")
+
+// journalEntry is an entry ready to be sent to journald.
+type journalEntry struct {
+	namespace string
+	message   string
+	priority  journal.Priority
+	vars      map[string]string
+	// msg is the message the entry came from, if it's at hand (it's not
+	// for entries from the spool).
+	msg *SyslogMessage
+}
+
+// write sends the entry to journald, or if that fails, to the spool or the
+// fallback (or failing those, logs why it was lost). A -failover chain
+// replaces that sequence.
+func (e *journalEntry) write() {
+	if failover != nil {
+		failover.write(e)
+		return
+	}
+	// Once entries are being spooled, later ones join them, to be replayed
+	// in order.
+	if spool != nil && spool.Pending() && spool.Add(e) == nil {
+		return
+	}
+	err := SendJournal(e.namespace, e.message, e.priority, e.vars)
+	if err != nil && spool != nil && spool.Add(e) == nil {
+		return
+	}
+	switch {
+	case fallback == nil:
+		if err != nil {
+			journalDrops.Add(1)
+			if e.msg != nil {
+				sourceVolumes.Drop(e.msg.Source)
+			}
+			log.Println(err)
+		}
+	case err != nil:
+		fallback.Write(e, err)
+	default:
+		fallback.Recovered()
+	}
+}
+
+// JournalQueue hands entries to a pool of workers which send them to
+// journald, so that ingestion isn't held up waiting on journald's socket
+// for each one in turn. journald's native protocol takes one entry per
+// datagram, so the workers can only overlap those writes, not combine them.
+// Add blocks while the queue is full, holding back readers rather than
+// losing entries.
+type JournalQueue struct {
+	entries chan *journalEntry
+	workers sync.WaitGroup
+}
+
+// NewJournalQueue starts workers sending entries, from a queue of up to size.
+func NewJournalQueue(size int, workers int) *JournalQueue {
+	q := &JournalQueue{entries: make(chan *journalEntry, size)}
+	q.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer q.workers.Done()
+			for entry := range q.entries {
+				entry.write()
+			}
+		}()
+	}
+	return q
+}
+
+// Add queues an entry to be sent. With a spool, entries go there rather
+// than waiting for room in the queue.
+func (q *JournalQueue) Add(entry *journalEntry) {
+	if spool != nil {
+		select {
+		case q.entries <- entry:
+			return
+		default:
+			if spool.Add(entry) == nil {
+				return
+			}
+		}
+	}
+	q.entries <- entry
+}
+
+// Close sends the entries still queued, and stops the workers. Nothing may
+// be added afterwards.
+func (q *JournalQueue) Close() {
+	close(q.entries)
+	q.workers.Wait()
+}
+
+// Evacuate takes the entries still queued, as when draining has timed out
+// and there's no more waiting for journald, and saves them to the spool, or
+// failing that the fallback. It returns how many were saved, and how many
+// were lost for want of either. Entries may still be being added, and sent
+// by the workers, meanwhile.
+func (q *JournalQueue) Evacuate() (saved int, lost int) {
+	for {
+		select {
+		case entry := <-q.entries:
+			switch {
+			case spool != nil && spool.Add(entry) == nil:
+				saved++
+			case fallback != nil:
+				fallback.Write(entry, errDrainTimeout)
+				saved++
+			default:
+				journalDrops.Add(1)
+				lost++
+			}
+		default:
+			return saved, lost
+		}
+	}
+}
+
+// journalQueue, if set by -journal-workers, sends entries in the background;
+// otherwise SendMessage sends them itself.
+var journalQueue *JournalQueue
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-systemd/journal"
+)
+
+func TestJournalQueue(t *testing.T) {
+	dir := t.TempDir()
+	defer func(saved func(string) string) { journalSocket = saved }(journalSocket)
+	journalSocket = func(namespace string) string { return filepath.Join(dir, namespace) }
+
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "queued"), Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const count = 100
+	received := make(chan string, count)
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			received <- string(buf[:n])
+		}
+	}()
+
+	q := NewJournalQueue(8, 4)
+	for i := 0; i < count; i++ {
+		q.Add(&journalEntry{"queued", strconv.Itoa(i), journal.PriInfo, nil, nil})
+	}
+	q.Close()
+
+	var got []string
+	for len(got) < count {
+		got = append(got, <-received)
+	}
+	sort.Strings(got)
+	expected := make([]string, count)
+	for i := range expected {
+		expected[i] = "PRIORITY=6\nMESSAGE=" + strconv.Itoa(i) + "\n"
+	}
+	sort.Strings(expected)
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("Expected %q, got %q", expected[i], got[i])
+		}
+	}
+}
+
+func TestJournalQueueEvacuate(t *testing.T) {
+	defer func(saved *Spool) { spool = saved }(spool)
+	spool = nil
+	var out bytes.Buffer
+	defer func(saved *Fallback) { fallback = saved }(fallback)
+	fallback = &Fallback{w: &out}
+
+	// With no workers, as when journald's stuck, entries stay queued until
+	// they're evacuated to the fallback.
+	q := NewJournalQueue(8, 0)
+	for i := 0; i < 3; i++ {
+		q.Add(&journalEntry{"", strconv.Itoa(i), journal.PriInfo, nil, nil})
+	}
+	if saved, lost := q.Evacuate(); saved != 3 || lost != 0 {
+		t.Errorf("Expected 3 entries saved, got %d saved and %d lost", saved, lost)
+	}
+	if got := strings.Count(out.String(), "\n"); got != 3 {
+		t.Errorf("Expected 3 lines in the fallback, got %q", out.String())
+	}
+
+	fallback = nil
+	q.Add(&journalEntry{"", "3", journal.PriInfo, nil, nil})
+	if saved, lost := q.Evacuate(); saved != 0 || lost != 1 {
+		t.Errorf("Expected 1 entry lost, got %d saved and %d lost", saved, lost)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+
+	"github.com/quic-go/quic-go"
+)
+
+// The ALPN protocol clients must ask for to deliver syslog over QUIC.
+const quicALPN = "syslog"
+
+// QUICTLSConfig adapts the TLS listener configuration for QUIC, which needs
+// TLS 1.3 and an agreed ALPN protocol. Certificates are still picked up from
+// base for each handshake, so reloading keeps working.
+func QUICTLSConfig(base *tls.Config) *tls.Config {
+	adapt := func(config *tls.Config) *tls.Config {
+		config = config.Clone()
+		config.MinVersion = tls.VersionTLS13
+		config.NextProtos = []string{quicALPN}
+		return config
+	}
+	config := adapt(base)
+	if base.GetConfigForClient != nil {
+		config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			current, err := base.GetConfigForClient(hello)
+			if err != nil || current == nil {
+				return current, err
+			}
+			return adapt(current), nil
+		}
+	}
+	return config
+}
+
+// ListenQUIC starts a QUIC listener on a UDP socket, either one passed in by
+// systemd or one bound with -listen-quic.
+func ListenQUIC(fd net.PacketConn, tlsConfig *tls.Config) (*quic.Listener, error) {
+	transport := &quic.Transport{Conn: fd}
+	return transport.Listen(QUICTLSConfig(tlsConfig), &quic.Config{
+		MaxIdleTimeout: *idleTimeout,
+	})
+}
+
+// HandleQUICListener takes a QUIC listener and repeatedly accepts new
+// connections from it, serving each one in its own goroutine. It returns when
+// the listener is closed.
+func HandleQUICListener(fd *quic.Listener, config *SocketConfig) {
+	for {
+		conn, err := fd.Accept(context.Background())
+		if err != nil {
+			if !errors.Is(err, quic.ErrServerClosed) {
+				log.Println(err)
+			}
+			return
+		}
+		drainer.Go(func() { HandleQUICConn(conn, config) })
+	}
+}
+
+// HandleQUICConn reads syslog messages from every stream the client opens on
+// a QUIC connection, framed just as they would be on a TCP connection. The
+// client may open as many streams as it likes (one per application, say), so
+// that a lost packet only holds up the messages on its own stream. It returns
+// once the connection is closed.
+func HandleQUICConn(conn quic.Connection, config *SocketConfig) {
+	source := conn.RemoteAddr().String()
+	extra := transportFields("quic", identityFields(conn.ConnectionState().TLS, source))
+
+	serveStream := func(stream quic.ReceiveStream) {
+		err := ReadStreamLimit(stream, source, config.StreamSize(), func(buf string, source string, truncated bool) {
+			if truncated {
+				ingestMessage(config, buf, source, markTruncated(extra))
+				return
+			}
+			ingestMessage(config, buf, source, extra)
+		})
+		if err != nil {
+			log.Printf("QUIC stream from %s: %s", source, err)
+			stream.CancelRead(0)
+		}
+	}
+
+	// Clients may use either unidirectional streams or bidirectional ones
+	// (which we never write to).
+	drainer.Go(func() {
+		for {
+			stream, err := conn.AcceptUniStream(conn.Context())
+			if err != nil {
+				return
+			}
+			drainer.Go(func() { serveStream(stream) })
+		}
+	})
+	for {
+		stream, err := conn.AcceptStream(conn.Context())
+		if err != nil {
+			return
+		}
+		drainer.Go(func() {
+			defer stream.Close()
+			serveStream(stream)
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"crypto/tls"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestQUICTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeTestCert(t, dir, "old")
+	reloadable, err := NewReloadableTLSConfig(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), "", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	config := QUICTLSConfig(reloadable.Config())
+
+	writeTestCert(t, dir, "new")
+	if err := reloadable.Reload(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if name := servedCommonName(t, config); name != "new" {
+		t.Errorf("Expected certificate for new, got %s", name)
+	}
+
+	current, _ := config.GetConfigForClient(&tls.ClientHelloInfo{})
+	for _, c := range []*tls.Config{config, current} {
+		if !reflect.DeepEqual(c.NextProtos, []string{quicALPN}) || c.MinVersion != tls.VersionTLS13 {
+			t.Errorf("Expected ALPN %q and TLS 1.3, got %q and %x", quicALPN, c.NextProtos, c.MinVersion)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-systemd/journal"
+	"github.com/jonboulle/clockwork"
+)
+
+// RateLimiter limits the entries sent to journald for each sender, or each
+// program on each sender, to a burst per interval, as journald does for each
+// of its own clients. journald sees all of ours as one client, so without
+// this, one noisy device would get everyone's messages dropped. Once the
+// interval is up, an entry saying how many were dropped is sent in their
+// place.
+type RateLimiter struct {
+	burst    int
+	interval time.Duration
+	by       string
+	send     func(*journalEntry)
+	clock    clockwork.Clock
+
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+	start     time.Time
+	count     int
+	dropped   int
+	namespace string
+}
+
+// journalRateLimiter limits the entries sent to journald, if -rate-limit-burst
+// is set.
+var journalRateLimiter *RateLimiter
+
+// NewRateLimiter returns a RateLimiter letting through burst entries per
+// interval for each "source" (sender's address) or "identifier" (program on
+// a host), which sends its reports of dropped entries with send.
+func NewRateLimiter(burst int, interval time.Duration, by string, send func(*journalEntry)) (*RateLimiter, error) {
+	if by != "source" && by != "identifier" {
+		return nil, fmt.Errorf("bad rate limit key %q; expected source or identifier", by)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("bad rate limit interval %s", interval)
+	}
+	return &RateLimiter{
+		burst:    burst,
+		interval: interval,
+		by:       by,
+		send:     send,
+		clock:    clockwork.NewRealClock(),
+		buckets:  map[string]*rateBucket{},
+	}, nil
+}
+
+// key returns what msg is limited by: the sender's address, or its hostname
+// and program.
+func (l *RateLimiter) key(msg *SyslogMessage) string {
+	source := sourceHost(msg.Source)
+	if l.by == "source" {
+		return source
+	}
+	host := msg.Hostname
+	if host == "" {
+		host = source
+	}
+	tag := msg.AppName
+	if tag == "" {
+		tag = strings.TrimSuffix(msg.Tag, ":")
+	}
+	return strings.TrimSpace(host + " " + tag)
+}
+
+// Allow reports whether an entry for msg, going to the journal namespace
+// given, is within the limit.
+func (l *RateLimiter) Allow(msg *SyslogMessage, namespace string) bool {
+	key := l.key(msg)
+	now := l.clock.Now()
+
+	l.mu.Lock()
+	bucket, ok := l.buckets[key]
+	var report *journalEntry
+	if ok && now.Sub(bucket.start) >= l.interval {
+		report = bucket.report(key)
+		ok = false
+	}
+	if !ok {
+		bucket = &rateBucket{start: now, namespace: namespace}
+		l.buckets[key] = bucket
+	}
+	allowed := bucket.count < l.burst
+	if allowed {
+		bucket.count++
+	} else {
+		bucket.dropped++
+	}
+	l.mu.Unlock()
+
+	if report != nil {
+		l.send(report)
+	}
+	return allowed
+}
+
+// report returns the entry reporting a bucket's dropped entries, or nil if
+// there weren't any.
+func (bucket *rateBucket) report(key string) *journalEntry {
+	if bucket.dropped == 0 {
+		return nil
+	}
+	return &journalEntry{
+		bucket.namespace,
+		fmt.Sprintf("dropped %d messages from %s over the rate limit", bucket.dropped, key),
+		journal.PriWarning,
+		map[string]string{
+			"SYSLOG_IDENTIFIER":       "journald-syslog",
+			"SYSLOG_RATE_LIMITED":     key,
+			"SYSLOG_DROPPED_MESSAGES": strconv.Itoa(bucket.dropped),
+		},
+		nil,
+	}
+}
+
+// Run reports the entries dropped in each interval which has ended, and
+// forgets its bucket, every interval; otherwise, a sender that went quiet
+// after being limited would never have its drops reported.
+func (l *RateLimiter) Run() {
+	for {
+		<-l.clock.After(l.interval)
+		now := l.clock.Now()
+		var reports []*journalEntry
+		l.mu.Lock()
+		for key, bucket := range l.buckets {
+			if now.Sub(bucket.start) < l.interval {
+				continue
+			}
+			if report := bucket.report(key); report != nil {
+				reports = append(reports, report)
+			}
+			delete(l.buckets, key)
+		}
+		l.mu.Unlock()
+		for _, report := range reports {
+			l.send(report)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var reports []*journalEntry
+	limiter, err := NewRateLimiter(2, 30*time.Second, "identifier", func(entry *journalEntry) {
+		reports = append(reports, entry)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := clockwork.NewFakeClock()
+	limiter.clock = clock
+
+	message := func(line string) *SyslogMessage {
+		msg := NewSyslogMessage()
+		msg.Parse(line, "192.0.2.1:514")
+		return msg
+	}
+	noisy := message("<13>Dec 15 11:55:02 host1 sshd[1]: noise")
+	quiet := message("<13>Dec 15 11:55:02 host1 cron[2]: quiet")
+
+	var tests = []struct {
+		msg      *SyslogMessage
+		advance  time.Duration
+		expected bool
+		reports  int
+	}{
+		{noisy, 0, true, 0},
+		{noisy, time.Second, true, 0},
+		{noisy, time.Second, false, 0},
+		{noisy, time.Second, false, 0},
+		// Other programs have their own limits.
+		{quiet, 0, true, 0},
+		// Once the interval is up, the drops are reported.
+		{noisy, 30 * time.Second, true, 1},
+		{noisy, 0, true, 1},
+	}
+
+	for i, test := range tests {
+		clock.Advance(test.advance)
+		if got := limiter.Allow(test.msg, ""); got != test.expected {
+			t.Errorf("Failed test %d: Expected %v, got %v", i, test.expected, got)
+		}
+		if len(reports) != test.reports {
+			t.Errorf("Failed test %d: Expected %d reports, got %d", i, test.reports, len(reports))
+		}
+	}
+	if report := reports[0]; report.message != "dropped 2 messages from host1 sshd over the rate limit" ||
+		report.vars["SYSLOG_DROPPED_MESSAGES"] != "2" || report.vars["SYSLOG_RATE_LIMITED"] != "host1 sshd" {
+		t.Errorf("Expected a report of 2 drops from host1 sshd, got %q, %v", report.message, report.vars)
+	}
+
+	// By source, every program on a sender shares its limit.
+	limiter, _ = NewRateLimiter(1, 30*time.Second, "source", func(entry *journalEntry) {})
+	if !limiter.Allow(noisy, "") || limiter.Allow(quiet, "") {
+		t.Errorf("Expected the second message from 192.0.2.1 to be dropped")
+	}
+
+	if _, err := NewRateLimiter(1, time.Second, "hostname", nil); err == nil {
+		t.Errorf("Expected an error for an unknown rate limit key")
+	}
+}
+
+func TestRateLimiterRun(t *testing.T) {
+	reports := make(chan *journalEntry, 1)
+	limiter, _ := NewRateLimiter(1, 50*time.Millisecond, "source", func(entry *journalEntry) {
+		reports <- entry
+	})
+	go limiter.Run()
+
+	msg := NewSyslogMessage()
+	msg.Parse("<13>Dec 15 11:55:02 host1 sshd[1]: noise", "192.0.2.1:514")
+	for i := 0; i < 3; i++ {
+		limiter.Allow(msg, "ns")
+	}
+
+	// The sender went quiet, but its drops are still reported.
+	select {
+	case report := <-reports:
+		if report.namespace != "ns" || report.vars["SYSLOG_DROPPED_MESSAGES"] != "2" {
+			t.Errorf("Expected a report of 2 drops to ns, got %q, %v", report.namespace, report.vars)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a report")
+	}
+}
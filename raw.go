@@ -0,0 +1,24 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"unicode/utf8"
+)
+
+// RawFields returns the packet buf, exactly as received but cut short at max
+// bytes, as SYSLOG_RAW; or, if it isn't printable UTF-8 text, base64-encoded
+// as SYSLOG_RAW_BASE64.
+func RawFields(buf string, max int) map[string]string {
+	if len(buf) > max {
+		buf = buf[:max]
+	}
+	if utf8.ValidString(buf) && strings.IndexFunc(buf, isControl) < 0 {
+		return map[string]string{"SYSLOG_RAW": buf}
+	}
+	return map[string]string{"SYSLOG_RAW_BASE64": base64.StdEncoding.EncodeToString([]byte(buf))}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRawFields(t *testing.T) {
+	var tests = []struct {
+		buf      string
+		max      int
+		expected map[string]string
+	}{
+		{"<13>1 - - a\n", 1024, map[string]string{"SYSLOG_RAW": "<13>1 - - a\n"}},
+		{"<13>1 - - a\x00", 1024, map[string]string{"SYSLOG_RAW_BASE64": "PDEzPjEgLSAtIGEA"}},
+		{"<13>1 - - a\tb", 1024, map[string]string{"SYSLOG_RAW": "<13>1 - - a\tb"}},
+		{"<13>1 - - abcdef", 11, map[string]string{"SYSLOG_RAW": "<13>1 - - a"}},
+		{"<13>\xff\xfe", 1024, map[string]string{"SYSLOG_RAW_BASE64": "PDEzPv/+"}},
+	}
+
+	for num, test := range tests {
+		if got := RawFields(test.buf, test.max); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Failed test %d:\nOriginal: %q\nExpected: %v\n     Got: %v", num, test.buf, test.expected, got)
+		}
+	}
+}
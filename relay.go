@@ -0,0 +1,311 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Messages waiting for a relay's upstream beyond this many are dropped.
+	relayQueueSize = 4096
+
+	// Close waits this long for queued messages to be relayed.
+	relayCloseTimeout = 5 * time.Second
+)
+
+// FormatRFC5424 serializes a message as RFC5424, for relaying. As RFC3164
+// section 4.3 asks of relays, a message without a HOSTNAME is given the
+// address it came from, and one without a TIMESTAMP already has the time it
+// arrived. Header fields are cut to RFC5424's lengths, with anything but
+// printable ASCII replaced.
+func (msg *SyslogMessage) FormatRFC5424() string {
+	hostname := msg.Hostname
+	if hostname == "" && msg.Source != "" {
+		hostname = sourceHost(msg.Source)
+	}
+	appName := msg.AppName
+	if appName == "" {
+		appName = strings.TrimSuffix(msg.Tag, ":")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s %s %s",
+		msg.Facility<<3|msg.Severity&7,
+		msg.Timestamp.Format("2006-01-02T15:04:05.000000Z07:00"),
+		headerField(hostname, 255),
+		headerField(appName, 48),
+		headerField(msg.ProcID, 128),
+		headerField(msg.MsgID, 32),
+		msg.StructuredData.String())
+	if msg.Message != "" {
+		b.WriteByte(' ')
+		if msg.MessageCharset == "UTF-8" {
+			b.WriteString(utf8BOM)
+		}
+		b.WriteString(msg.Message)
+	}
+	return b.String()
+}
+
+// headerField makes s fit an RFC5424 header field of up to max characters
+// of printable ASCII, or NILVALUE if it's empty.
+func headerField(s string, max int) string {
+	if s == "" {
+		return "-"
+	}
+	if len(s) > max {
+		s = s[:max]
+	}
+	return strings.Map(func(r rune) rune {
+		if r < '!' || r > '~' {
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// Relay forwards messages, as RFC5424, to an upstream syslog server over UDP,
+// TCP or TLS (octet-counted, per RFC6587 and RFC5425), from a queue so that a
+// slow or missing upstream doesn't hold up anything else. Stream connections
+// are made again when they fail.
+type Relay struct {
+	network string
+	addr    string
+	tls     *tls.Config
+
+	// confirm, set for relays in the failover chain, has Send wait for
+	// each message to be written, rather than only queued, so the chain
+	// knows to pass it on to the next output if it isn't.
+	confirm bool
+
+	queue   chan relayLine
+	done    chan struct{}
+	mu      sync.Mutex
+	dropped int
+	closed  bool
+}
+
+// relayLine is a message queued for an upstream, and where to report whether
+// it was written, if anywhere.
+type relayLine struct {
+	line string
+	sent chan error
+}
+
+// relayGroup is the sink relaying messages to every -relay upstream.
+type relayGroup []*Relay
+
+func init() {
+	RegisterSink("relay", func() (Sink, error) {
+		var group relayGroup
+		for _, upstream := range relayTo {
+			relay, err := NewRelay(upstream)
+			if err != nil {
+				return nil, err
+			}
+			group = append(group, relay)
+		}
+		if len(group) == 0 {
+			return nil, nil
+		}
+		return group, nil
+	})
+}
+
+// Write queues a message for every upstream, failing only if none of them
+// will take it.
+func (g relayGroup) Write(msg *SyslogMessage) error {
+	var err error
+	sent := false
+	for _, relay := range g {
+		if e := relay.Send(msg); e != nil {
+			err = e
+		} else {
+			sent = true
+		}
+	}
+	if sent {
+		return nil
+	}
+	return err
+}
+
+// confirm has each relay in the group wait for messages to be written.
+func (g relayGroup) confirm() {
+	for _, relay := range g {
+		relay.confirm = true
+	}
+}
+
+// Flush does nothing: relays send messages as soon as they can.
+func (g relayGroup) Flush() error {
+	return nil
+}
+
+func (g relayGroup) Close() error {
+	for _, relay := range g {
+		relay.Close()
+	}
+	return nil
+}
+
+// NewRelay returns a Relay to an upstream given as udp://HOST:PORT,
+// tcp://HOST:PORT or tls://HOST:PORT, and starts it sending.
+func NewRelay(upstream string) (*Relay, error) {
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, err
+	}
+	if u.Port() == "" || u.Path != "" {
+		return nil, fmt.Errorf("bad relay %q; expected SCHEME://HOST:PORT", upstream)
+	}
+	r := &Relay{addr: u.Host, queue: make(chan relayLine, relayQueueSize), done: make(chan struct{})}
+	switch u.Scheme {
+	case "udp", "tcp":
+		r.network = u.Scheme
+	case "tls":
+		r.network = "tcp"
+		r.tls = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("bad relay %q; expected udp, tcp or tls", upstream)
+	}
+	go r.run()
+	return r, nil
+}
+
+// Send queues a message for the upstream, failing, and dropping it, if the
+// queue is full. Messages are kept queued while the upstream is down, until
+// it's back, unless the relay confirms them: then Send waits for the message
+// to be written, failing if the upstream can't be reached.
+func (r *Relay) Send(msg *SyslogMessage) error {
+	line := relayLine{line: msg.FormatRFC5424()}
+	if r.confirm {
+		line.sent = make(chan error, 1)
+	}
+	if err := r.enqueue(line); err != nil {
+		return err
+	}
+	if line.sent == nil {
+		return nil
+	}
+	return <-line.sent
+}
+
+func (r *Relay) enqueue(line relayLine) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return fmt.Errorf("relay to %s is closed", r.addr)
+	}
+	select {
+	case r.queue <- line:
+		return nil
+	default:
+		r.dropped++
+		return fmt.Errorf("relay to %s isn't keeping up", r.addr)
+	}
+}
+
+// Close stops the relay once it's sent the messages queued, or given up on
+// them after relayCloseTimeout.
+func (r *Relay) Close() {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.mu.Unlock()
+	select {
+	case <-r.done:
+	case <-time.After(relayCloseTimeout):
+		log.Printf("gave up relaying %d messages to %s", len(r.queue), r.addr)
+	}
+}
+
+func (r *Relay) run() {
+	defer close(r.done)
+	var conn net.Conn
+	for line := range r.queue {
+		var err error
+		for attempt := 0; ; attempt++ {
+			if conn == nil {
+				if conn, err = r.dial(); err != nil {
+					// Whoever's waiting on the message can send it
+					// elsewhere; otherwise it waits for the upstream.
+					if line.sent != nil {
+						break
+					}
+					if attempt == 0 {
+						log.Printf("can't relay to %s: %s", r.addr, err)
+					}
+					time.Sleep(time.Second)
+					continue
+				}
+			}
+			if err = r.write(conn, line.line); err != nil {
+				conn.Close()
+				conn = nil
+				// Try a fresh connection once; after that the upstream
+				// is as good as down.
+				if attempt == 0 {
+					continue
+				}
+				if line.sent == nil {
+					log.Printf("can't relay to %s: %s", r.addr, err)
+				}
+			}
+			break
+		}
+		if line.sent != nil {
+			line.sent <- err
+		}
+		r.reportDropped()
+	}
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+func (r *Relay) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if r.tls != nil {
+		conn, err := tls.DialWithDialer(dialer, r.network, r.addr, r.tls)
+		if err != nil {
+			// Not a nil *tls.Conn, which wouldn't be a nil net.Conn.
+			return nil, err
+		}
+		return conn, nil
+	}
+	return dialer.Dial(r.network, r.addr)
+}
+
+func (r *Relay) write(conn net.Conn, line string) error {
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if r.network == "udp" {
+		_, err := conn.Write([]byte(line))
+		return err
+	}
+	_, err := conn.Write([]byte(strconv.Itoa(len(line)) + " " + line))
+	return err
+}
+
+func (r *Relay) reportDropped() {
+	r.mu.Lock()
+	dropped := r.dropped
+	r.dropped = 0
+	r.mu.Unlock()
+	if dropped > 0 {
+		log.Printf("dropped %d messages for %s, which isn't keeping up", dropped, r.addr)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatRFC5424(t *testing.T) {
+	tests := []struct {
+		buf      string
+		expected string
+	}{
+		{
+			"<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - \xef\xbb\xbf'su root' failed",
+			"<34>1 2003-10-11T22:14:15.003000Z mymachine.example.com su - ID47 - \xef\xbb\xbf'su root' failed",
+		},
+		{
+			`<165>1 2003-10-11T22:14:15.003Z host app 1234 - [exampleSDID@32473 iut="3" eventSource="Application"] hello`,
+			`<165>1 2003-10-11T22:14:15.003000Z host app 1234 - [exampleSDID@32473 eventSource="Application" iut="3"] hello`,
+		},
+		{
+			"<13>1 2003-10-11T22:14:15Z - - - - -",
+			"<13>1 2003-10-11T22:14:15.000000Z 192.0.2.1 - - - -",
+		},
+		{
+			"<13>Oct 11 22:14:15 host1 sshd[42]: Accepted publickey",
+			"<13>1 YEAR-10-11T22:14:15.000000Z host1 sshd 42 - - Accepted publickey",
+		},
+	}
+
+	for num, test := range tests {
+		msg := NewSyslogMessage()
+		msg.Location = time.UTC
+		msg.Parse(test.buf, "192.0.2.1:514")
+		// RFC3164 timestamps take their year from the clock.
+		expected := strings.Replace(test.expected, "YEAR", strconv.Itoa(msg.Timestamp.Year()), 1)
+		if got := msg.FormatRFC5424(); got != expected {
+			t.Errorf("Failed test %d: expected %q, got %q", num, expected, got)
+		}
+	}
+}
+
+func TestHeaderField(t *testing.T) {
+	tests := []struct {
+		s        string
+		max      int
+		expected string
+	}{
+		{"", 48, "-"},
+		{"app", 48, "app"},
+		{"my app\n", 48, "my_app_"},
+		{"abcdef", 4, "abcd"},
+		{"caf\xc3\xa9", 48, "caf_"},
+	}
+
+	for num, test := range tests {
+		if got := headerField(test.s, test.max); got != test.expected {
+			t.Errorf("Failed test %d: expected %q, got %q", num, test.expected, got)
+		}
+	}
+}
+
+func TestRelayUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	relay, err := NewRelay("udp://" + conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := NewSyslogMessage()
+	msg.Parse("<13>1 2003-10-11T22:14:15Z host1 app - - - hello", "192.0.2.1:514")
+	relay.Send(msg)
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "<13>1 2003-10-11T22:14:15.000000Z host1 app - - - hello"
+	if got := string(buf[:n]); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestRelayTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	relay, err := NewRelay("tcp://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, buf := range []string{
+		"<13>1 2003-10-11T22:14:15Z host1 app - - - first",
+		"<13>1 2003-10-11T22:14:15Z host1 app - - - second",
+	} {
+		msg := NewSyslogMessage()
+		msg.Parse(buf, "192.0.2.1:514")
+		relay.Send(msg)
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	scanner := bufio.NewScanner(conn)
+	scanner.Split(ScanFrames)
+	for _, expected := range []string{
+		"<13>1 2003-10-11T22:14:15.000000Z host1 app - - - first",
+		"<13>1 2003-10-11T22:14:15.000000Z host1 app - - - second",
+	} {
+		if !scanner.Scan() {
+			t.Fatalf("Expected %q, got %v", expected, scanner.Err())
+		}
+		if got := scanner.Text(); got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	}
+}
+
+func TestNewRelayErrors(t *testing.T) {
+	for num, upstream := range []string{
+		"host:514",
+		"udp://host",
+		"http://host:80",
+		"tcp://host:514/path",
+	} {
+		if _, err := NewRelay(upstream); err == nil {
+			t.Errorf("Failed test %d: expected an error for %q", num, upstream)
+		}
+	}
+}
+
+func TestRelayWhileDown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	relay, err := NewRelay("tcp://" + addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := NewSyslogMessage()
+	msg.Parse("<13>1 2003-10-11T22:14:15Z host1 app - - - queued", "192.0.2.1:514")
+
+	// A confirming relay, as in a failover chain, fails straight away, so
+	// the message can go elsewhere...
+	relay.confirm = true
+	if err := relay.Send(msg); err == nil {
+		t.Errorf("Expected an error with nothing listening")
+	}
+
+	// ...while a plain one keeps it until the upstream's back.
+	relay.confirm = false
+	if err := relay.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	if listener, err = net.Listen("tcp", addr); err != nil {
+		t.Skipf("Can't listen on %s again: %s", addr, err)
+	}
+	defer listener.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	scanner := bufio.NewScanner(conn)
+	scanner.Split(ScanFrames)
+	expected := "<13>1 2003-10-11T22:14:15.000000Z host1 app - - - queued"
+	if !scanner.Scan() || scanner.Text() != expected {
+		t.Errorf("Expected %q, got %q (%v)", expected, scanner.Text(), scanner.Err())
+	}
+}
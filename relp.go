@@ -0,0 +1,183 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+)
+
+// The RELP offers we make in response to a client's "open" command.
+const relpOffers = "relp_version=0\nrelp_software=journald-syslog\ncommands=syslog"
+
+var (
+	errBadRELPFrame     = errors.New("malformed RELP frame")
+	errRELPFrameTooLong = errors.New("RELP frame exceeds maximum message size")
+)
+
+// RELPFrame is a single RELP command or response:
+// "TXNR SP COMMAND SP DATALEN [SP DATA] TRAILER".
+type RELPFrame struct {
+	Txnr    int
+	Command string
+	Data    string
+}
+
+// readRELPToken reads a header field terminated by SP or LF, returning the
+// field and its terminator.
+func readRELPToken(r *bufio.Reader, max int) (string, byte, error) {
+	var token []byte
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return "", 0, err
+		}
+		if c == ' ' || c == '\n' {
+			return string(token), c, nil
+		}
+		if len(token) == max {
+			return "", 0, errBadRELPFrame
+		}
+		token = append(token, c)
+	}
+}
+
+// ReadRELPFrame reads the next complete frame from r. It returns io.EOF only
+// if the stream ends cleanly between frames.
+func ReadRELPFrame(r *bufio.Reader) (*RELPFrame, error) {
+	txnr, delim, err := readRELPToken(r, maxMsgLenDigits)
+	if err != nil {
+		return nil, err
+	}
+	frame := &RELPFrame{}
+	if frame.Txnr, err = strconv.Atoi(txnr); err != nil || delim != ' ' {
+		return nil, errBadRELPFrame
+	}
+
+	if frame.Command, delim, err = readRELPToken(r, 32); err != nil {
+		return nil, unexpectedEOF(err)
+	} else if delim != ' ' || frame.Command == "" {
+		return nil, errBadRELPFrame
+	}
+
+	datalen, delim, err := readRELPToken(r, maxMsgLenDigits)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	length, err := strconv.Atoi(datalen)
+	if err != nil || length < 0 {
+		return nil, errBadRELPFrame
+	}
+	if length > PACKETSIZE {
+		return nil, errRELPFrameTooLong
+	}
+
+	if length > 0 {
+		if delim != ' ' {
+			return nil, errBadRELPFrame
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		frame.Data = string(data)
+		delim, err = r.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+	if delim != '\n' {
+		return nil, errBadRELPFrame
+	}
+	return frame, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// WriteRELPFrame writes a single frame to w.
+func WriteRELPFrame(w io.Writer, frame *RELPFrame) error {
+	var err error
+	if len(frame.Data) > 0 {
+		_, err = fmt.Fprintf(w, "%d %s %d %s\n", frame.Txnr, frame.Command, len(frame.Data), frame.Data)
+	} else {
+		_, err = fmt.Fprintf(w, "%d %s 0\n", frame.Txnr, frame.Command)
+	}
+	return err
+}
+
+// ServeRELP runs the server side of a RELP session: it reads commands from r
+// and answers them on w until the client closes the session. Each "syslog"
+// command is passed to ingest along with source, and is only acknowledged
+// once ingest has returned, so the client can retransmit anything we never
+// got around to.
+func ServeRELP(r io.Reader, w io.Writer, source string, ingest func(string, string)) error {
+	reader := bufio.NewReader(r)
+	opened := false
+	for {
+		frame, err := ReadRELPFrame(reader)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		response := &RELPFrame{Txnr: frame.Txnr, Command: "rsp"}
+		switch {
+		case frame.Command == "open":
+			opened = true
+			response.Data = "200 OK\n" + relpOffers
+		case !opened:
+			response.Data = "500 session not open"
+		case frame.Command == "syslog":
+			if len(frame.Data) > 0 {
+				ingest(frame.Data, source)
+			}
+			response.Data = "200 OK"
+		case frame.Command == "close":
+			if err := WriteRELPFrame(w, response); err != nil {
+				return err
+			}
+			return WriteRELPFrame(w, &RELPFrame{Txnr: 0, Command: "serverclose"})
+		default:
+			response.Data = "500 unsupported command " + frame.Command
+		}
+		if err := WriteRELPFrame(w, response); err != nil {
+			return err
+		}
+	}
+}
+
+// HandleRELPListener takes a stream listener and repeatedly accepts new
+// connections from it, serving each one as a RELP session in its own
+// goroutine.
+func HandleRELPListener(fd net.Listener, config *SocketConfig) {
+	AcceptConnections(fd, func(conn net.Conn) {
+		defer conn.Close()
+		source := conn.RemoteAddr().String()
+		tconn := newTimeoutConn(conn, *readTimeout, *idleTimeout)
+		defer drainer.Track(tconn)()
+		extra := transportFields(connTransport(conn), nil)
+		err := ServeRELP(tconn, conn, source, func(buf string, source string) {
+			tconn.MessageDone()
+			ingestMessage(config, buf, source, extra)
+		})
+		if isTimeout(err) {
+			log.Printf("closing idle RELP session from %s", source)
+		} else if err != nil {
+			log.Println(err)
+		}
+	})
+}
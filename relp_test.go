@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestServeRELP(t *testing.T) {
+	session := "1 open 86 relp_version=0\nrelp_software=librelp,1.2.14,http://librelp.adiscon.com\ncommands=syslog\n" +
+		"2 syslog 38 <13>Dec 15 11:55:02 host user: message\n" +
+		"3 syslog 11 <13>1 - - a\n" +
+		"4 close 0\n"
+
+	var ingested []string
+	var out bytes.Buffer
+	err := ServeRELP(strings.NewReader(session), &out, "127.0.0.1", func(buf string, source string) {
+		ingested = append(ingested, buf)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+
+	expected := []string{`<13>Dec 15 11:55:02 host user: message`, `<13>1 - - a`}
+	if !reflect.DeepEqual(ingested, expected) {
+		t.Errorf("Ingested messages:\nExpected: %q\n     Got: %q", expected, ingested)
+	}
+
+	responses := "1 rsp 67 200 OK\n" + relpOffers + "\n" +
+		"2 rsp 6 200 OK\n" +
+		"3 rsp 6 200 OK\n" +
+		"4 rsp 0\n" +
+		"0 serverclose 0\n"
+	if out.String() != responses {
+		t.Errorf("Responses:\nExpected: %q\n     Got: %q", responses, out.String())
+	}
+}
+
+func TestServeRELPRejectsBeforeOpen(t *testing.T) {
+	var out bytes.Buffer
+	err := ServeRELP(strings.NewReader("1 syslog 11 <13>1 - - a\n"), &out, "127.0.0.1", func(buf string, source string) {
+		t.Errorf("Unexpected message ingested: %q", buf)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if expected := "1 rsp 20 500 session not open\n"; out.String() != expected {
+		t.Errorf("Responses:\nExpected: %q\n     Got: %q", expected, out.String())
+	}
+}
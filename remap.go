@@ -0,0 +1,81 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RemapRule gives the messages matching a filter another severity or
+// facility, for senders known to get theirs wrong or to use their own.
+type RemapRule struct {
+	Filter *Filter
+	Value  int
+}
+
+// remapRules holds the rules given with -severity-rule or -facility-rule, in
+// order.
+type remapRules struct {
+	field string // "severity" or "facility"
+	rules []RemapRule
+}
+
+func (r *remapRules) String() string {
+	return ""
+}
+
+// Set parses a rule given as EXPRESSION -> SEVERITY (or FACILITY), where
+// EXPRESSION is a filter (see Filter).
+func (r *remapRules) Set(value string) error {
+	i := strings.LastIndex(value, "->")
+	if i < 0 {
+		return fmt.Errorf("bad %s rule %q; expected EXPRESSION -> %s", r.field, value, strings.ToUpper(r.field))
+	}
+	filter, err := ParseFilter(value[:i])
+	if err != nil {
+		return err
+	}
+	parse := ParseSeverity
+	if r.field == "facility" {
+		parse = ParseFacility
+	}
+	number, err := parse(strings.TrimSpace(value[i+2:]))
+	if err != nil {
+		return err
+	}
+	r.rules = append(r.rules, RemapRule{filter, number})
+	return nil
+}
+
+// Apply gives msg the severity or facility of the first rule it matches, if
+// any, returning the field recording the one it was sent with.
+func (r *remapRules) Apply(msg *SyslogMessage) map[string]string {
+	target := &msg.Severity
+	if r.field == "facility" {
+		target = &msg.Facility
+	}
+	for _, rule := range r.rules {
+		if !rule.Filter.Match(msg) {
+			continue
+		}
+		if rule.Value == *target {
+			return nil
+		}
+		original := *target
+		*target = rule.Value
+		return map[string]string{"SYSLOG_ORIGINAL_" + strings.ToUpper(r.field): strconv.Itoa(original)}
+	}
+	return nil
+}
+
+// remapFacilities and remapSeverities are the -facility-rule and
+// -severity-rule rules. Facilities are remapped first, so that severity
+// rules see the facility a message ends up with.
+var (
+	remapFacilities = &remapRules{field: "facility"}
+	remapSeverities = &remapRules{field: "severity"}
+)
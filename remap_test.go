@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSeverityRules(t *testing.T) {
+	rules := &remapRules{field: "severity"}
+	for _, rule := range []string{
+		"facility=local4 and tag=janky-app and severity=err -> warning",
+		"tag=janky-app -> debug",
+		"hostname~->$ -> notice",
+	} {
+		if err := rules.Set(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tests = []struct {
+		buf      string
+		severity int
+		original string
+	}{
+		{"<163>Oct 16 12:00:00 host1 janky-app[1]: disk on fire", 4, "3"},
+		{"<162>Oct 16 12:00:00 host1 janky-app[1]: disk on fire", 7, "2"},
+		{"<167>Oct 16 12:00:00 host1 janky-app[1]: debugging", 7, ""},
+		{"<163>Oct 16 12:00:00 host1 other-app[1]: disk on fire", 3, ""},
+		{"<11>1 2026-10-16T12:00:00Z odd-> app - - - hi", 5, "3"},
+	}
+
+	for num, test := range tests {
+		msg := NewSyslogMessage()
+		msg.Parse(test.buf, "192.0.2.1:514")
+		fields := rules.Apply(msg)
+		if msg.Severity != test.severity || fields["SYSLOG_ORIGINAL_SEVERITY"] != test.original {
+			t.Errorf("Failed test %d: expected severity %d (originally %q), got %d, %v", num, test.severity, test.original, msg.Severity, fields)
+		}
+		if got := msg.Fields()["SYSLOG_SEVERITY"]; got != strconv.Itoa(test.severity) {
+			t.Errorf("Failed test %d: expected SYSLOG_SEVERITY %d, got %s", num, test.severity, got)
+		}
+	}
+
+	for num, bad := range []string{
+		"severity=err",
+		"severity=err -> loud",
+		"severity=loud -> warning",
+		" -> warning",
+	} {
+		if err := rules.Set(bad); err == nil {
+			t.Errorf("Failed test %d: expected an error for %q", num, bad)
+		}
+	}
+}
+
+func TestFacilityRules(t *testing.T) {
+	rules := &remapRules{field: "facility"}
+	for _, rule := range []string{
+		"source=10.2.0.0/24 -> local6",
+		"tag=nginx|haproxy -> 23",
+	} {
+		if err := rules.Set(rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tests = []struct {
+		buf, source string
+		facility    int
+		original    string
+	}{
+		{"<13>Oct 16 12:00:00 sw1 kernel: link down", "10.2.0.7:514", 22, "1"},
+		{"<182>Oct 16 12:00:00 sw1 kernel: link down", "10.2.0.7:514", 22, ""},
+		{"<13>Oct 16 12:00:00 web1 nginx[1]: started", "10.3.0.7:514", 23, "1"},
+		{"<13>Oct 16 12:00:00 web1 cron[1]: ran", "10.3.0.7:514", 1, ""},
+	}
+
+	for num, test := range tests {
+		msg := NewSyslogMessage()
+		msg.Parse(test.buf, test.source)
+		fields := rules.Apply(msg)
+		if msg.Facility != test.facility || fields["SYSLOG_ORIGINAL_FACILITY"] != test.original {
+			t.Errorf("Failed test %d: expected facility %d (originally %q), got %d, %v", num, test.facility, test.original, msg.Facility, fields)
+		}
+	}
+
+	if err := rules.Set("source=10.2.0.0/24 -> err"); err == nil {
+		t.Errorf("Expected an error for a severity in a facility rule")
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+// RepeatSuppressor collapses runs of identical messages from the same sender
+// and program, as chatty devices send, the way syslogd does: the first is
+// sent as usual, and the repeats after it are counted rather than sent. When
+// the run ends, or the window has passed since the first uncounted repeat,
+// an entry saying "last message repeated N times" is sent in their place,
+// with the count in SYSLOG_REPEAT_COUNT. Run checks for runs whose window
+// has passed, and Flush ends the lot.
+type RepeatSuppressor struct {
+	window time.Duration
+	send   func(*SyslogMessage, map[string]string)
+	clock  clockwork.Clock
+
+	mu   sync.Mutex
+	runs map[string]*repeatRun
+}
+
+type repeatRun struct {
+	msg       *SyslogMessage
+	count     int
+	last      *SyslogMessage
+	lastExtra map[string]string
+	deadline  time.Time
+}
+
+func NewRepeatSuppressor(window time.Duration, send func(*SyslogMessage, map[string]string)) *RepeatSuppressor {
+	return &RepeatSuppressor{
+		window: window,
+		send:   send,
+		clock:  clockwork.NewRealClock(),
+		runs:   map[string]*repeatRun{},
+	}
+}
+
+var (
+	repeatSuppressorsMu sync.Mutex
+	repeatSuppressors   = map[*SocketConfig]*RepeatSuppressor{}
+)
+
+// repeatsFor returns the RepeatSuppressor for a socket with Repeat set,
+// which sends its entries with SendMessage, running until draining starts
+// and flushed once it's done.
+func repeatsFor(config *SocketConfig) *RepeatSuppressor {
+	repeatSuppressorsMu.Lock()
+	defer repeatSuppressorsMu.Unlock()
+	if suppressor, ok := repeatSuppressors[config]; ok {
+		return suppressor
+	}
+	suppressor := NewRepeatSuppressor(config.Repeat, SendMessage)
+	repeatSuppressors[config] = suppressor
+	go suppressor.Run(drainer.Stopping())
+	drainer.OnDrain(drainRepeats, suppressor.Flush)
+	return suppressor
+}
+
+// repeats reports whether msg says the same as the message a run started
+// with.
+func (run *repeatRun) repeats(msg *SyslogMessage) bool {
+	return msg.Message == run.msg.Message && msg.Severity == run.msg.Severity && msg.Facility == run.msg.Facility
+}
+
+// Add counts msg if it repeats the last message from the same sender and
+// program, and otherwise sends it, after the summary of the run it ends.
+func (s *RepeatSuppressor) Add(msg *SyslogMessage, extra map[string]string) {
+	key := strings.Join([]string{msg.Source, msg.Hostname, msg.Tag, msg.AppName, msg.ProcID}, "\x00")
+
+	now := s.clock.Now()
+	s.mu.Lock()
+	previous, ok := s.runs[key]
+	if ok && previous.repeats(msg) {
+		if previous.count == 0 {
+			previous.deadline = now.Add(s.window)
+		}
+		previous.count++
+		previous.last, previous.lastExtra = msg, extra
+		s.mu.Unlock()
+		return
+	}
+	run := &repeatRun{msg: msg, deadline: now.Add(s.window)}
+	s.runs[key] = run
+	var summary *SyslogMessage
+	var summaryExtra map[string]string
+	if ok {
+		summary, summaryExtra = previous.summary()
+	}
+	s.mu.Unlock()
+
+	if summary != nil {
+		s.send(summary, summaryExtra)
+	}
+	s.send(msg, extra)
+}
+
+// summary returns the entry to send for the repeats counted in a run, if
+// any, and starts counting afresh. The caller must hold the lock.
+func (run *repeatRun) summary() (*SyslogMessage, map[string]string) {
+	if run.count == 0 {
+		return nil, nil
+	}
+	// The repeats differ from the first message only in their timestamps,
+	// so the last of them stands in for the lot.
+	summary := run.last
+	summary.Message = "last message repeated " + strconv.Itoa(run.count) + " times"
+	extra := underlay(map[string]string{"SYSLOG_REPEAT_COUNT": strconv.Itoa(run.count)}, run.lastExtra)
+	run.count, run.last, run.lastExtra = 0, nil, nil
+	return summary, extra
+}
+
+// Sweep sends the summary of each run's repeats once the window has passed
+// since the first of them, and forgets runs once a window passes without
+// any.
+func (s *RepeatSuppressor) Sweep() {
+	s.sweep(false)
+}
+
+// Flush sends the summaries of every run's repeats so far, and forgets the
+// runs.
+func (s *RepeatSuppressor) Flush() {
+	s.sweep(true)
+}
+
+func (s *RepeatSuppressor) sweep(all bool) {
+	now := s.clock.Now()
+	var summaries []*SyslogMessage
+	var extras []map[string]string
+	s.mu.Lock()
+	for key, run := range s.runs {
+		if !all && now.Before(run.deadline) {
+			continue
+		}
+		summary, extra := run.summary()
+		if summary == nil || all {
+			delete(s.runs, key)
+		} else {
+			run.deadline = now.Add(s.window)
+		}
+		if summary != nil {
+			summaries, extras = append(summaries, summary), append(extras, extra)
+		}
+	}
+	s.mu.Unlock()
+	for i, summary := range summaries {
+		s.send(summary, extras[i])
+	}
+}
+
+// Run sweeps the runs a few times a window, until stop is closed.
+func (s *RepeatSuppressor) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-s.clock.After(s.window / 4):
+			s.Sweep()
+		}
+	}
+}
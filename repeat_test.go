@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+func TestRepeatSuppressor(t *testing.T) {
+	var sent []string
+	suppressor := NewRepeatSuppressor(50*time.Millisecond, func(msg *SyslogMessage, extra map[string]string) {
+		sent = append(sent, msg.Hostname+": "+msg.Message+" "+extra["SYSLOG_REPEAT_COUNT"])
+	})
+	clock := clockwork.NewFakeClock()
+	suppressor.clock = clock
+
+	add := func(lines ...string) {
+		for _, line := range lines {
+			msg := NewSyslogMessage()
+			msg.Parse(line, "192.0.2.1:514")
+			suppressor.Add(msg, nil)
+		}
+	}
+	add(
+		"<13>Dec 15 11:55:02 host1 sshd[1]: link down",
+		"<13>Dec 15 11:55:03 host1 sshd[1]: link down",
+		"<13>Dec 15 11:55:04 host2 sshd[1]: link down",
+		"<13>Dec 15 11:55:05 host1 sshd[1]: link down",
+		"<11>Dec 15 11:55:06 host1 sshd[1]: link down",
+		"<13>Dec 15 11:55:07 host1 sshd[1]: link up",
+		"<13>Dec 15 11:55:08 host1 sshd[1]: link up",
+	)
+
+	// Runs ended by a different message are summed up straight away; the
+	// last once the window has passed.
+	expected := []string{
+		"host1: link down ",
+		"host2: link down ",
+		"host1: last message repeated 2 times 2",
+		"host1: link down ",
+		"host1: link up ",
+	}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected %q, got %q", expected, sent)
+	}
+	clock.Advance(49 * time.Millisecond)
+	suppressor.Sweep()
+	if len(sent) != len(expected) {
+		t.Errorf("Expected nothing before the window passed, got %q", sent[len(expected):])
+	}
+	clock.Advance(time.Millisecond)
+	suppressor.Sweep()
+	expected = append(expected, "host1: last message repeated 1 times 1")
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected %q, got %q", expected, sent)
+	}
+
+	// Nothing more comes of it, and the runs are forgotten.
+	clock.Advance(50 * time.Millisecond)
+	suppressor.Sweep()
+	if len(sent) != len(expected) {
+		t.Errorf("Expected nothing more, got %q", sent[len(expected):])
+	}
+	if len(suppressor.runs) != 0 {
+		t.Errorf("Expected the runs to be forgotten, got %v", suppressor.runs)
+	}
+
+	// Flushing sends what's counted without waiting.
+	sent = nil
+	add(
+		"<13>Dec 15 11:56:00 host3 sshd[1]: fan failed",
+		"<13>Dec 15 11:56:01 host3 sshd[1]: fan failed",
+	)
+	suppressor.Flush()
+	expected = []string{"host3: fan failed ", "host3: last message repeated 1 times 1"}
+	if !reflect.DeepEqual(sent, expected) || len(suppressor.runs) != 0 {
+		t.Errorf("Expected %q and no runs, got %q and %v", expected, sent, suppressor.runs)
+	}
+}
+
+func TestRepeatSuppressorRun(t *testing.T) {
+	sent := make(chan string, 1)
+	suppressor := NewRepeatSuppressor(20*time.Millisecond, func(msg *SyslogMessage, extra map[string]string) {
+		if count := extra["SYSLOG_REPEAT_COUNT"]; count != "" {
+			sent <- count
+		}
+	})
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		suppressor.Run(stop)
+		close(stopped)
+	}()
+
+	for i := 0; i < 3; i++ {
+		msg := NewSyslogMessage()
+		msg.Parse("<13>Dec 15 11:55:02 host1 sshd[1]: link down", "192.0.2.1:514")
+		suppressor.Add(msg, nil)
+	}
+	select {
+	case count := <-sent:
+		if count != "2" {
+			t.Errorf("Expected 2 repeats, got %s", count)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the summary")
+	}
+
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't stop")
+	}
+}
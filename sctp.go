@@ -0,0 +1,178 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// sctpSocket creates an SCTP socket of the given style (SOCK_STREAM for
+// one-to-one, SOCK_SEQPACKET for one-to-many), bound to addr and listening.
+func sctpSocket(sotype int, addr string) (int, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return -1, err
+	}
+
+	family := unix.AF_INET6
+	var sa unix.Sockaddr
+	if ip4 := tcpAddr.IP.To4(); ip4 != nil {
+		family = unix.AF_INET
+		sa4 := &unix.SockaddrInet4{Port: tcpAddr.Port}
+		copy(sa4.Addr[:], ip4)
+		sa = sa4
+	} else {
+		sa6 := &unix.SockaddrInet6{Port: tcpAddr.Port}
+		copy(sa6.Addr[:], tcpAddr.IP.To16())
+		sa = sa6
+	}
+
+	fd, err := unix.Socket(family, sotype|unix.SOCK_CLOEXEC, unix.IPPROTO_SCTP)
+	if err != nil {
+		return -1, os.NewSyscallError("socket", err)
+	}
+	if family == unix.AF_INET6 {
+		// Accept IPv4 associations on wildcard binds, as net.Listen would.
+		if err := unix.SetsockoptInt(fd, unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, 0); err != nil {
+			unix.Close(fd)
+			return -1, os.NewSyscallError("setsockopt", err)
+		}
+	}
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+		unix.Close(fd)
+		return -1, os.NewSyscallError("setsockopt", err)
+	}
+	if err := unix.Bind(fd, sa); err != nil {
+		unix.Close(fd)
+		return -1, os.NewSyscallError("bind", err)
+	}
+	if err := unix.Listen(fd, unix.SOMAXCONN); err != nil {
+		unix.Close(fd)
+		return -1, os.NewSyscallError("listen", err)
+	}
+	return fd, nil
+}
+
+// ListenSCTP binds a one-to-one style SCTP socket on addr. Its associations
+// behave like TCP connections carrying a byte stream, so the listener can be
+// served by HandleListener with the usual framing.
+func ListenSCTP(addr string) (net.Listener, error) {
+	fd, err := sctpSocket(unix.SOCK_STREAM, addr)
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "sctp:"+addr)
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// ListenSCTPSeqPacket binds a one-to-many style SCTP socket on addr, for use
+// with HandleSCTPPacket.
+func ListenSCTPSeqPacket(addr string) (int, error) {
+	return sctpSocket(unix.SOCK_SEQPACKET, addr)
+}
+
+// IsSCTPSeqPacket reports whether fd is a one-to-many style SCTP socket, as
+// systemd passes in for ListenSequentialPacket= with SocketProtocol=sctp.
+func IsSCTPSeqPacket(fd int) bool {
+	sotype, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_TYPE)
+	if err != nil || sotype != unix.SOCK_SEQPACKET {
+		return false
+	}
+	proto, err := unix.GetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_PROTOCOL)
+	return err == nil && proto == unix.IPPROTO_SCTP
+}
+
+// isSCTPConn reports whether a connection Go took for TCP is really SCTP.
+func isSCTPConn(conn *net.TCPConn) bool {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	sctp := false
+	raw.Control(func(fd uintptr) {
+		proto, err := unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_PROTOCOL)
+		sctp = err == nil && proto == unix.IPPROTO_SCTP
+	})
+	return sctp
+}
+
+// sockaddrString formats an inet socket address as host:port.
+func sockaddrString(sa unix.Sockaddr) string {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return net.JoinHostPort(net.IP(sa.Addr[:]).String(), strconv.Itoa(sa.Port))
+	case *unix.SockaddrInet6:
+		return net.JoinHostPort(net.IP(sa.Addr[:]).String(), strconv.Itoa(sa.Port))
+	}
+	return ""
+}
+
+// HandleSCTPPacket takes a one-to-many style SCTP socket and repeatedly reads
+// messages from any of its associations, handing them off for processing to
+// IngestMessage. Each SCTP message carries exactly one syslog message, much
+// like a UDP datagram; anything beyond -max-datagram-size is discarded, and
+// the message marked as truncated. It returns (closing the socket) once the
+// socket is shut down by draining.
+func HandleSCTPPacket(fd int, config *SocketConfig) {
+	truncated := false
+	// Reused: messages are copied out before the next read.
+	buf := make([]byte, config.DatagramSize())
+	whole, cut := transportFields("sctp", nil), transportFields("sctp", truncatedFields)
+	for {
+		count, _, flags, from, err := unix.Recvmsg(fd, buf, nil, 0)
+		if drainer.Draining() {
+			// Not deferred: after a panic, the handler is restarted on
+			// the same descriptor.
+			unix.Close(fd)
+			return
+		}
+		if err != nil {
+			log.Println(os.NewSyscallError("recvmsg", err))
+			continue
+		}
+
+		// Without MSG_EOR, the message didn't fit and the remainder will
+		// arrive in subsequent reads.
+		complete := flags&unix.MSG_EOR != 0
+		if truncated {
+			truncated = !complete
+			continue
+		}
+		truncated = !complete
+		if count == 0 {
+			continue
+		}
+
+		extra := whole
+		if truncated {
+			extra = cut
+		}
+		packet := string(buf[:count])
+		ingestPacket(config, packet, sockaddrString(from), extra)
+	}
+}
+
+// sctpShutdown is an io.Closer which wakes up HandleSCTPPacket when draining.
+// The descriptor itself is closed by HandleSCTPPacket, once it's no longer
+// reading from it.
+type sctpShutdown int
+
+func (fd sctpShutdown) String() string {
+	sa, err := unix.Getsockname(int(fd))
+	if err != nil {
+		return "sctp socket " + strconv.Itoa(int(fd))
+	}
+	return sockaddrString(sa)
+}
+
+func (fd sctpShutdown) Close() error {
+	return os.NewSyscallError("shutdown", unix.Shutdown(int(fd), unix.SHUT_RDWR))
+}
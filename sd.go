@@ -0,0 +1,235 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// SD-IDs and PARAM-NAMEs may be no longer than this.
+const maxSDNameLength = 32
+
+var errBadStructuredData = errors.New("malformed structured data")
+
+// StructuredData holds the parsed STRUCTURED-DATA of an RFC5424 message, as
+// {SD-ID: {PARAM-NAME: PARAM-VALUE, ...}, ...}.
+type StructuredData map[string]map[string]string
+
+// ParseStructuredData parses the STRUCTURED-DATA at the start of s: either
+// the NILVALUE "-" (giving nil), or one or more SD-ELEMENTs. It returns the
+// MSG following it, without the separating space.
+func ParseStructuredData(s string) (StructuredData, string, error) {
+	if strings.HasPrefix(s, "-") {
+		msg, err := sdRest(s[1:])
+		return nil, msg, err
+	}
+
+	sd := StructuredData{}
+	for len(s) > 0 && s[0] == '[' {
+		id, n := scanSDName(s[1:])
+		if n == 0 {
+			return nil, "", errBadStructuredData
+		}
+		s = s[1+n:]
+		if _, ok := sd[id]; ok {
+			// RFC5424 forbids repeating an SD-ID within a message.
+			return nil, "", errBadStructuredData
+		}
+		params := map[string]string{}
+		sd[id] = params
+
+		for len(s) > 0 && s[0] == ' ' {
+			name, n := scanSDName(s[1:])
+			if n == 0 || len(s) < n+3 || s[1+n] != '=' || s[2+n] != '"' {
+				return nil, "", errBadStructuredData
+			}
+			value, n, ok := scanSDValue(s[3+n:])
+			if !ok {
+				return nil, "", errBadStructuredData
+			}
+			params[name] = value
+			s = s[3+len(name)+n:]
+		}
+		if len(s) == 0 || s[0] != ']' {
+			return nil, "", errBadStructuredData
+		}
+		s = s[1:]
+	}
+	if len(sd) == 0 {
+		return nil, "", errBadStructuredData
+	}
+	msg, err := sdRest(s)
+	return sd, msg, err
+}
+
+// sdRest checks what follows STRUCTURED-DATA: nothing, or a space and MSG.
+func sdRest(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	if s[0] != ' ' {
+		return "", errBadStructuredData
+	}
+	return s[1:], nil
+}
+
+// scanSDName returns the SD-NAME at the start of s, and its length (zero if
+// there isn't one).
+func scanSDName(s string) (string, int) {
+	n := 0
+	for n < len(s) && n <= maxSDNameLength {
+		c := s[n]
+		if c <= ' ' || c > '~' || c == '=' || c == ']' || c == '"' {
+			break
+		}
+		n++
+	}
+	if n > maxSDNameLength {
+		return "", 0
+	}
+	return s[:n], n
+}
+
+// scanSDValue returns the unescaped PARAM-VALUE at the start of s, and the
+// length of it in s including the closing quote. Only \", \\ and \] are
+// escapes; a backslash before anything else is taken literally, as RFC5424
+// requires.
+func scanSDValue(s string) (string, int, bool) {
+	// Most values contain no escapes, and can be returned as they are.
+	if end := strings.IndexAny(s, `"\`); end >= 0 && s[end] == '"' {
+		return s[:end], end + 1, true
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '"' {
+			return b.String(), i + 1, true
+		}
+		if c == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\' || s[i+1] == ']') {
+			i++
+			c = s[i]
+		}
+		b.WriteByte(c)
+	}
+	return "", 0, false
+}
+
+// sdEscaper escapes PARAM-VALUEs for String.
+var sdEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`, `]`, `\]`)
+
+// String formats the structured data as it would appear in a message, with
+// elements and parameters in sorted order.
+func (sd StructuredData) String() string {
+	if len(sd) == 0 {
+		return "-"
+	}
+	ids := make([]string, 0, len(sd))
+	for id := range sd {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var b strings.Builder
+	for _, id := range ids {
+		b.WriteString("[" + id)
+		params := sd[id]
+		names := make([]string, 0, len(params))
+		for name := range params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			b.WriteString(" " + name + `="` + sdEscaper.Replace(params[name]) + `"`)
+		}
+		b.WriteString("]")
+	}
+	return b.String()
+}
+
+// Fields returns each parameter as a journal field named
+// SYSLOG_SD_<SD-ID>_<PARAM-NAME>, e.g. SYSLOG_SD_ORIGIN_IP for the ip of an
+// origin element, so that journalctl can match on it. Names are made valid
+// as JournalFieldName does, so "exampleSDID@32473" becomes
+// EXAMPLESDID_32473; where two come out the same, the first in sorted order
+// wins.
+func (sd StructuredData) Fields() map[string]string {
+	if len(sd) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(sd))
+	for id := range sd {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	fields := map[string]string{}
+	for _, id := range ids {
+		params := sd[id]
+		names := make([]string, 0, len(params))
+		for name := range params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			field := JournalFieldName("SYSLOG_SD_", id+"_"+name)
+			if _, ok := fields[field]; !ok {
+				fields[field] = params[name]
+			}
+		}
+	}
+	return fields
+}
+
+// JSON encodes the structured data as a JSON object of elements, each an
+// object of its parameters, as in {"origin":{"ip":"192.0.2.1"}}, for
+// SYSLOG_SD_JSON. It returns "" if there isn't any.
+func (sd StructuredData) JSON() string {
+	if len(sd) == 0 {
+		return ""
+	}
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(sd); err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// TimeQualityFields returns the parameters of the timeQuality element
+// (RFC5424, section 7.1) as SYSLOG_TIME_QUALITY_TZ_KNOWN,
+// SYSLOG_TIME_QUALITY_IS_SYNCED and SYSLOG_TIME_QUALITY_SYNC_ACCURACY (in
+// microseconds), leaving out malformed ones. If the sender says its time zone
+// is unknown or its clock isn't synchronized, SYSLOG_TIME_UNTRUSTED=1 is
+// added, as a warning not to rely on the timestamp.
+func (sd StructuredData) TimeQualityFields() map[string]string {
+	params, ok := sd["timeQuality"]
+	if !ok {
+		return nil
+	}
+
+	fields := map[string]string{}
+	for param, name := range map[string]string{
+		"tzKnown":  "SYSLOG_TIME_QUALITY_TZ_KNOWN",
+		"isSynced": "SYSLOG_TIME_QUALITY_IS_SYNCED",
+	} {
+		switch value := params[param]; value {
+		case "0":
+			fields["SYSLOG_TIME_UNTRUSTED"] = "1"
+			fields[name] = value
+		case "1":
+			fields[name] = value
+		}
+	}
+	if accuracy := params["syncAccuracy"]; isDigits(accuracy) {
+		fields["SYSLOG_TIME_QUALITY_SYNC_ACCURACY"] = accuracy
+	}
+	return fields
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStructuredData(t *testing.T) {
+	var tests = []struct {
+		buf  string
+		sd   StructuredData
+		rest string
+	}{
+		{`- message`, nil, "message"},
+		{`-`, nil, ""},
+		{`[exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"] message`,
+			StructuredData{"exampleSDID@32473": {"iut": "3", "eventSource": "Application", "eventID": "1011"}}, "message"},
+		{`[exampleSDID@32473 iut="3"][examplePriority@32473 class="high"]`,
+			StructuredData{"exampleSDID@32473": {"iut": "3"}, "examplePriority@32473": {"class": "high"}}, ""},
+		{`[origin] `, StructuredData{"origin": {}}, ""},
+		{`[meta x=""] a [b] c`, StructuredData{"meta": {"x": ""}}, "a [b] c"},
+		{`[id q="say \"hi\"" b="[a\]\\" l="C:\dir"] message`,
+			StructuredData{"id": {"q": `say "hi"`, "b": `[a]\`, "l": `C:\dir`}}, "message"},
+		{`[id x="unescaped ] bracket"]`, StructuredData{"id": {"x": "unescaped ] bracket"}}, ""},
+	}
+	for _, test := range tests {
+		sd, rest, err := ParseStructuredData(test.buf)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", test.buf, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(sd, test.sd) || rest != test.rest {
+			t.Errorf("%q:\nExpected: %v %q\n     Got: %v %q", test.buf, test.sd, test.rest, sd, rest)
+		}
+	}
+
+	for _, bad := range []string{
+		``,
+		`message`,
+		`-message`,
+		`[]`,
+		`[id`,
+		`[id x="1"`,
+		`[id x=1]`,
+		`[id x="1]`,
+		`[id x="1"]message`,
+		`[id x="1\"]`,
+		`[id][id]`,
+		`[an_sd_id_which_is_longer_than_32_bytes]`,
+	} {
+		if _, _, err := ParseStructuredData(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestStructuredDataString(t *testing.T) {
+	sd := StructuredData{"b": {"y": "2", "x": `1 "\]`}, "a": {}}
+	if s := sd.String(); s != `[a][b x="1 \"\\\]" y="2"]` {
+		t.Errorf("Got %s", s)
+	}
+	if s := StructuredData(nil).String(); s != "-" {
+		t.Errorf("Got %s", s)
+	}
+}
+
+func TestStructuredDataFields(t *testing.T) {
+	sd := StructuredData{
+		"origin":            {"ip": "192.0.2.1", "software": "rsyslogd"},
+		"exampleSDID@32473": {"iut": "3", "eventSource": "Application"},
+		"a-b":               {"c": "first"},
+		"a_b":               {"c": "second"},
+	}
+	expected := map[string]string{
+		"SYSLOG_SD_ORIGIN_IP":                     "192.0.2.1",
+		"SYSLOG_SD_ORIGIN_SOFTWARE":               "rsyslogd",
+		"SYSLOG_SD_EXAMPLESDID_32473_IUT":         "3",
+		"SYSLOG_SD_EXAMPLESDID_32473_EVENTSOURCE": "Application",
+		"SYSLOG_SD_A_B_C":                         "first",
+	}
+	if got := sd.Fields(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+	if got := StructuredData(nil).Fields(); got != nil {
+		t.Errorf("Expected no fields, got %v", got)
+	}
+}
+
+func TestStructuredDataJSON(t *testing.T) {
+	sd := StructuredData{"timeQuality": {"tzKnown": "1", "isSynced": "0"}, "meta": {"note": `<a & "b">`}, "empty": {}}
+	expected := `{"empty":{},"meta":{"note":"<a & \"b\">"},"timeQuality":{"isSynced":"0","tzKnown":"1"}}`
+	if got := sd.JSON(); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+	if got := StructuredData(nil).JSON(); got != "" {
+		t.Errorf("Expected nothing, got %s", got)
+	}
+
+	// Either or both forms are recorded, as the socket says.
+	line := `<13>1 2015-12-15T11:54:41Z host app - - [origin ip="192.0.2.1"] hello`
+	for _, test := range []struct {
+		setting     string
+		json, param bool
+	}{{"", false, true}, {"params", false, true}, {"json", true, false}, {"both", true, true}} {
+		msg := NewSyslogMessage()
+		msg.SDFields = test.setting
+		msg.Parse(line, "192.0.2.1:514")
+		vars := msg.Fields()
+		if _, ok := vars["SYSLOG_SD_JSON"]; ok != test.json {
+			t.Errorf("Expected SYSLOG_SD_JSON with %q to be %v, got %v", test.setting, test.json, vars)
+		}
+		if _, ok := vars["SYSLOG_SD_ORIGIN_IP"]; ok != test.param {
+			t.Errorf("Expected SYSLOG_SD_ORIGIN_IP with %q to be %v, got %v", test.setting, test.param, vars)
+		}
+		if vars["SYSLOG_STRUCTURED_DATA"] == "" {
+			t.Errorf("Expected SYSLOG_STRUCTURED_DATA with %q, got %v", test.setting, vars)
+		}
+	}
+}
+
+func TestTimeQualityFields(t *testing.T) {
+	var tests = []struct {
+		sd       StructuredData
+		expected map[string]string
+	}{
+		{
+			StructuredData{"timeQuality": {"tzKnown": "1", "isSynced": "1", "syncAccuracy": "380797"}},
+			map[string]string{
+				"SYSLOG_TIME_QUALITY_TZ_KNOWN":      "1",
+				"SYSLOG_TIME_QUALITY_IS_SYNCED":     "1",
+				"SYSLOG_TIME_QUALITY_SYNC_ACCURACY": "380797",
+			},
+		},
+		{
+			StructuredData{"timeQuality": {"tzKnown": "1", "isSynced": "0"}},
+			map[string]string{
+				"SYSLOG_TIME_QUALITY_TZ_KNOWN":  "1",
+				"SYSLOG_TIME_QUALITY_IS_SYNCED": "0",
+				"SYSLOG_TIME_UNTRUSTED":         "1",
+			},
+		},
+		{
+			StructuredData{"timeQuality": {"tzKnown": "yes", "syncAccuracy": "-1"}},
+			map[string]string{},
+		},
+		{
+			StructuredData{"origin": {"ip": "192.0.2.1"}},
+			nil,
+		},
+	}
+
+	for num, test := range tests {
+		if got := test.sd.TimeQualityFields(); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Failed test %d:\nExpected: %v\n     Got: %v", num, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"crypto/dsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+// Messages from one sender beyond this many awaiting a signature block are
+// given up on, oldest first.
+const maxSignedPending = 1024
+
+// SignatureVerifier checks messages against the RFC5848 (syslog-sign)
+// signature blocks their senders send after them. Each message is held back
+// until a block whose signature verifies against one of the trusted keys
+// lists its hash, and is then sent with SYSLOG_SIGNED=verified; one which
+// isn't covered by such a block within the window is sent with
+// SYSLOG_SIGNED=failed. Signature and certificate blocks are themselves sent
+// straight away, marked by whether their own signatures verify. Run gives up
+// on messages as their windows pass, and Flush on all of them, marking them
+// SYSLOG_SIGNED=unverified.
+type SignatureVerifier struct {
+	keys   []*dsa.PublicKey
+	window time.Duration
+	send   func(*SyslogMessage, map[string]string)
+	clock  clockwork.Clock
+
+	mu      sync.Mutex
+	pending map[string][]*signedEntry
+}
+
+type signedEntry struct {
+	msg      *SyslogMessage
+	extra    map[string]string
+	buf      string
+	deadline time.Time
+}
+
+func NewSignatureVerifier(keys []*dsa.PublicKey, window time.Duration, send func(*SyslogMessage, map[string]string)) *SignatureVerifier {
+	return &SignatureVerifier{
+		keys:    keys,
+		window:  window,
+		send:    send,
+		clock:   clockwork.NewRealClock(),
+		pending: map[string][]*signedEntry{},
+	}
+}
+
+var (
+	signatureVerifiersMu sync.Mutex
+	signatureVerifiers   = map[*SocketConfig]*SignatureVerifier{}
+)
+
+// verifierFor returns the SignatureVerifier for a socket with SignKeys set,
+// which passes its entries on to deliver, running until draining starts and
+// flushed once it's done.
+func verifierFor(config *SocketConfig) *SignatureVerifier {
+	signatureVerifiersMu.Lock()
+	defer signatureVerifiersMu.Unlock()
+	if verifier, ok := signatureVerifiers[config]; ok {
+		return verifier
+	}
+	verifier := NewSignatureVerifier(config.SignKeys, config.SignWindow, func(msg *SyslogMessage, extra map[string]string) {
+		deliver(config, msg, extra)
+	})
+	signatureVerifiers[config] = verifier
+	go verifier.Run(drainer.Stopping())
+	drainer.OnDrain(drainSigned, verifier.Flush)
+	return verifier
+}
+
+// Add checks msg, which was received from source as buf, if it's a signature
+// or certificate block, or otherwise holds it back until one covers it.
+func (v *SignatureVerifier) Add(msg *SyslogMessage, extra map[string]string, buf, source string) {
+	host := sourceHost(source)
+	if params, ok := msg.StructuredData["ssign"]; ok {
+		verified := v.verifyBlock(buf, params)
+		v.send(msg, underlay(signedFields(verified), extra))
+		if verified {
+			v.release(host, params)
+		}
+		return
+	}
+	if params, ok := msg.StructuredData["ssign-cert"]; ok {
+		v.send(msg, underlay(signedFields(v.verifyBlock(buf, params)), extra))
+		return
+	}
+
+	entry := &signedEntry{msg: msg, extra: extra, buf: buf, deadline: v.clock.Now().Add(v.window)}
+	var dropped *signedEntry
+	v.mu.Lock()
+	queue := append(v.pending[host], entry)
+	if len(queue) > maxSignedPending {
+		dropped, queue = queue[0], queue[1:]
+	}
+	v.pending[host] = queue
+	v.mu.Unlock()
+
+	if dropped != nil {
+		v.send(dropped.msg, underlay(signedFields(false), dropped.extra))
+	}
+}
+
+func signedFields(verified bool) map[string]string {
+	if verified {
+		return map[string]string{"SYSLOG_SIGNED": "verified"}
+	}
+	return map[string]string{"SYSLOG_SIGNED": "failed"}
+}
+
+// release sends the messages from host whose hashes are listed in a
+// verified signature block's HB.
+func (v *SignatureVerifier) release(host string, params map[string]string) {
+	newHash := blockHash(params["VER"])
+	if newHash == nil {
+		return
+	}
+	hashes := map[string]bool{}
+	for _, h := range strings.Fields(params["HB"]) {
+		hashes[h] = true
+	}
+
+	var released []*signedEntry
+	v.mu.Lock()
+	queue := v.pending[host][:0]
+	for _, entry := range v.pending[host] {
+		h := newHash()
+		h.Write([]byte(entry.buf))
+		if hashes[base64.StdEncoding.EncodeToString(h.Sum(nil))] {
+			released = append(released, entry)
+			continue
+		}
+		queue = append(queue, entry)
+	}
+	v.setPending(host, queue)
+	v.mu.Unlock()
+
+	for _, entry := range released {
+		v.send(entry.msg, underlay(signedFields(true), entry.extra))
+	}
+}
+
+// setPending replaces host's queue, forgetting it once it's empty. The
+// caller holds mu.
+func (v *SignatureVerifier) setPending(host string, queue []*signedEntry) {
+	if len(queue) == 0 {
+		delete(v.pending, host)
+		return
+	}
+	v.pending[host] = queue
+}
+
+// Sweep sends the messages whose windows have passed without a signature
+// block covering them as failed.
+func (v *SignatureVerifier) Sweep() {
+	now := v.clock.Now()
+	var expired []*signedEntry
+	v.mu.Lock()
+	for host, queue := range v.pending {
+		// Each sender's messages are held in the order they arrived, so
+		// their windows end in that order too.
+		n := 0
+		for n < len(queue) && !now.Before(queue[n].deadline) {
+			n++
+		}
+		expired = append(expired, queue[:n]...)
+		v.setPending(host, queue[n:])
+	}
+	v.mu.Unlock()
+
+	for _, entry := range expired {
+		v.send(entry.msg, underlay(signedFields(false), entry.extra))
+	}
+}
+
+// Flush sends every message still held, marked SYSLOG_SIGNED=unverified:
+// their signature blocks may yet have come, but there's no waiting for them.
+func (v *SignatureVerifier) Flush() {
+	var held []*signedEntry
+	v.mu.Lock()
+	for host, queue := range v.pending {
+		held = append(held, queue...)
+		delete(v.pending, host)
+	}
+	v.mu.Unlock()
+
+	for _, entry := range held {
+		v.send(entry.msg, underlay(map[string]string{"SYSLOG_SIGNED": "unverified"}, entry.extra))
+	}
+}
+
+// Run sweeps the held messages a few times a window, until stop is closed.
+func (v *SignatureVerifier) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-v.clock.After(v.window / 4):
+			v.Sweep()
+		}
+	}
+}
+
+// verifyBlock reports whether the SIGN of a signature or certificate block,
+// received as buf, is a valid OpenPGP DSA signature by one of the trusted
+// keys. As RFC5848 specifies, the signature covers the whole message less
+// the SIGN parameter.
+func (v *SignatureVerifier) verifyBlock(buf string, params map[string]string) bool {
+	ver := params["VER"]
+	newHash := blockHash(ver)
+	if newHash == nil || ver[3] != '1' {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(params["SIGN"])
+	if err != nil {
+		return false
+	}
+	r, sig, ok := cutMPI(sig)
+	if !ok {
+		return false
+	}
+	s, sig, ok := cutMPI(sig)
+	if !ok || len(sig) != 0 {
+		return false
+	}
+	signed, ok := withoutSign(buf)
+	if !ok {
+		return false
+	}
+
+	h := newHash()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+	for _, key := range v.keys {
+		if dsa.Verify(key, digest, r, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockHash returns the hash function named by a block's VER (protocol
+// version 01, then the hash and signature scheme), or nil if it isn't one
+// this understands.
+func blockHash(ver string) func() hash.Hash {
+	if len(ver) != 4 || ver[:2] != "01" {
+		return nil
+	}
+	switch ver[2] {
+	case '1':
+		return sha1.New
+	case '2':
+		return sha256.New
+	}
+	return nil
+}
+
+// cutMPI cuts an OpenPGP multiprecision integer (RFC4880 section 3.2), a
+// two-octet length in bits followed by the integer, from the front of b.
+func cutMPI(b []byte) (*big.Int, []byte, bool) {
+	if len(b) < 2 {
+		return nil, nil, false
+	}
+	n := ((int(b[0])<<8 | int(b[1])) + 7) / 8
+	if len(b) < 2+n {
+		return nil, nil, false
+	}
+	return new(big.Int).SetBytes(b[2 : 2+n]), b[2+n:], true
+}
+
+// withoutSign removes the SIGN parameter, and the space before it, from a
+// block.
+func withoutSign(buf string) (string, bool) {
+	start := strings.Index(buf, ` SIGN="`)
+	if start < 0 {
+		return "", false
+	}
+	end := strings.IndexByte(buf[start+len(` SIGN="`):], '"')
+	if end < 0 {
+		return "", false
+	}
+	return buf[:start] + buf[start+len(` SIGN="`)+end+1:], true
+}
+
+// LoadSignKeys reads the DSA public keys trusted to sign messages from a PEM
+// file of PUBLIC KEY or CERTIFICATE blocks.
+func LoadSignKeys(name string) ([]*dsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(CredentialPath(name))
+	if err != nil {
+		return nil, err
+	}
+	var keys []*dsa.PublicKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		var key interface{}
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key = cert.PublicKey
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		dsaKey, ok := key.(*dsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not a DSA key", name)
+		}
+		keys = append(keys, dsaKey)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no public keys found in " + name)
+	}
+	return keys, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/dsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+func encodeMPI(n *big.Int) []byte {
+	bits := n.BitLen()
+	return append([]byte{byte(bits >> 8), byte(bits)}, n.Bytes()...)
+}
+
+// signBlock builds a signature block from host covering messages, signed by
+// key.
+func signBlock(t *testing.T, key *dsa.PrivateKey, messages ...string) string {
+	var hashes []string
+	for _, message := range messages {
+		sum := sha256.Sum256([]byte(message))
+		hashes = append(hashes, base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	block := `<110>1 2026-10-16T12:00:01Z host1 app - - [ssign VER="0121" RSID="1" SG="0" SPRI="0" GBC="1" FMN="1" CNT="` +
+		string(rune('0'+len(messages))) + `" HB="` + strings.Join(hashes, " ") + `"]`
+	digest := sha256.Sum256([]byte(block))
+	r, s, err := dsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := base64.StdEncoding.EncodeToString(append(encodeMPI(r), encodeMPI(s)...))
+	return block[:len(block)-1] + ` SIGN="` + sig + `"]`
+}
+
+func TestSignatureVerifier(t *testing.T) {
+	key := new(dsa.PrivateKey)
+	if err := dsa.GenerateParameters(&key.Parameters, rand.Reader, dsa.L1024N160); err != nil {
+		t.Fatal(err)
+	}
+	if err := dsa.GenerateKey(key, rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []string
+	verifier := NewSignatureVerifier([]*dsa.PublicKey{&key.PublicKey}, 100*time.Millisecond, func(msg *SyslogMessage, extra map[string]string) {
+		sent = append(sent, msg.Message+"="+extra["SYSLOG_SIGNED"])
+	})
+	clock := clockwork.NewFakeClock()
+	verifier.clock = clock
+	add := func(buf string) {
+		msg := NewSyslogMessage()
+		msg.Parse(buf, "192.0.2.1:514")
+		verifier.Add(msg, nil, buf, "192.0.2.1:514")
+	}
+
+	first := "<13>1 2026-10-16T12:00:00Z host1 app - - - first"
+	second := "<13>1 2026-10-16T12:00:00Z host1 app - - - second"
+	add(first)
+	add(second)
+	add("<13>1 2026-10-16T12:00:00Z host1 app - - - unsigned")
+	add(signBlock(t, key, first, second))
+	tampered := strings.Replace(signBlock(t, key, "<13>1 2026-10-16T12:00:00Z host1 app - - - forged"), `CNT="1"`, `CNT="2"`, 1)
+	add(tampered)
+
+	// The message no block covers is given up on once its window passes.
+	expected := []string{"=verified", "first=verified", "second=verified", "=failed"}
+	clock.Advance(99 * time.Millisecond)
+	verifier.Sweep()
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected %q, got %q", expected, sent)
+	}
+	clock.Advance(time.Millisecond)
+	verifier.Sweep()
+	expected = append(expected, "unsigned=failed")
+	if !reflect.DeepEqual(sent, expected) {
+		t.Errorf("Expected %q, got %q", expected, sent)
+	}
+
+	// Draining sends what's still held without waiting, as unverified.
+	add("<13>1 2026-10-16T12:00:01Z host1 app - - - late")
+	verifier.Flush()
+	expected = append(expected, "late=unverified")
+	if !reflect.DeepEqual(sent, expected) || len(verifier.pending) != 0 {
+		t.Errorf("Expected %q and nothing held, got %q and %v", expected, sent, verifier.pending)
+	}
+}
+
+func TestWithoutSign(t *testing.T) {
+	tests := []struct {
+		buf      string
+		expected string
+		ok       bool
+	}{
+		{`[ssign VER="0121" SIGN="abc="]`, `[ssign VER="0121"]`, true},
+		{`[ssign VER="0121"]`, "", false},
+		{`[ssign VER="0121" SIGN="abc=`, "", false},
+	}
+
+	for num, test := range tests {
+		got, ok := withoutSign(test.buf)
+		if got != test.expected || ok != test.ok {
+			t.Errorf("Failed test %d: expected %q, %v, got %q, %v", num, test.expected, test.ok, got, ok)
+		}
+	}
+}
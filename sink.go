@@ -0,0 +1,132 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Sink is a destination for messages besides journald, such as a file or an
+// upstream server. Sinks are registered with RegisterSink, usually from an
+// init function in their own file, and opened once flags are parsed.
+type Sink interface {
+	// Write sends a message, or queues it to be sent. The journal fields
+	// worked out for it, extra fields and mappings included, are in
+	// msg.Entry.
+	Write(msg *SyslogMessage) error
+
+	// Flush sends any messages the sink has queued or batched up.
+	Flush() error
+
+	// Close flushes the sink and releases what it holds. Nothing is
+	// written to it afterwards.
+	Close() error
+}
+
+// SinkOpener opens a sink as its flags (or socket settings) configure it,
+// returning nil if it isn't configured.
+type SinkOpener func() (Sink, error)
+
+// builtinSinkNames are the outputs which aren't Sinks, but may be given
+// filters as if they were.
+var builtinSinkNames = map[string]bool{"journald": true, "stdout": true}
+
+var (
+	sinkOpeners = map[string]SinkOpener{}
+	// sinkOrder is the order sinks were registered in, and are written to.
+	sinkOrder []string
+)
+
+// RegisterSink makes a sink available under name, for -filter and -failover
+// to refer to. It panics if the name is taken.
+func RegisterSink(name string, open SinkOpener) {
+	if _, dup := sinkOpeners[name]; dup || builtinSinkNames[name] {
+		panic(fmt.Sprintf("sink %q registered twice", name))
+	}
+	sinkOpeners[name] = open
+	sinkOrder = append(sinkOrder, name)
+}
+
+// openSink is a configured sink, and whether it's failing.
+type openSink struct {
+	name string
+	sink Sink
+
+	mu      sync.Mutex
+	failing bool
+}
+
+// sinks are the sinks opened by OpenSinks.
+var sinks []*openSink
+
+// OpenSinks opens every registered sink which is configured.
+func OpenSinks() error {
+	for _, name := range sinkOrder {
+		sink, err := sinkOpeners[name]()
+		if err != nil {
+			return err
+		}
+		if sink != nil {
+			sinks = append(sinks, &openSink{name: name, sink: sink})
+		}
+	}
+	return nil
+}
+
+// lookupSink returns the open sink called name, or nil.
+func lookupSink(name string) Sink {
+	for _, s := range sinks {
+		if s.name == name {
+			return s.sink
+		}
+	}
+	return nil
+}
+
+// WriteSinks writes a message to every open sink whose filter it passes,
+// except those in the failover chain, which only get what journald can't
+// take. Failures are logged when a sink starts failing and when it recovers,
+// rather than for every message.
+func WriteSinks(msg *SyslogMessage) {
+	for _, s := range sinks {
+		if !filters[s.name].Match(msg) || failover.Has(s.name) {
+			continue
+		}
+		err := s.sink.Write(msg)
+		s.mu.Lock()
+		if err != nil && !s.failing {
+			log.Printf("%s: %s; dropping messages for it until it recovers", s.name, err)
+		} else if err == nil && s.failing {
+			log.Printf("%s is taking messages again", s.name)
+		}
+		s.failing = err != nil
+		s.mu.Unlock()
+	}
+}
+
+// CloseSinks flushes and closes every open sink.
+func CloseSinks() {
+	for _, s := range sinks {
+		if err := s.sink.Close(); err != nil {
+			log.Printf("%s: %s", s.name, err)
+		}
+	}
+}
+
+// routingKey returns FACILITY.SEVERITY for msg, by name (as the -socket
+// facility= and severity= settings take them), which outputs to message
+// brokers route it by.
+func routingKey(msg *SyslogMessage) string {
+	facility, severity := "unknown", "unknown"
+	if msg.Facility >= 0 && msg.Facility < len(facilityNames) {
+		facility = facilityNames[msg.Facility]
+	}
+	if msg.Severity >= 0 && msg.Severity < len(severityNames) {
+		severity = severityNames[msg.Severity]
+	}
+	return facility + "." + severity
+}
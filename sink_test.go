@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// memorySink keeps the messages written to it, for tests.
+type memorySink struct {
+	messages []string
+	err      error
+	closed   bool
+}
+
+func (s *memorySink) Write(msg *SyslogMessage) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.messages = append(s.messages, msg.Entry["SYSLOG_IDENTIFIER"]+": "+msg.Message)
+	return nil
+}
+
+func (s *memorySink) confirm() {}
+
+func (s *memorySink) Flush() error {
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+var testSink *memorySink
+
+func init() {
+	RegisterSink("memory", func() (Sink, error) {
+		if testSink == nil {
+			return nil, nil
+		}
+		return testSink, nil
+	})
+}
+
+func TestSinks(t *testing.T) {
+	defer func(saved []*openSink) { sinks = saved }(sinks)
+	sinks = nil
+	defer func(saved *memorySink) { testSink = saved }(testSink)
+	testSink = &memorySink{}
+	defer func(saved sinkFilters) { filters = saved }(filters)
+	filters = sinkFilters{}
+	if err := filters.Set("memory=severity<=warning"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := OpenSinks(); err != nil {
+		t.Fatal(err)
+	}
+	if lookupSink("memory") != testSink {
+		t.Fatalf("Expected the memory sink to be open")
+	}
+	for _, buf := range []string{
+		"<11>1 2026-10-16T12:00:00Z host1 app - - - error",
+		"<14>1 2026-10-16T12:00:00Z host1 app - - - info",
+	} {
+		msg := NewSyslogMessage()
+		msg.Parse(buf, "192.0.2.1:514")
+		msg.Entry = msg.Fields()
+		WriteSinks(msg)
+	}
+	if expected := []string{"app: error"}; !reflect.DeepEqual(testSink.messages, expected) {
+		t.Errorf("Expected %q, got %q", expected, testSink.messages)
+	}
+
+	// A failing sink is noted as such until it works again.
+	testSink.err = errors.New("broken")
+	msg := NewSyslogMessage()
+	msg.Parse("<11>1 2026-10-16T12:00:00Z host1 app - - - lost", "192.0.2.1:514")
+	WriteSinks(msg)
+	if !sinks[len(sinks)-1].failing {
+		t.Errorf("Expected the sink to be failing")
+	}
+	testSink.err = nil
+	WriteSinks(msg)
+	if sinks[len(sinks)-1].failing {
+		t.Errorf("Expected the sink to have recovered")
+	}
+
+	CloseSinks()
+	if !testSink.closed {
+		t.Errorf("Expected the sink to be closed")
+	}
+}
+
+func TestRegisterSinkDuplicate(t *testing.T) {
+	for num, name := range []string{"memory", "journald"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Failed test %d: expected a panic registering %q", num, name)
+				}
+			}()
+			RegisterSink(name, func() (Sink, error) { return nil, nil })
+		}()
+	}
+}
+
+func TestRoutingKey(t *testing.T) {
+	tests := []struct {
+		buf      string
+		expected string
+	}{
+		{"<164>Oct 16 12:00:00 host1 app: hi", "local4.warning"},
+		{"<38>1 2026-10-16T12:00:00Z host1 sshd - - - hi", "auth.info"},
+		{"<0>Oct 16 12:00:00 host1 kernel: panic", "kern.emerg"},
+	}
+	for num, test := range tests {
+		msg := NewSyslogMessage()
+		msg.Parse(test.buf, "192.0.2.1:514")
+		if got := routingKey(msg); got != test.expected {
+			t.Errorf("Failed test %d: expected %s, got %s", num, test.expected, got)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// splitEntry makes an entry fit journald's limits on field sizes: a MESSAGE
+// longer than max bytes is split into several entries, each with the other
+// fields, marked SYSLOG_PART=N/COUNT and with a SYSLOG_PART_ID in common so
+// they can be put back together; other fields longer than max are cut
+// short, and listed in SYSLOG_TRUNCATED_FIELDS. Messages are split after a
+// newline where that doesn't make the part much shorter, as in stack
+// traces, and never inside a UTF-8 sequence. A max of 0 leaves entries be.
+// Only the first part keeps the message the entry came from, so that sinks
+// get it (and its drops are counted) once.
+func splitEntry(e *journalEntry, max int) []*journalEntry {
+	if max <= 0 {
+		return []*journalEntry{e}
+	}
+
+	var truncated []string
+	for k, v := range e.vars {
+		if len(v) > max {
+			truncated = append(truncated, k)
+		}
+	}
+	if len(e.message) <= max && len(truncated) == 0 {
+		return []*journalEntry{e}
+	}
+
+	vars := make(map[string]string, len(e.vars)+3)
+	for k, v := range e.vars {
+		vars[k] = v
+	}
+	if len(truncated) > 0 {
+		sort.Strings(truncated)
+		for _, k := range truncated {
+			vars[k] = vars[k][:cutPoint(vars[k], max, false)]
+		}
+		vars["SYSLOG_TRUNCATED_FIELDS"] = strings.Join(truncated, " ")
+	}
+	if len(e.message) <= max {
+		split := *e
+		split.vars = vars
+		return []*journalEntry{&split}
+	}
+
+	var parts []string
+	for rest := e.message; rest != ""; {
+		n := cutPoint(rest, max, true)
+		parts = append(parts, rest[:n])
+		rest = rest[n:]
+	}
+	var id [16]byte
+	rand.Read(id[:])
+	entries := make([]*journalEntry, len(parts))
+	for i, part := range parts {
+		partVars := make(map[string]string, len(vars)+2)
+		for k, v := range vars {
+			partVars[k] = v
+		}
+		partVars["SYSLOG_PART"] = strconv.Itoa(i+1) + "/" + strconv.Itoa(len(parts))
+		partVars["SYSLOG_PART_ID"] = hex.EncodeToString(id[:])
+		split := *e
+		split.message, split.vars = part, partVars
+		if i > 0 {
+			split.msg = nil
+		}
+		entries[i] = &split
+	}
+	return entries
+}
+
+// cutPoint returns where to cut s to at most max bytes: not inside a UTF-8
+// sequence, and with lines set, just after the last newline if that's in
+// the second half.
+func cutPoint(s string, max int, lines bool) int {
+	if len(s) <= max {
+		return len(s)
+	}
+	if lines {
+		if i := strings.LastIndexByte(s[:max], '\n'); i >= max/2 {
+			return i + 1
+		}
+	}
+	n := max
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	if n == 0 {
+		// Not UTF-8 to speak of; cut it anywhere.
+		return max
+	}
+	return n
+}
+
+// isLaterPart reports whether an entry is one of the parts of a split
+// message after the first.
+func isLaterPart(e *journalEntry) bool {
+	part := e.vars["SYSLOG_PART"]
+	return part != "" && !strings.HasPrefix(part, "1/")
+}
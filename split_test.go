@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/coreos/go-systemd/journal"
+)
+
+func TestSplitEntry(t *testing.T) {
+	trace := "panic: oops\n" + strings.Repeat("\tframe.go:42 +0x1d\n", 10)
+	var tests = []struct {
+		message string
+		vars    map[string]string
+		max     int
+		parts   []string
+		fields  string
+	}{
+		{"short", nil, 16, []string{"short"}, ""},
+		{strings.Repeat("x", 40), nil, 0, []string{strings.Repeat("x", 40)}, ""},
+		{strings.Repeat("x", 40), nil, 16, []string{strings.Repeat("x", 16), strings.Repeat("x", 16), strings.Repeat("x", 8)}, ""},
+		// Cut after a newline in the second half of the part, not before.
+		{"abcdefghijklm\nopqrstuvwxyz", nil, 16, []string{"abcdefghijklm\n", "opqrstuvwxyz"}, ""},
+		{"ab\ncdefghijklmnopqrstuvwxyz", nil, 16, []string{"ab\ncdefghijklmno", "pqrstuvwxyz"}, ""},
+		// Not inside a UTF-8 sequence.
+		{"abcdefghijklmnoé", nil, 16, []string{"abcdefghijklmno", "é"}, ""},
+		{"short", map[string]string{"SYSLOG_STRUCTURED_DATA": strings.Repeat("y", 20), "SYSLOG_HOSTNAME": "host"}, 16, []string{"short"}, "SYSLOG_STRUCTURED_DATA"},
+	}
+
+	for i, test := range tests {
+		entry := &journalEntry{"", test.message, journal.PriInfo, test.vars, nil}
+		entries := splitEntry(entry, test.max)
+		if len(entries) != len(test.parts) {
+			t.Errorf("Failed test %d: Expected %d parts, got %d", i, len(test.parts), len(entries))
+			continue
+		}
+		for j, e := range entries {
+			if e.message != test.parts[j] {
+				t.Errorf("Failed test %d: Expected part %d to be %q, got %q", i, j, test.parts[j], e.message)
+			}
+			if len(entries) > 1 && e.vars["SYSLOG_PART_ID"] != entries[0].vars["SYSLOG_PART_ID"] {
+				t.Errorf("Failed test %d: Expected parts to share a SYSLOG_PART_ID, got %q and %q", i, entries[0].vars["SYSLOG_PART_ID"], e.vars["SYSLOG_PART_ID"])
+			}
+			if got := e.vars["SYSLOG_TRUNCATED_FIELDS"]; got != test.fields {
+				t.Errorf("Failed test %d: Expected SYSLOG_TRUNCATED_FIELDS %q, got %q", i, test.fields, got)
+			}
+			for k := range test.vars {
+				if v := e.vars[k]; len(v) > test.max {
+					t.Errorf("Failed test %d: Expected %s to be at most %d bytes, got %d", i, k, test.max, len(v))
+				}
+			}
+		}
+	}
+
+	// A stack trace comes apart between frames, and nothing is lost.
+	entries := splitEntry(&journalEntry{"", trace, journal.PriErr, map[string]string{"SYSLOG_IDENTIFIER": "app"}, nil}, 64)
+	var joined strings.Builder
+	for i, e := range entries {
+		if want := strconv.Itoa(i+1) + "/" + strconv.Itoa(len(entries)); e.vars["SYSLOG_PART"] != want {
+			t.Errorf("Expected SYSLOG_PART %q, got %q", want, e.vars["SYSLOG_PART"])
+		}
+		if e.vars["SYSLOG_IDENTIFIER"] != "app" || e.priority != journal.PriErr {
+			t.Errorf("Expected part %d to keep the entry's fields, got %v", i+1, e.vars)
+		}
+		if i < len(entries)-1 && !strings.HasSuffix(e.message, "\n") {
+			t.Errorf("Expected part %d to end with a newline, got %q", i+1, e.message)
+		}
+		if !utf8.ValidString(e.message) {
+			t.Errorf("Expected part %d to be valid UTF-8, got %q", i+1, e.message)
+		}
+		joined.WriteString(e.message)
+	}
+	if joined.String() != trace {
+		t.Errorf("Expected parts to make up %q, got %q", trace, joined.String())
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-systemd/journal"
+)
+
+// Spool segments are started afresh once they reach this size.
+const spoolSegmentSize = 1 << 20
+
+var (
+	// ErrSpoolFull is returned by Spool.Add once the spool holds its limit.
+	ErrSpoolFull = errors.New("spool is full")
+
+	errBadSpoolRecord = errors.New("malformed spool record")
+)
+
+// Spool keeps entries on disk, in order, while journald can't take them or
+// the journal queue is full, and replays them once it can. Entries are
+// written to numbered segment files, each removed once it's been replayed;
+// an entry may be replayed twice if the daemon stops part way through a
+// segment, but none are lost.
+type Spool struct {
+	dir string
+	max int64
+
+	mu       sync.Mutex
+	segments []string
+	size     int64
+	next     int
+	out      *os.File
+	outSize  int64
+	in       *os.File
+	reader   *bufio.Reader
+	peeked   *journalEntry
+	peekSize int64
+	consumed int64
+}
+
+// spool, if set by -spool-dir, takes the entries journald can't.
+var spool *Spool
+
+// OpenSpool opens the spool in dir, holding up to max bytes, and picks up
+// any entries left there from before.
+func OpenSpool(dir string, max int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*.spool"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	s := &Spool{dir: dir, max: max, segments: names}
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		s.size += info.Size()
+		seq, _ := strconv.Atoi(strings.TrimSuffix(filepath.Base(name), ".spool"))
+		s.next = seq + 1
+	}
+	return s, nil
+}
+
+// Pending reports whether any entries are waiting to be replayed, in which
+// case new ones must be spooled behind them to keep their order.
+func (s *Spool) Pending() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.segments) > 0
+}
+
+// Add appends an entry to the spool.
+func (s *Spool) Add(entry *journalEntry) error {
+	record := encodeSpoolEntry(entry)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size+int64(len(record)) > s.max {
+		return ErrSpoolFull
+	}
+	if s.out == nil || s.outSize >= spoolSegmentSize {
+		if s.out != nil {
+			s.out.Close()
+		}
+		name := filepath.Join(s.dir, fmt.Sprintf("%020d.spool", s.next))
+		out, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		s.next++
+		s.out, s.outSize = out, 0
+		s.segments = append(s.segments, name)
+	}
+	if _, err := s.out.Write(record); err != nil {
+		return err
+	}
+	s.outSize += int64(len(record))
+	s.size += int64(len(record))
+	return nil
+}
+
+// peek returns the oldest entry in the spool, or nil if there are none.
+func (s *Spool) peek() *journalEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.peeked == nil && len(s.segments) > 0 {
+		if s.in == nil {
+			in, err := os.Open(s.segments[0])
+			if err != nil {
+				log.Printf("dropping unreadable spool segment: %s", err)
+				s.removeSegment()
+				continue
+			}
+			s.in, s.reader, s.consumed = in, bufio.NewReader(in), 0
+		}
+		entry, size, err := decodeSpoolEntry(s.reader)
+		if err == nil {
+			s.peeked, s.peekSize = entry, size
+			break
+		}
+		if err == io.EOF && s.writing() {
+			if s.consumed < s.outSize {
+				// Add can't have left a record half written, so
+				// something's amiss: start again from the last
+				// complete one next time.
+				s.in.Seek(s.consumed, io.SeekStart)
+				s.reader.Reset(s.in)
+				break
+			}
+		} else if err != io.EOF {
+			log.Printf("dropping the rest of corrupt spool segment %s: %s", s.segments[0], err)
+		}
+		s.removeSegment()
+	}
+	return s.peeked
+}
+
+// advance drops the entry peek returned, once it's been sent.
+func (s *Spool) advance() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peeked = nil
+	s.consumed += s.peekSize
+	s.size -= s.peekSize
+}
+
+// writing reports whether the oldest segment is the one Add is writing to.
+// The caller holds mu.
+func (s *Spool) writing() bool {
+	return s.out != nil && s.segments[0] == s.out.Name()
+}
+
+// removeSegment removes the oldest segment, which has been read. The caller
+// holds mu.
+func (s *Spool) removeSegment() {
+	if s.writing() {
+		s.out.Close()
+		s.out = nil
+	}
+	if s.in != nil {
+		s.in.Close()
+		s.in, s.reader = nil, nil
+	}
+	if info, err := os.Stat(s.segments[0]); err == nil {
+		s.size -= info.Size() - s.consumed
+	}
+	os.Remove(s.segments[0])
+	s.segments = s.segments[1:]
+	s.consumed = 0
+}
+
+// Replay sends spooled entries to journald every interval, for as long as it
+// takes them, until stop is closed; what's left then waits in the spool for
+// the next start.
+func (s *Spool) Replay(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.replay()
+		}
+	}
+}
+
+// replay sends spooled entries until there are none left or journald fails,
+// reporting how many it sent.
+func (s *Spool) replay() int {
+	sent := 0
+	for entry := s.peek(); entry != nil; entry = s.peek() {
+		if err := SendJournal(entry.namespace, entry.message, entry.priority, entry.vars); err != nil {
+			break
+		}
+		s.advance()
+		sent++
+	}
+	if sent > 0 {
+		log.Printf("replayed %d spooled entries to journald", sent)
+	}
+	return sent
+}
+
+// encodeSpoolEntry serializes an entry as a record: its length, then the
+// namespace, message, priority and fields, each string preceded by its
+// length.
+func encodeSpoolEntry(entry *journalEntry) []byte {
+	var body []byte
+	appendString := func(s string) {
+		body = binary.AppendUvarint(body, uint64(len(s)))
+		body = append(body, s...)
+	}
+	appendString(entry.namespace)
+	appendString(entry.message)
+	body = binary.AppendUvarint(body, uint64(entry.priority))
+	body = binary.AppendUvarint(body, uint64(len(entry.vars)))
+	for k, v := range entry.vars {
+		appendString(k)
+		appendString(v)
+	}
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(body))), body...)
+}
+
+// decodeSpoolEntry reads a record written by encodeSpoolEntry, returning the
+// entry and the record's size. A record cut short (as by a crash while it
+// was written) is reported as io.EOF.
+func decodeSpoolEntry(r *bufio.Reader) (*journalEntry, int64, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, io.EOF
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length > 4*maxMessageSize {
+		return nil, 0, errBadSpoolRecord
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, 0, io.EOF
+	}
+	size := int64(len(header) + len(body))
+
+	readUvarint := func() (uint64, error) {
+		n, used := binary.Uvarint(body)
+		if used <= 0 {
+			return 0, errBadSpoolRecord
+		}
+		body = body[used:]
+		return n, nil
+	}
+	readString := func() (string, error) {
+		n, err := readUvarint()
+		if err != nil || n > uint64(len(body)) {
+			return "", errBadSpoolRecord
+		}
+		s := string(body[:n])
+		body = body[n:]
+		return s, nil
+	}
+
+	entry := &journalEntry{}
+	var err error
+	if entry.namespace, err = readString(); err != nil {
+		return nil, size, err
+	}
+	if entry.message, err = readString(); err != nil {
+		return nil, size, err
+	}
+	priority, err := readUvarint()
+	if err != nil {
+		return nil, size, err
+	}
+	entry.priority = journal.Priority(priority)
+	count, err := readUvarint()
+	if err != nil {
+		return nil, size, err
+	}
+	entry.vars = make(map[string]string, min(count, 256))
+	for i := uint64(0); i < count; i++ {
+		k, err := readString()
+		if err != nil {
+			return nil, size, err
+		}
+		v, err := readString()
+		if err != nil {
+			return nil, size, err
+		}
+		entry.vars[k] = v
+	}
+	return entry, size, nil
+}
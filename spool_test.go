@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-systemd/journal"
+)
+
+func TestSpoolEntryEncoding(t *testing.T) {
+	entry := &journalEntry{"remote", "two\nlines \xff", journal.PriWarning, map[string]string{"SYSLOG_HOSTNAME": "host", "EMPTY": ""}, nil}
+	record := encodeSpoolEntry(entry)
+	got, size, err := decodeSpoolEntry(bufio.NewReader(bytes.NewReader(record)))
+	if err != nil || size != int64(len(record)) || !reflect.DeepEqual(got, entry) {
+		t.Errorf("Expected %v (%d bytes), got %v (%d bytes), %v", entry, len(record), got, size, err)
+	}
+
+	// A record cut short reads as the end of the segment.
+	if _, _, err := decodeSpoolEntry(bufio.NewReader(bytes.NewReader(record[:len(record)-1]))); err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+	record[4] = 0xff
+	if _, _, err := decodeSpoolEntry(bufio.NewReader(bytes.NewReader(record))); err != errBadSpoolRecord {
+		t.Errorf("Expected %v, got %v", errBadSpoolRecord, err)
+	}
+}
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	defer func(saved func(string) string) { journalSocket = saved }(journalSocket)
+	journalSocket = func(namespace string) string { return filepath.Join(dir, namespace) }
+	defer func(saved *Spool) { spool = saved }(spool)
+
+	var err error
+	spoolDir := filepath.Join(dir, "spool")
+	if spool, err = OpenSpool(spoolDir, 4<<20); err != nil {
+		t.Fatal(err)
+	}
+
+	// journald isn't listening yet, so entries are spooled, over more than
+	// one segment.
+	padding := strings.Repeat("x", 40000)
+	const count = 40
+	for i := 0; i < count; i++ {
+		(&journalEntry{"spooled", strconv.Itoa(i), journal.PriInfo, map[string]string{"PADDING": padding}, nil}).write()
+	}
+	if !spool.Pending() {
+		t.Fatalf("Expected entries to be spooled")
+	}
+	if segments, _ := filepath.Glob(filepath.Join(spoolDir, "*.spool")); len(segments) != 2 {
+		t.Errorf("Expected 2 segments, got %v", segments)
+	}
+	if sent := spool.replay(); sent != 0 {
+		t.Errorf("Expected nothing replayed without journald, got %d", sent)
+	}
+
+	// Reopening the spool picks them up again.
+	if spool, err = OpenSpool(spoolDir, 4<<20); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "spooled"), Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := conn.SetReadBuffer(8 << 20); err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, count+1)
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			message := strings.SplitN(string(buf[:n]), "\n", 3)[1]
+			received <- strings.TrimPrefix(message, "MESSAGE=")
+		}
+	}()
+
+	// Entries sent while some are still spooled join them.
+	(&journalEntry{"spooled", strconv.Itoa(count), journal.PriInfo, nil, nil}).write()
+	if sent := spool.replay(); sent != count+1 {
+		t.Errorf("Expected %d entries replayed, got %d", count+1, sent)
+	}
+	for i := 0; i <= count; i++ {
+		if got := <-received; got != strconv.Itoa(i) {
+			t.Fatalf("Expected entry %d, got %s", i, got)
+		}
+	}
+	if spool.Pending() || spool.size != 0 {
+		t.Errorf("Expected an empty spool, got %d bytes", spool.size)
+	}
+	if segments, _ := filepath.Glob(filepath.Join(spoolDir, "*.spool")); len(segments) != 0 {
+		t.Errorf("Expected the segments to be removed, got %v", segments)
+	}
+
+	// Entries go straight to journald again.
+	(&journalEntry{"spooled", "direct", journal.PriInfo, nil, nil}).write()
+	if got := <-received; got != "direct" || spool.Pending() {
+		t.Errorf("Expected the entry to be sent directly, got %s", got)
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	s, err := OpenSpool(t.TempDir(), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &journalEntry{"", strings.Repeat("x", 60), journal.PriInfo, nil, nil}
+	if err := s.Add(entry); err != nil {
+		t.Errorf("Unexpected error: %s", err.Error())
+	}
+	if err := s.Add(entry); err != ErrSpoolFull {
+		t.Errorf("Expected %v, got %v", ErrSpoolFull, err)
+	}
+	if _, err := os.Stat(s.dir); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,314 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ParseError describes where a message departs from the format a strict
+// socket requires it to be in.
+type ParseError struct {
+	Format string
+	Field  string
+	Offset int
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("not %s: bad %s at offset %d: %s", e.Format, e.Field, e.Offset, e.Reason)
+}
+
+// validator walks through a message field by field, remembering the offset
+// of the current one for errors.
+type validator struct {
+	format string
+	buf    string
+	offset int
+}
+
+func (v *validator) fail(field string, reason string) error {
+	return &ParseError{Format: v.format, Field: field, Offset: v.offset, Reason: reason}
+}
+
+// token returns the field up to the next space (which must be there, as
+// more fields follow, unless last is set), and moves past both.
+func (v *validator) token(field string, last bool) (string, error) {
+	rest := v.buf[v.offset:]
+	end := strings.IndexByte(rest, ' ')
+	if end < 0 {
+		if !last {
+			return "", v.fail(field, "not followed by a space")
+		}
+		end = len(rest)
+	}
+	if end == 0 {
+		return "", v.fail(field, "empty")
+	}
+	v.offset += end
+	if v.offset < len(v.buf) {
+		v.offset++
+	}
+	return rest[:end], nil
+}
+
+// pri checks the PRI field common to both formats.
+func (v *validator) pri() error {
+	rest := v.buf[v.offset:]
+	if rest == "" || rest[0] != '<' {
+		return v.fail("PRI", "missing '<'")
+	}
+	end := strings.IndexByte(rest, '>')
+	if end < 2 || end > 4 {
+		return v.fail("PRI", "expected 1 to 3 digits between '<' and '>'")
+	}
+	if !isDigits(rest[1:end]) {
+		return v.fail("PRI", "not a number from 0 to 191")
+	}
+	if _, ok := parsePRI(rest[1:end]); !ok {
+		return v.fail("PRI", "not a number from 0 to 191 without leading zeros")
+	}
+	v.offset += end + 1
+	return nil
+}
+
+// printable checks a header field of PRINTUSASCII characters, or the
+// NILVALUE.
+func (v *validator) printable(field string, max int) error {
+	start := v.offset
+	value, err := v.token(field, false)
+	if err != nil {
+		return err
+	}
+	if len(value) > max {
+		v.offset = start
+		return v.fail(field, fmt.Sprintf("longer than %d characters", max))
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < 33 || value[i] > 126 {
+			v.offset = start + i
+			return v.fail(field, "not printable US-ASCII")
+		}
+	}
+	return nil
+}
+
+// ValidateRFC5424 checks that buf strictly follows the RFC5424 message
+// format.
+func ValidateRFC5424(buf string) error {
+	v := &validator{format: "rfc5424", buf: buf}
+	if err := v.pri(); err != nil {
+		return err
+	}
+
+	if version, err := v.token("VERSION", false); err != nil {
+		return err
+	} else if version != "1" {
+		v.offset -= len(version) + 1
+		return v.fail("VERSION", "expected 1")
+	}
+
+	start := v.offset
+	timestamp, err := v.token("TIMESTAMP", false)
+	if err != nil {
+		return err
+	}
+	if timestamp != "-" {
+		if _, err := time.Parse(time.RFC3339Nano, timestamp); err != nil {
+			v.offset = start
+			return v.fail("TIMESTAMP", "not an RFC3339 date and time")
+		}
+	}
+
+	for _, field := range []struct {
+		name string
+		max  int
+	}{{"HOSTNAME", 255}, {"APP-NAME", 48}, {"PROCID", 128}, {"MSGID", 32}} {
+		if err := v.printable(field.name, field.max); err != nil {
+			return err
+		}
+	}
+
+	_, msg, err := ParseStructuredData(buf[v.offset:])
+	if err != nil {
+		return v.fail("STRUCTURED-DATA", err.Error())
+	}
+	v.offset = len(buf) - len(msg)
+	if strings.HasPrefix(msg, utf8BOM) && !utf8.ValidString(msg) {
+		return v.fail("MSG", "marked as UTF-8, but isn't")
+	}
+	return nil
+}
+
+// ValidateRFC3164 checks that buf strictly follows the RFC3164 message
+// format, with a TAG of up to 32 alphanumeric characters, optionally
+// followed by a bracketed PID, and a colon.
+func ValidateRFC3164(buf string) error {
+	v := &validator{format: "rfc3164", buf: buf}
+	if err := v.pri(); err != nil {
+		return err
+	}
+
+	rest := buf[v.offset:]
+	if len(rest) < len(time.Stamp)+1 || rest[len(time.Stamp)] != ' ' {
+		return v.fail("TIMESTAMP", "expected \"Mmm dd hh:mm:ss\"")
+	}
+	if _, err := time.Parse(time.Stamp, rest[:len(time.Stamp)]); err != nil {
+		return v.fail("TIMESTAMP", "expected \"Mmm dd hh:mm:ss\"")
+	}
+	v.offset += len(time.Stamp) + 1
+
+	if _, err := v.token("HOSTNAME", false); err != nil {
+		return err
+	}
+
+	start := v.offset
+	tag, err := v.token("TAG", true)
+	if err != nil {
+		return err
+	}
+	v.offset = start
+	if !strings.HasSuffix(tag, ":") {
+		return v.fail("TAG", "not followed by ':'")
+	}
+	tag = tag[:len(tag)-1]
+	if open := strings.IndexByte(tag, '['); open >= 0 {
+		if pid, err := strconv.Atoi(strings.TrimSuffix(tag[open+1:], "]")); err != nil || pid < 0 || !strings.HasSuffix(tag, "]") {
+			return v.fail("TAG", "bad PID")
+		}
+		tag = tag[:open]
+	}
+	if tag == "" || len(tag) > 32 {
+		return v.fail("TAG", "expected 1 to 32 characters")
+	}
+	for i := 0; i < len(tag); i++ {
+		c := tag[i]
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' && c != '_' && c != '.' && c != '/' {
+			v.offset = start + i
+			return v.fail("TAG", "not alphanumeric")
+		}
+	}
+	return nil
+}
+
+// ValidateCisco checks that buf is a message in Cisco IOS's dialect.
+func ValidateCisco(buf string) error {
+	v := &validator{format: "cisco", buf: buf}
+	if err := v.pri(); err != nil {
+		return err
+	}
+	if _, ok := NewSyslogMessage().parseCisco(buf[v.offset:]); !ok {
+		return v.fail("MNEMONIC", "no %FACILITY-SEVERITY-MNEMONIC following the sequence number, hostname and timestamp")
+	}
+	return nil
+}
+
+// Validate checks buf against the socket's format, if it's strict. With a
+// chain of formats, the message must be in one of them (and "raw" accepts
+// anything). With the default, messages with a VERSION are checked as
+// RFC5424, and the rest as RFC3164.
+func (config *SocketConfig) Validate(buf string) error {
+	if !config.Strict {
+		return nil
+	}
+	return ValidateFormat(buf, config.Format)
+}
+
+// ValidateFormat checks buf against format, as Validate does for strict
+// sockets.
+func ValidateFormat(buf string, format string) error {
+	if format == "" {
+		if end := strings.IndexByte(buf, '>'); end >= 0 {
+			if _, _, ok := cutVersion(buf[end+1:]); ok {
+				return ValidateRFC5424(buf)
+			}
+		}
+		return ValidateRFC3164(buf)
+	}
+
+	var first error
+	for _, parser := range strings.Split(format, "/") {
+		var err error
+		switch parser {
+		case "raw":
+			return nil
+		case "rfc5424":
+			err = ValidateRFC5424(buf)
+		case "rfc3164":
+			err = ValidateRFC3164(buf)
+		case "cisco":
+			err = ValidateCisco(buf)
+		default:
+			if p := LookupParser(parser); p != nil {
+				err = validateWith(p, buf)
+			}
+		}
+		if err == nil {
+			return nil
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// validateWith checks buf against a registered parser, by parsing it.
+func validateWith(parser Parser, buf string) error {
+	if !parser.Detect([]byte(buf)) {
+		return fmt.Errorf("not a %s message", parser.Name())
+	}
+	_, err := parser.Parse([]byte(buf), "")
+	return err
+}
+
+// rejectCounts tallies the messages rejected by each strict socket since
+// they were last reported.
+var (
+	rejectCountsMu sync.Mutex
+	rejectCounts   = map[string]int{}
+)
+
+// Reject counts (and with -log-rejected, logs) a message which failed
+// validation.
+func Reject(config *SocketConfig, source string, err error) {
+	rejectCountsMu.Lock()
+	rejectCounts[config.Name]++
+	rejectCountsMu.Unlock()
+	sourceVolumes.Drop(source)
+	if *logRejected {
+		log.Printf("rejected message from %s: %s", source, err)
+	}
+}
+
+// ReportRejected logs the number of messages rejected by each strict socket
+// every interval, for any socket which has rejected some, until stop is
+// closed.
+func ReportRejected(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		rejectCountsMu.Lock()
+		for name, count := range rejectCounts {
+			if name == "" {
+				name = "unnamed sockets"
+			}
+			log.Printf("rejected %d malformed messages on %s in the last %s", count, name, interval)
+		}
+		rejectCounts = map[string]int{}
+		rejectCountsMu.Unlock()
+	}
+}
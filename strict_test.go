@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	var tests = []struct {
+		format   string
+		buf      string
+		field    string
+		offset   int
+		expected string
+	}{
+		{"rfc5424", `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3"] message`, "", 0, ""},
+		{"rfc5424", `<13>1 - - - - - -`, "", 0, ""},
+		{"rfc5424", "<13>1 - - - - - - \xef\xbb\xbfvalid", "", 0, ""},
+		{"rfc5424", `13>1 - - - - - -`, "PRI", 0, "rfc5424"},
+		{"rfc5424", `<192>1 - - - - - -`, "PRI", 0, "rfc5424"},
+		{"rfc5424", `<013>1 - - - - - -`, "PRI", 0, "rfc5424"},
+		{"rfc5424", `<13>2 - - - - - -`, "VERSION", 4, "rfc5424"},
+		{"rfc5424", `<13>1 2003-10-11 - - - - -`, "TIMESTAMP", 6, "rfc5424"},
+		{"rfc5424", "<13>1 - host app\x01 - - -", "APP-NAME", 16, "rfc5424"},
+		{"rfc5424", `<13>1 - - - - an_msgid_which_is_longer_than_32_bytes -`, "MSGID", 14, "rfc5424"},
+		{"rfc5424", `<13>1 - - - -`, "PROCID", 12, "rfc5424"},
+		{"rfc5424", `<13>1 - - - - - [id x="1"`, "STRUCTURED-DATA", 16, "rfc5424"},
+		{"rfc5424", "<13>1 - - - - - - \xef\xbb\xbf\xff", "MSG", 18, "rfc5424"},
+		{"rfc3164", `<13>Dec 15 11:55:02 host user: message`, "", 0, ""},
+		{"rfc3164", `<13>Dec  5 11:55:02 host sshd[1234]: message`, "", 0, ""},
+		{"rfc3164", `<13>2015-12-15 11:55:02 host user: message`, "TIMESTAMP", 4, "rfc3164"},
+		{"rfc3164", `<13>Dec 15 11:55:02 host`, "HOSTNAME", 20, "rfc3164"},
+		{"rfc3164", `<13>Dec 15 11:55:02 host user message`, "TAG", 25, "rfc3164"},
+		{"rfc3164", `<13>Dec 15 11:55:02 host sshd[x]: message`, "TAG", 25, "rfc3164"},
+		{"rfc3164", `<13>Dec 15 11:55:02 host us#r: message`, "TAG", 27, "rfc3164"},
+		{"", `<13>1 - - - - - -`, "", 0, ""},
+		{"", `<13>Dec 15 11:55:02 host user: message`, "", 0, ""},
+		{"", `<13>1 2003-10-11 - - - - -`, "TIMESTAMP", 6, "rfc5424"},
+		{"", `<13>2 - - - - - -`, "VERSION", 4, "rfc5424"},
+		{"", `message`, "PRI", 0, "rfc3164"},
+		{"raw", `message`, "", 0, ""},
+		{"rfc5424/rfc3164", `<13>Dec 15 11:55:02 host user: message`, "", 0, ""},
+		{"rfc5424/rfc3164", `<13>Dec 15 11:55:02 host user message`, "VERSION", 4, "rfc5424"},
+		{"rfc3164/raw", `message`, "", 0, ""},
+		{"cisco", `<189>123: *Mar  1 00:00:00.123: %SYS-5-CONFIG_I: Configured`, "", 0, ""},
+		{"cisco", `<189>Configured from console`, "MNEMONIC", 5, "cisco"},
+	}
+
+	for num, test := range tests {
+		config := NewSocketConfig("")
+		config.Format = test.format
+		config.Strict = true
+		err := config.Validate(test.buf)
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("Failed test %d: unexpected error: %s", num, err.Error())
+			}
+			continue
+		}
+		perr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("Failed test %d: expected a ParseError, got %v", num, err)
+			continue
+		}
+		if perr.Field != test.field || perr.Offset != test.offset || perr.Format != test.expected {
+			t.Errorf("Failed test %d: expected %s %s at %d, got %s", num, test.expected, test.field, test.offset, perr.Error())
+		}
+	}
+
+	if err := NewSocketConfig("").Validate("message"); err != nil {
+		t.Errorf("Expected non-strict sockets to accept anything, got %s", err.Error())
+	}
+}
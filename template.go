@@ -0,0 +1,83 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateMessage is what a -message-template sees of a message: the parts
+// of its header, by name, and the entry's journal fields as .Fields.
+type templateMessage struct {
+	Hostname  string
+	Tag       string // the APP-NAME, or the RFC3164 program name
+	ProcID    string
+	MsgID     string
+	Message   string
+	Source    string
+	Facility  string
+	Severity  string
+	Timestamp time.Time
+	Fields    map[string]string
+}
+
+// MessageTemplate composes journal MESSAGEs from the parts of syslog
+// messages, such as "{{.Hostname}} {{.Tag}}: {{.Message}}", so that
+// journalctl shows where each came from.
+type MessageTemplate struct {
+	tmpl *template.Template
+}
+
+// journalTemplate, if -message-template is given, composes the MESSAGE of
+// every entry sent to journald.
+var journalTemplate *MessageTemplate
+
+// ParseMessageTemplate parses a template in text/template's syntax, and
+// tries it out on a sample message, so that one which refers to something
+// messages don't have fails here rather than on every message.
+func ParseMessageTemplate(text string) (*MessageTemplate, error) {
+	tmpl, err := template.New("message").Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	t := &MessageTemplate{tmpl}
+	sample := NewSyslogMessage()
+	sample.Parse("<13>1 2026-10-16T12:00:00Z host app 1 ID - message", "192.0.2.1:514")
+	if _, err := t.Execute(sample, sample.Fields()); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Execute composes the MESSAGE for msg, whose entry has the fields vars.
+func (t *MessageTemplate) Execute(msg *SyslogMessage, vars map[string]string) (string, error) {
+	data := templateMessage{
+		Hostname:  msg.Hostname,
+		Tag:       msg.AppName,
+		ProcID:    msg.ProcID,
+		MsgID:     msg.MsgID,
+		Message:   msg.Message,
+		Source:    msg.Source,
+		Timestamp: msg.Timestamp,
+		Fields:    vars,
+	}
+	if data.Tag == "" {
+		data.Tag = strings.TrimSuffix(msg.Tag, ":")
+	}
+	if msg.Facility >= 0 && msg.Facility < len(facilityNames) {
+		data.Facility = facilityNames[msg.Facility]
+	}
+	if msg.Severity >= 0 && msg.Severity < len(severityNames) {
+		data.Severity = severityNames[msg.Severity]
+	}
+
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
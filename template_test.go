@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMessageTemplate(t *testing.T) {
+	var tests = []struct {
+		template string
+		buf      string
+		expected string
+	}{
+		{"{{.Hostname}} {{.Tag}}: {{.Message}}", "<13>Oct 16 12:00:00 web1 nginx[42]: GET /", "web1 nginx: GET /"},
+		{"{{.Hostname}} {{.Tag}}: {{.Message}}", "<13>1 2026-10-16T12:00:00Z web1 nginx 42 - - GET /", "web1 nginx: GET /"},
+		{"[{{.Severity}}] {{.Message}} ({{.Source}})", "<11>Oct 16 12:00:00 web1 app: oops", "[err] oops (192.0.2.1:514)"},
+		{"{{.Facility}}.{{.Severity}} {{.Timestamp.Format \"15:04\"}}", "<166>1 2026-10-16T12:00:00Z web1 app - - - hi", "local4.info 12:00"},
+		{"{{.Fields.SYSLOG_PID}}/{{.Fields.MISSING}}/{{.ProcID}}", "<13>Oct 16 12:00:00 web1 nginx[42]: GET /", "42//42"},
+		{"{{.Message}}", "<13>Oct 16 12:00:00 web1 nginx[42]: GET /", "GET /"},
+	}
+
+	for num, test := range tests {
+		tmpl, err := ParseMessageTemplate(test.template)
+		if err != nil {
+			t.Errorf("Failed test %d: %s", num, err)
+			continue
+		}
+		msg := NewSyslogMessage()
+		msg.Parse(test.buf, "192.0.2.1:514")
+		got, err := tmpl.Execute(msg, msg.Fields())
+		if err != nil || got != test.expected {
+			t.Errorf("Failed test %d: expected %q, got %q (%v)", num, test.expected, got, err)
+		}
+	}
+
+	if _, err := ParseMessageTemplate("{{.Hostname"); err == nil {
+		t.Errorf("Expected an error for an unterminated action")
+	}
+	for _, bad := range []string{"{{.Nonexistent}}", "{{.Timestamp.Nonexistent}}", `{{template "other"}}`} {
+		if _, err := ParseMessageTemplate(bad); err == nil {
+			t.Errorf("Expected an error trying out %q", bad)
+		}
+	}
+}
+
+func TestMessageTemplateEntry(t *testing.T) {
+	out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved *FileSink) { stdoutSink = saved }(stdoutSink)
+	defer func(saved bool) { *stdoutJSON = saved }(*stdoutJSON)
+	stdoutSink = &FileSink{Path: "stdout", JSON: true, file: out}
+	*stdoutJSON = true
+	defer func(saved *MessageTemplate) { journalTemplate = saved }(journalTemplate)
+	if journalTemplate, err = ParseMessageTemplate("{{.Hostname}} {{.Tag}}: {{.Message}}"); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := NewSyslogMessage()
+	msg.Parse("<13>1 2026-10-16T12:00:00Z web1 nginx 42 - - GET /", "192.0.2.1:514")
+	SendMessage(msg, nil)
+
+	got, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var object map[string]string
+	if err := json.Unmarshal(got, &object); err != nil {
+		t.Fatalf("Expected a JSON object, got %q", got)
+	}
+	if object["MESSAGE"] != "web1 nginx: GET /" || object["SYSLOG_MESSAGE"] != "GET /" {
+		t.Errorf("Expected the composed MESSAGE and the original SYSLOG_MESSAGE, got %q and %q", object["MESSAGE"], object["SYSLOG_MESSAGE"])
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// No timestamp we'd recognize is longer than this.
+const maxLayoutLength = 64
+
+// parseLayouts tries msg.TimestampLayouts in turn against the start of buf,
+// returning the first timestamp which parses and the rest of buf after the
+// space following it.
+func (msg *SyslogMessage) parseLayouts(buf string) (time.Time, string, bool) {
+	for _, layout := range msg.TimestampLayouts {
+		// Layouts may contain spaces (and padding), so try each space in
+		// turn as the end of the timestamp.
+		for end := 0; end < len(buf) && end <= maxLayoutLength; end++ {
+			if buf[end] != ' ' {
+				continue
+			}
+			ts, err := parseLayout(layout, buf[:end], msg.location())
+			if err != nil {
+				continue
+			}
+			if ts.Year() == 0 {
+				ts = msg.withYear(ts)
+			}
+			return ts, buf[end+1:], true
+		}
+	}
+	return time.Time{}, buf, false
+}
+
+// parseEpochStamp parses a timestamp at the start of buf given as Unix time,
+// as some embedded devices send: ten digits of seconds (with an optional
+// fraction) or thirteen of milliseconds. It returns the rest of buf after the
+// space following it.
+func parseEpochStamp(buf string) (time.Time, string, bool) {
+	stamp, rest, ok := strings.Cut(buf, " ")
+	if !ok {
+		return time.Time{}, buf, false
+	}
+	secs, frac, hasFrac := strings.Cut(stamp, ".")
+	if !isDigits(secs) || hasFrac && !isDigits(frac) {
+		return time.Time{}, buf, false
+	}
+	layout := "unix"
+	switch {
+	case len(secs) == 10:
+	case len(secs) == 13 && !hasFrac:
+		layout = "unixmilli"
+	default:
+		return time.Time{}, buf, false
+	}
+	ts, err := parseLayout(layout, stamp, time.UTC)
+	if err != nil {
+		return time.Time{}, buf, false
+	}
+	return ts, rest, true
+}
+
+// parseLayout parses s as a time.Parse layout, or as a count of seconds (with
+// an optional fraction) or milliseconds since the epoch for the layouts
+// "unix" and "unixmilli". Times without a zone are taken to be in loc.
+func parseLayout(layout string, s string, loc *time.Location) (time.Time, error) {
+	switch layout {
+	case "unix":
+		secs, frac, _ := strings.Cut(s, ".")
+		sec, err := strconv.ParseInt(secs, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		var nsec int64
+		if frac != "" {
+			if !isDigits(frac) {
+				return time.Time{}, strconv.ErrSyntax
+			}
+			if len(frac) > 9 {
+				frac = frac[:9]
+			}
+			nsec, _ = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+		}
+		return time.Unix(sec, nsec).UTC(), nil
+	case "unixmilli":
+		msec, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.UnixMilli(msec).UTC(), nil
+	}
+	return time.ParseInLocation(layout, s, loc)
+}
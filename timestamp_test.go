@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+func TestTimestampLayouts(t *testing.T) {
+	layouts := []string{"2006-01-02 15:04:05", "unixmilli", "unix", "Jan _2 15:04:05.000"}
+	var tests = []struct {
+		buf      string
+		expected time.Time
+		message  string
+	}{
+		{
+			"<13>2015-03-01 12:34:56 host app: message",
+			time.Date(2015, 3, 1, 12, 34, 56, 0, time.UTC),
+			"message",
+		},
+		{
+			"<13>1425213296123 host app: message",
+			time.Date(2015, 3, 1, 12, 34, 56, 123000000, time.UTC),
+			"message",
+		},
+		{
+			"<13>1425213296.5 host app: message",
+			time.Date(2015, 3, 1, 12, 34, 56, 500000000, time.UTC),
+			"message",
+		},
+		{
+			"<13>Mar  1 12:34:56.789 host app: message",
+			time.Date(2015, 3, 1, 12, 34, 56, 789000000, time.UTC),
+			"message",
+		},
+		{
+			"<13>1 2015-03-01 12:34:56 host app - - - message",
+			time.Date(2015, 3, 1, 12, 34, 56, 0, time.UTC),
+			"message",
+		},
+	}
+
+	for num, test := range tests {
+		msg := NewSyslogMessage()
+		msg.clock = clockwork.NewFakeClockAt(time.Date(2015, 3, 10, 0, 0, 0, 0, time.UTC))
+		msg.TimestampLayouts = layouts
+		msg.ParseFormat(test.buf, "127.0.0.1", "")
+		if !msg.Timestamp.Equal(test.expected) || msg.Message != test.message {
+			t.Errorf("Failed test %d:\nOriginal: %q\nExpected: %v %q\n     Got: %v %q", num, test.buf, test.expected, test.message, msg.Timestamp, msg.Message)
+		}
+	}
+}
+
+func TestEpochTimestamps(t *testing.T) {
+	received := time.Date(2015, 3, 10, 0, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		buf      string
+		expected time.Time
+		message  string
+	}{
+		{
+			"<13>1425213296 host app: message",
+			time.Date(2015, 3, 1, 12, 34, 56, 0, time.UTC),
+			"message",
+		},
+		{
+			"<13>1425213296.25 host app: message",
+			time.Date(2015, 3, 1, 12, 34, 56, 250000000, time.UTC),
+			"message",
+		},
+		{
+			"<13>1425213296123 host app: message",
+			time.Date(2015, 3, 1, 12, 34, 56, 123000000, time.UTC),
+			"message",
+		},
+		{
+			"<13>1 1425213296 host app - - - message",
+			time.Date(2015, 3, 1, 12, 34, 56, 0, time.UTC),
+			"message",
+		},
+		{
+			"<13>12345 host app: message",
+			received,
+			"12345 host app: message",
+		},
+		{
+			"<13>1425213296123.5 host app: message",
+			received,
+			"1425213296123.5 host app: message",
+		},
+	}
+
+	for num, test := range tests {
+		msg := NewSyslogMessage()
+		msg.clock = clockwork.NewFakeClockAt(received)
+		msg.Timestamp = received
+		msg.ParseFormat(test.buf, "127.0.0.1", "")
+		if !msg.Timestamp.Equal(test.expected) || msg.Message != test.message {
+			t.Errorf("Failed test %d:\nOriginal: %q\nExpected: %v %q\n     Got: %v %q", num, test.buf, test.expected, test.message, msg.Timestamp, msg.Message)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CredentialPath resolves a certificate or key path. Relative paths are
+// looked up in the directory systemd populates for LoadCredential= (if any),
+// so a unit can simply name the credential, e.g. -tls-cert=syslog.crt.
+func CredentialPath(name string) string {
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" && !filepath.IsAbs(name) {
+		return filepath.Join(dir, name)
+	}
+	return name
+}
+
+// NewTLSConfig builds the server configuration for RFC5425 syslog-over-TLS
+// listeners from a PEM certificate chain and private key. If clientCAFile is
+// given, client certificates are verified against the CAs it contains, and
+// requireClientCert decides whether clients without one are turned away.
+func NewTLSConfig(certFile string, keyFile string, clientCAFile string, requireClientCert bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(CredentialPath(certFile), CredentialPath(keyFile))
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		// RFC5425 mandates TLS 1.2 support; don't go below it.
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if clientCAFile != "" {
+		pem, err := ioutil.ReadFile(CredentialPath(clientCAFile))
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates found in " + clientCAFile)
+		}
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+		if requireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if requireClientCert {
+		return nil, errors.New("requiring client certificates needs a client CA")
+	}
+	return config, nil
+}
+
+// ReloadableTLSConfig keeps the TLS server configuration loaded from a set of
+// files, and can load it again when they change (e.g. when a certificate is
+// renewed). Reloading only affects new connections; established ones carry on
+// with whatever they negotiated.
+type ReloadableTLSConfig struct {
+	certFile          string
+	keyFile           string
+	clientCAFile      string
+	requireClientCert bool
+
+	mu      sync.RWMutex
+	current *tls.Config
+}
+
+// NewReloadableTLSConfig loads the configuration as NewTLSConfig does.
+func NewReloadableTLSConfig(certFile string, keyFile string, clientCAFile string, requireClientCert bool) (*ReloadableTLSConfig, error) {
+	r := &ReloadableTLSConfig{
+		certFile:          certFile,
+		keyFile:           keyFile,
+		clientCAFile:      clientCAFile,
+		requireClientCert: requireClientCert,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the files again. If any of them can't be loaded, the previous
+// configuration stays in use.
+func (r *ReloadableTLSConfig) Reload() error {
+	config, err := NewTLSConfig(r.certFile, r.keyFile, r.clientCAFile, r.requireClientCert)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.current = config
+	r.mu.Unlock()
+	return nil
+}
+
+// Config returns a configuration for TLS listeners which picks up the most
+// recently loaded configuration at the start of each handshake.
+func (r *ReloadableTLSConfig) Config() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+			return r.current, nil
+		},
+	}
+}
+
+// PeerIdentity returns the identity asserted by a verified client
+// certificate: its first DNS subjectAltName, falling back to the subject CN
+// as RFC5425 allows. It returns an empty string for unauthenticated clients.
+func PeerIdentity(state tls.ConnectionState) string {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	cert := state.VerifiedChains[0][0]
+	if len(cert.DNSNames) > 0 {
+		return cert.DNSNames[0]
+	}
+	return cert.Subject.CommonName
+}
+
+// identityFields returns the journal fields recording a verified client's
+// identity: SYSLOG_SOURCE_IDENTITY, and with -tls-identity-source, the
+// identity as SYSLOG_SOURCE and the address it connected from as
+// SYSLOG_SOURCE_ADDRESS. It returns nil for unauthenticated clients.
+func identityFields(state tls.ConnectionState, source string) map[string]string {
+	identity := PeerIdentity(state)
+	if identity == "" {
+		return nil
+	}
+	extra := map[string]string{"SYSLOG_SOURCE_IDENTITY": identity}
+	if *tlsIdentitySource {
+		extra["SYSLOG_SOURCE"] = identity
+		extra["SYSLOG_SOURCE_ADDRESS"] = source
+	}
+	return extra
+}
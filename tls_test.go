@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCert writes a self-signed certificate for name, and its key, to
+// dir as cert.pem and key.pem.
+func writeTestCert(t *testing.T, dir string, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func servedCommonName(t *testing.T, config *tls.Config) string {
+	current, err := config.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(current.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Subject.CommonName
+}
+
+func TestReloadableTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeTestCert(t, dir, "old")
+
+	reloadable, err := NewReloadableTLSConfig(certFile, keyFile, "", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	config := reloadable.Config()
+	if name := servedCommonName(t, config); name != "old" {
+		t.Errorf("Expected certificate for old, got %s", name)
+	}
+
+	writeTestCert(t, dir, "new")
+	if err := reloadable.Reload(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	if name := servedCommonName(t, config); name != "new" {
+		t.Errorf("Expected certificate for new, got %s", name)
+	}
+
+	// A broken renewal leaves the working configuration in place.
+	os.Remove(keyFile)
+	if err := reloadable.Reload(); err == nil {
+		t.Error("Expected an error reloading without a key")
+	}
+	if name := servedCommonName(t, config); name != "new" {
+		t.Errorf("Expected certificate for new, got %s", name)
+	}
+}
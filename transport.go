@@ -0,0 +1,43 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+)
+
+// transportFields returns extra's fields with SYSLOG_TRANSPORT added, naming
+// the transport a message came in over: udp, tcp, tls, unix, sctp or quic.
+// The result is shared by the messages of a socket or connection, like
+// extra itself, and not changed.
+func transportFields(transport string, extra map[string]string) map[string]string {
+	return underlay(map[string]string{"SYSLOG_TRANSPORT": transport}, extra)
+}
+
+// connTransport returns the transport of a stream connection: tls for TLS
+// connections, and otherwise that of its local address.
+func connTransport(conn net.Conn) string {
+	switch c := conn.(type) {
+	case *tls.Conn:
+		return "tls"
+	case *net.TCPConn:
+		// Go takes one-to-one SCTP sockets for TCP.
+		if isSCTPConn(c) {
+			return "sctp"
+		}
+	}
+	return addrTransport(conn.LocalAddr())
+}
+
+// addrTransport returns the transport of a socket bound to addr: its
+// network, with every kind of unix socket simply "unix".
+func addrTransport(addr net.Addr) string {
+	if network := addr.Network(); !strings.HasPrefix(network, "unix") {
+		return network
+	}
+	return "unix"
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestConnTransport(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcp.Close()
+	unixListener, err := net.Listen("unix", filepath.Join(t.TempDir(), "sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unixListener.Close()
+
+	for _, test := range []struct {
+		listener net.Listener
+		tls      bool
+		expected string
+	}{
+		{tcp, false, "tcp"},
+		{tcp, true, "tls"},
+		{unixListener, false, "unix"},
+	} {
+		conn, err := net.Dial(test.listener.Addr().Network(), test.listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if test.tls {
+			conn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+		}
+		if got := connTransport(conn); got != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, got)
+		}
+	}
+
+	if got := addrTransport(&net.UnixAddr{Name: "/dev/log", Net: "unixgram"}); got != "unix" {
+		t.Errorf("Expected unix, got %s", got)
+	}
+	if got := addrTransport(&net.UDPAddr{}); got != "udp" {
+		t.Errorf("Expected udp, got %s", got)
+	}
+}
+
+func TestTransportFields(t *testing.T) {
+	fields := transportFields("udp", truncatedFields)
+	if fields["SYSLOG_TRANSPORT"] != "udp" || fields["SYSLOG_TRUNCATED"] != "1" {
+		t.Errorf("Expected the transport and truncation, got %v", fields)
+	}
+	if _, ok := truncatedFields["SYSLOG_TRANSPORT"]; ok {
+		t.Errorf("Expected truncatedFields to be left alone, got %v", truncatedFields)
+	}
+
+	for _, test := range []struct {
+		source, port string
+	}{
+		{"192.0.2.1:51514", "51514"},
+		{"[2001:db8::1]:514", "514"},
+		{"/run/systemd/journal/syslog", ""},
+		{"", ""},
+	} {
+		msg := NewSyslogMessage()
+		msg.Parse("<13>Dec 15 11:55:02 host app: hello", test.source)
+		if got := msg.Fields()["SYSLOG_SOURCE_PORT"]; got != test.port {
+			t.Errorf("Expected SYSLOG_SOURCE_PORT %q for %q, got %q", test.port, test.source, got)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+// EnablePassCred asks the kernel to attach SCM_CREDENTIALS to every datagram
+// received on a unix socket, whether or not the sender supplied them.
+func EnablePassCred(fd *net.UnixConn) error {
+	raw, err := fd.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = raw.Control(func(s uintptr) {
+		sockErr = syscall.SetsockoptInt(int(s), syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// PeerCredentialFields looks up the credentials of the process at the other
+// end of a unix stream connection (SO_PEERCRED), returning them as the same
+// journal fields CredentialFields produces.
+func PeerCredentialFields(conn *net.UnixConn) map[string]string {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil
+	}
+	var cred *syscall.Ucred
+	err = raw.Control(func(s uintptr) {
+		cred, err = syscall.GetsockoptUcred(int(s), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil || cred == nil {
+		return nil
+	}
+	return credentialFields(cred)
+}
+
+// CredentialFields turns the SCM_CREDENTIALS control message (if any) in oob
+// into journal fields describing the sending process. OBJECT_PID lets
+// journald itself attach the usual OBJECT_* metadata (command line, unit,
+// and so on) for the sender.
+func CredentialFields(oob []byte) map[string]string {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+	for i := range msgs {
+		if cred, err := syscall.ParseUnixCredentials(&msgs[i]); err == nil {
+			return credentialFields(cred)
+		}
+	}
+	return nil
+}
+
+func credentialFields(cred *syscall.Ucred) map[string]string {
+	return map[string]string{
+		"OBJECT_PID":        strconv.Itoa(int(cred.Pid)),
+		"SYSLOG_SOURCE_UID": strconv.Itoa(int(cred.Uid)),
+		"SYSLOG_SOURCE_GID": strconv.Itoa(int(cred.Gid)),
+	}
+}
+
+// HandleUnixgram takes an AF_UNIX SOCK_DGRAM socket (passed in from systemd,
+// like /dev/log) and repeatedly reads new messages from it, handing them off
+// for processing along with the sender's credentials. Local senders rarely
+// bind a name of their own, so the socket's path is used as the source. It
+// returns when the socket is closed.
+func HandleUnixgram(fd *net.UnixConn, config *SocketConfig) {
+	if err := EnablePassCred(fd); err != nil {
+		log.Println(err)
+	}
+	source := fd.LocalAddr().String()
+	// Both buffers are consumed before the next read.
+	buf := make([]byte, config.DatagramSize())
+	oob := make([]byte, syscall.CmsgSpace(syscall.SizeofUcred))
+	for {
+		count, oobCount, flags, _, err := fd.ReadMsgUnix(buf, oob)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Println(err)
+			continue
+		}
+		if count == 0 {
+			continue
+		}
+
+		extra := transportFields("unix", CredentialFields(oob[:oobCount]))
+		if flags&syscall.MSG_TRUNC != 0 {
+			extra = markTruncated(extra)
+		}
+		packet := string(buf[:count])
+		ingestPacket(config, packet, source, extra)
+	}
+}
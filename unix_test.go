@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestCredentialFields(t *testing.T) {
+	oob := syscall.UnixCredentials(&syscall.Ucred{Pid: 1234, Uid: 1000, Gid: 100})
+	expected := map[string]string{
+		"OBJECT_PID":        "1234",
+		"SYSLOG_SOURCE_UID": "1000",
+		"SYSLOG_SOURCE_GID": "100",
+	}
+	if got := CredentialFields(oob); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %v\n     Got: %v", expected, got)
+	}
+	if got := CredentialFields(nil); got != nil {
+		t.Errorf("Expected no fields without credentials, got: %v", got)
+	}
+}
+
+func TestPeerCredentialFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.sock")
+	fd, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err.Error())
+	}
+	defer fd.Close()
+
+	client, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer client.Close()
+	conn, err := fd.AcceptUnix()
+	if err != nil {
+		t.Fatalf("Could not accept: %s", err.Error())
+	}
+	defer conn.Close()
+
+	expected := map[string]string{
+		"OBJECT_PID":        strconv.Itoa(os.Getpid()),
+		"SYSLOG_SOURCE_UID": strconv.Itoa(os.Getuid()),
+		"SYSLOG_SOURCE_GID": strconv.Itoa(os.Getgid()),
+	}
+	if got := PeerCredentialFields(conn); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected: %v\n     Got: %v", expected, got)
+	}
+}
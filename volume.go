@@ -0,0 +1,145 @@
+// Copyright 2015 Ed Marshall. All rights reserved.
+// Use of this source code is governed by a GPL-style
+// license that can be found in the COPYING file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-systemd/journal"
+)
+
+// volumeMessageID is the MESSAGE_ID of volume summaries, so that
+// journalctl MESSAGE_ID=... finds them all.
+const volumeMessageID = "5e0d7a8c4f1b4e2a9c3d6b7e8f901a2b"
+
+// Senders beyond this many in an interval (as a flood from spoofed
+// addresses might bring) are tallied together, rather than each.
+const maxVolumeSources = 4096
+
+// VolumeCounter tallies the messages, bytes and drops from each sender, to
+// be summarized in entries of their own every interval.
+type VolumeCounter struct {
+	mu      sync.Mutex
+	sources map[string]*sourceVolume
+	// others tallies the senders beyond maxVolumeSources.
+	others sourceVolume
+}
+
+type sourceVolume struct {
+	messages int
+	bytes    int
+	dropped  int
+}
+
+// sourceVolumes counts each sender's messages, if -volume-interval is set.
+var sourceVolumes *VolumeCounter
+
+// NewVolumeCounter returns an empty VolumeCounter.
+func NewVolumeCounter() *VolumeCounter {
+	return &VolumeCounter{sources: map[string]*sourceVolume{}}
+}
+
+// volume returns the tally for a sender's address (including a port or not),
+// with the lock held.
+func (v *VolumeCounter) volume(source string) *sourceVolume {
+	host := sourceHost(source)
+	volume, ok := v.sources[host]
+	if !ok {
+		if len(v.sources) >= maxVolumeSources {
+			return &v.others
+		}
+		volume = &sourceVolume{}
+		v.sources[host] = volume
+	}
+	return volume
+}
+
+// Count records a message of size bytes from source.
+func (v *VolumeCounter) Count(source string, size int) {
+	if v == nil || source == "" {
+		return
+	}
+	v.mu.Lock()
+	volume := v.volume(source)
+	volume.messages++
+	volume.bytes += size
+	v.mu.Unlock()
+}
+
+// Drop records a message from source which didn't make it to journald,
+// whether rejected, rate limited or refused.
+func (v *VolumeCounter) Drop(source string) {
+	if v == nil || source == "" {
+		return
+	}
+	v.mu.Lock()
+	v.volume(source).dropped++
+	v.mu.Unlock()
+}
+
+// Entries returns a summary entry for each sender heard from since the last
+// call, which covered the interval given, in order of address, followed by
+// one for any senders beyond maxVolumeSources, together.
+func (v *VolumeCounter) Entries(interval time.Duration) []*journalEntry {
+	v.mu.Lock()
+	sources, others := v.sources, v.others
+	v.sources, v.others = map[string]*sourceVolume{}, sourceVolume{}
+	v.mu.Unlock()
+
+	hosts := make([]string, 0, len(sources))
+	for host := range sources {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	entries := make([]*journalEntry, 0, len(hosts)+1)
+	summarize := func(name string, volume *sourceVolume, source string) {
+		vars := map[string]string{
+			"SYSLOG_IDENTIFIER":      "journald-syslog",
+			"MESSAGE_ID":             volumeMessageID,
+			"SYSLOG_VOLUME_MESSAGES": strconv.Itoa(volume.messages),
+			"SYSLOG_VOLUME_BYTES":    strconv.Itoa(volume.bytes),
+			"SYSLOG_VOLUME_DROPPED":  strconv.Itoa(volume.dropped),
+			"SYSLOG_VOLUME_INTERVAL": strconv.FormatFloat(interval.Seconds(), 'f', -1, 64),
+		}
+		if source != "" {
+			vars["SYSLOG_SOURCE"] = source
+		}
+		entries = append(entries, &journalEntry{
+			*journalNamespace,
+			fmt.Sprintf("%s sent %d messages (%d bytes) in the last %s, %d dropped", name, volume.messages, volume.bytes, interval, volume.dropped),
+			journal.PriInfo,
+			vars,
+			nil,
+		})
+	}
+	for _, host := range hosts {
+		summarize(host, sources[host], host)
+	}
+	if others != (sourceVolume{}) {
+		summarize("other senders", &others, "")
+	}
+	return entries
+}
+
+// Report sends the summaries with send every interval, until stop is closed.
+func (v *VolumeCounter) Report(interval time.Duration, send func(*journalEntry), stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		for _, entry := range v.Entries(interval) {
+			send(entry)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestVolumeCounter(t *testing.T) {
+	volumes := NewVolumeCounter()
+	volumes.Count("192.0.2.1:514", 100)
+	volumes.Count("192.0.2.1:40000", 50)
+	volumes.Drop("192.0.2.1:40000")
+	volumes.Count("[2001:db8::1]:514", 10)
+	volumes.Count("", 10)
+
+	entries := volumes.Entries(time.Minute)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	var tests = []struct {
+		message  string
+		source   string
+		messages string
+		bytes    string
+		dropped  string
+	}{
+		{"192.0.2.1 sent 2 messages (150 bytes) in the last 1m0s, 1 dropped", "192.0.2.1", "2", "150", "1"},
+		{"2001:db8::1 sent 1 messages (10 bytes) in the last 1m0s, 0 dropped", "2001:db8::1", "1", "10", "0"},
+	}
+	for num, test := range tests {
+		entry := entries[num]
+		if entry.message != test.message || entry.vars["SYSLOG_SOURCE"] != test.source ||
+			entry.vars["SYSLOG_VOLUME_MESSAGES"] != test.messages || entry.vars["SYSLOG_VOLUME_BYTES"] != test.bytes ||
+			entry.vars["SYSLOG_VOLUME_DROPPED"] != test.dropped {
+			t.Errorf("Failed test %d: got %q, %v", num, entry.message, entry.vars)
+		}
+		if entry.vars["MESSAGE_ID"] != volumeMessageID || entry.vars["SYSLOG_VOLUME_INTERVAL"] != "60" {
+			t.Errorf("Failed test %d: expected the MESSAGE_ID and interval, got %v", num, entry.vars)
+		}
+	}
+
+	// Each interval starts afresh, and quiet senders get no entry.
+	if entries := volumes.Entries(time.Minute); len(entries) != 0 {
+		t.Errorf("Expected no entries, got %d", len(entries))
+	}
+
+	// Senders beyond the limit are tallied together.
+	for i := 0; i < maxVolumeSources+2; i++ {
+		volumes.Count(fmt.Sprintf("10.%d.%d.%d:514", i>>16, i>>8&0xff, i&0xff), 1)
+	}
+	volumes.Drop("192.0.2.1:514")
+	entries = volumes.Entries(time.Minute)
+	if len(entries) != maxVolumeSources+1 {
+		t.Fatalf("Expected %d entries, got %d", maxVolumeSources+1, len(entries))
+	}
+	if others := entries[maxVolumeSources]; others.message != "other senders sent 2 messages (2 bytes) in the last 1m0s, 1 dropped" || others.vars["SYSLOG_SOURCE"] != "" {
+		t.Errorf("Expected the others' summary, got %q, %v", others.message, others.vars)
+	}
+
+	var none *VolumeCounter
+	none.Count("192.0.2.1:514", 1)
+	none.Drop("192.0.2.1:514")
+}